@@ -1,21 +1,33 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io/fs"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
 	assets "wyapi-golang"
 	"wyapi-golang/internal/api"
+	"wyapi-golang/internal/auth"
 	"wyapi-golang/internal/config"
 	"wyapi-golang/internal/cookie"
+	"wyapi-golang/internal/cover"
 	"wyapi-golang/internal/downloader"
+	"wyapi-golang/internal/jobs"
+	"wyapi-golang/internal/library"
 	"wyapi-golang/internal/netease"
+	"wyapi-golang/internal/ratelimit"
+	"wyapi-golang/internal/scheduler"
+	"wyapi-golang/internal/subscription"
+	"wyapi-golang/internal/transcode"
 
 	httpSwagger "github.com/swaggo/http-swagger"
 )
@@ -37,8 +49,175 @@ func main() {
 		logger.Error("failed to ensure cookie file", slog.String("error", err.Error()))
 	}
 
-	neteaseClient := netease.NewClient(time.Duration(cfg.Server.RequestTimeoutSeconds) * time.Second)
+	// Background work (cookie watching, syncs, scheduled tasks, download
+	// jobs) stops with ctx on SIGINT or SIGTERM.
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	go cookieManager.Watch(ctx, time.Duration(cfg.Cookie.WatchIntervalSeconds)*time.Second, func(err error) {
+		if err != nil {
+			logger.Warn("failed to reload cookie file", slog.String("error", err.Error()))
+			return
+		}
+		logger.Info("cookie file reloaded")
+	})
+
+	neteaseClient, err := netease.NewClientWithOptions(netease.ClientOptions{
+		Timeout:       time.Duration(cfg.Server.RequestTimeoutSeconds) * time.Second,
+		APIProxy:      firstNonEmpty(cfg.Upstream.Proxy.API, cfg.Upstream.Proxy.URL),
+		DownloadProxy: firstNonEmpty(cfg.Upstream.Proxy.Download, cfg.Upstream.Proxy.URL),
+		RealIP:        cfg.Upstream.RealIP,
+		Transport: netease.TransportOptions{
+			MaxIdleConns:        cfg.Upstream.Transport.MaxIdleConns,
+			MaxIdleConnsPerHost: cfg.Upstream.Transport.MaxIdleConnsPerHost,
+			MaxConnsPerHost:     cfg.Upstream.Transport.MaxConnsPerHost,
+			IdleConnTimeout:     time.Duration(cfg.Upstream.Transport.IdleConnTimeoutSeconds) * time.Second,
+			KeepAlive:           time.Duration(cfg.Upstream.Transport.KeepAliveSeconds) * time.Second,
+			TLSHandshakeTimeout: time.Duration(cfg.Upstream.Transport.TLSHandshakeTimeoutSeconds) * time.Second,
+			ReadIdleTimeout:     time.Duration(cfg.Upstream.Transport.ReadIdleTimeoutSeconds) * time.Second,
+			InsecureSkipVerify:  cfg.Upstream.Transport.InsecureSkipVerify,
+			MinTLSVersion:       cfg.Upstream.Transport.MinTLSVersion,
+		},
+	})
+	if err != nil {
+		logger.Error("invalid upstream config", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	neteaseClient.OnSetCookie(func(reqCtx context.Context, cookies []*http.Cookie) {
+		if _, ok := cookie.OverrideFromContext(reqCtx); ok {
+			return
+		}
+		updated, err := cookieManager.Merge(cookies)
+		if err != nil {
+			logger.Warn("failed to persist refreshed cookies", slog.String("error", err.Error()))
+			return
+		}
+		if updated {
+			logger.Info("cookie file updated from upstream Set-Cookie")
+		}
+	})
+	profiles, err := netease.LoadProfiles(cfg.Upstream.IdentityFile)
+	if err != nil {
+		logger.Error("failed to load device identity", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	if err := neteaseClient.SetProfiles(profiles, cfg.Upstream.Profile); err != nil {
+		logger.Error("invalid upstream profile", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	neteaseClient.SetRetryPolicy(netease.RetryPolicy{
+		MaxRetries: cfg.Upstream.MaxRetries,
+		BaseDelay:  time.Duration(cfg.Upstream.BackoffBaseMs) * time.Millisecond,
+		MaxDelay:   time.Duration(cfg.Upstream.BackoffMaxMs) * time.Millisecond,
+	})
+	neteaseClient.SetBreaker(netease.NewBreaker(cfg.Upstream.BreakerThreshold, time.Duration(cfg.Upstream.BreakerCooldownSeconds)*time.Second))
+	if cfg.RateLimit.Enabled && cfg.RateLimit.Upstream.RequestsPerSecond > 0 {
+		neteaseClient.SetLimiter(ratelimit.New(cfg.RateLimit.Upstream.RequestsPerSecond, cfg.RateLimit.Upstream.Burst, 0))
+	}
 	downloaderSvc := downloader.NewDownloader(neteaseClient, cookieManager, cfg.Download.Dir)
+	filenameTemplate, err := downloader.ParseTemplate(cfg.Download.FilenameTemplate)
+	if err != nil {
+		logger.Error("invalid download filename template", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	downloaderSvc.SetTemplate(filenameTemplate)
+	pinned := make(map[int64]bool, len(cfg.Download.Retention.Pinned))
+	for _, id := range cfg.Download.Retention.Pinned {
+		pinned[id] = true
+	}
+	sidecar := downloader.Sidecar{
+		Lyrics:    cfg.Download.Sidecars.Lyrics,
+		Cover:     cfg.Download.Sidecars.Cover,
+		CoverSize: cfg.Download.Sidecars.CoverSize,
+		Metadata:  cfg.Download.Sidecars.Metadata,
+	}
+	if err := sidecar.Validate(); err != nil {
+		logger.Error("invalid download sidecar config", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	downloaderSvc.SetSidecar(sidecar)
+	if cfg.Download.Transcode.FFmpegPath != "" {
+		transcoder := transcode.New(cfg.Download.Transcode.FFmpegPath, cfg.Download.Transcode.MaxConcurrent)
+		if !transcoder.Available() {
+			logger.Warn("ffmpeg not found, downloads will not be transcoded", slog.String("path", cfg.Download.Transcode.FFmpegPath))
+		}
+		downloaderSvc.SetTranscoder(transcoder)
+	}
+	downloaderSvc.SetReplayGain(cfg.Download.ReplayGain)
+	if cfg.Download.ReplayGain && !downloaderSvc.CanTranscode() {
+		logger.Warn("replaygain needs ffmpeg, downloads will not be analyzed")
+	}
+	if cfg.Library.Enabled {
+		lib, err := library.Open(cfg.Download.Dir, cfg.Library.IndexFile)
+		if err != nil {
+			logger.Error("failed to open library index", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		downloaderSvc.SetLibrary(lib)
+		go func() {
+			added, removed, err := lib.Scan()
+			if err != nil {
+				logger.Warn("library scan failed", slog.String("error", err.Error()))
+				return
+			}
+			logger.Info("library scanned", slog.Int("added", added), slog.Int("removed", removed))
+		}()
+	}
+
+	if !cfg.Library.Enabled && (cfg.Download.Retention.MaxSizeMB > 0 || cfg.Download.Retention.MaxAgeDays > 0) {
+		logger.Warn("download.retention max_size_mb and max_age_days need the library index; enable library to apply them")
+	}
+
+	subscriptions, err := subscription.NewManager(cfg.Subscriptions.File, neteaseClient, downloaderSvc, cookieManager, cfg.Subscriptions.HistoryLimit)
+	if err != nil {
+		logger.Error("failed to load subscriptions", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	// Set once the subscriptions are loaded, so retention spares the
+	// folders they mirror.
+	downloaderSvc.SetRetention(downloader.Retention{
+		Policy: library.Policy{
+			MaxBytes:  cfg.Download.Retention.MaxSizeMB * 1024 * 1024,
+			MaxAge:    time.Duration(cfg.Download.Retention.MaxAgeDays) * 24 * time.Hour,
+			PinnedIDs: pinned,
+			Keep:      subscriptions.Owns,
+		},
+		MinFreeBytes: cfg.Download.Retention.MinFreeMB * 1024 * 1024,
+	})
+	if cfg.Subscriptions.Enabled {
+		go subscriptions.Run(ctx, time.Duration(cfg.Subscriptions.IntervalMinutes)*time.Minute, func(result subscription.SyncResult) {
+			if result.Error != "" {
+				logger.Warn("playlist sync failed", slog.Int64("playlist_id", result.PlaylistID), slog.String("error", result.Error))
+				return
+			}
+			logger.Info("playlist synced", slog.Int64("playlist_id", result.PlaylistID), slog.Int("added", result.Added), slog.Int("removed", result.Removed), slog.Int("failed", len(result.Failed)))
+		})
+	}
+
+	coverDir := cfg.Covers.CacheDir
+	if !filepath.IsAbs(coverDir) {
+		coverDir = filepath.Join(cfg.Download.Dir, coverDir)
+	}
+	covers := cover.NewCache(coverDir, time.Duration(cfg.Covers.CacheDays)*24*time.Hour, cfg.Covers.MaxCacheMB*1024*1024, neteaseClient, cookieManager)
+
+	tasks := scheduler.New()
+	taskDeps := scheduler.Deps{Client: neteaseClient, Downloader: downloaderSvc, Cookies: cookieManager, Covers: covers}
+	for _, taskCfg := range cfg.Scheduler.Tasks {
+		if taskCfg.Disabled {
+			continue
+		}
+		fn, err := scheduler.BuildTask(taskCfg, taskDeps)
+		if err == nil {
+			err = tasks.Add(taskCfg.Name, taskCfg.Type, taskCfg.Schedule, fn)
+		}
+		if err != nil {
+			logger.Error("invalid scheduled task", slog.String("task", taskCfg.Name), slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+	}
+	if cfg.Scheduler.Enabled {
+		tasks.Start(ctx, logTaskResult(logger))
+	}
 
 	openAPIData, _ := fs.ReadFile(assets.OpenAPI, "docs/openapi.json")
 
@@ -54,7 +233,34 @@ func main() {
 
 	swaggerHandler := httpSwagger.Handler(httpSwagger.URL("/openapi.json"))
 
-	handler := api.NewHandler(cfg, neteaseClient, cookieManager, downloaderSvc, openAPIData)
+	tokens, err := auth.NewRegistry(cfg.Security)
+	if err != nil {
+		logger.Error("invalid api token config", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	if err := tokens.LoadUsage(cfg.Security.UsageFile); err != nil {
+		logger.Error("failed to load api token usage", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	go tokens.PersistUsage(ctx, time.Minute, func(err error) {
+		logger.Warn("failed to save api token usage", slog.String("error", err.Error()))
+	})
+
+	signer, err := auth.NewSigner(cfg.Security.SigningKey)
+	if err != nil {
+		logger.Error("invalid signing key", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	queue := jobs.NewQueue(cfg.Download.Jobs.Workers, cfg.Download.Jobs.QueueSize)
+	go queue.Run(ctx)
+
+	if _, err := api.ParseTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		logger.Error("invalid server config", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	handler := api.NewHandler(cfg, neteaseClient, cookieManager, downloaderSvc, subscriptions, tasks, covers, tokens, signer, queue, openAPIData)
 	router := api.NewRouter(handler, cfg, staticHandler, swaggerHandler)
 
 	addr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port)
@@ -72,16 +278,60 @@ func main() {
 	} else if cfg.Security.APIToken != "" {
 		logger.Info("api token loaded", slog.String("api_token", cfg.Security.APIToken))
 	}
+	if len(cfg.Security.Tokens) > 0 {
+		logger.Info("named api tokens loaded", slog.Int("count", len(cfg.Security.Tokens)))
+	}
 
 	if cfg.Security.RequireToken {
 		logger.Info("api token required for protected routes")
 	} else {
 		logger.Warn("api token enforcement is disabled; enable require_token in config.json for security")
 	}
+	if !tokens.HasScope(auth.ScopeAdmin) {
+		logger.Warn("no admin api token configured; admin routes are disabled")
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		logger.Info("shutting down")
+		shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancelShutdown()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logger.Warn("server shutdown", slog.String("error", err.Error()))
+		}
+		// In-flight requests are done; keep their usage.
+		if err := tokens.SaveUsage(); err != nil {
+			logger.Warn("failed to save api token usage", slog.String("error", err.Error()))
+		}
+	}()
 
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	err = server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
 		logger.Error("server stopped", slog.String("error", err.Error()))
+		return
+	}
+	<-stopped
+}
+
+func logTaskResult(logger *slog.Logger) func(string, scheduler.RunResult) {
+	return func(name string, result scheduler.RunResult) {
+		if result.Error != "" {
+			logger.Warn("scheduled task failed", slog.String("task", name), slog.String("result", result.Result), slog.String("error", result.Error))
+			return
+		}
+		logger.Info("scheduled task finished", slog.String("task", name), slog.String("result", result.Result))
+	}
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			return strings.TrimSpace(value)
+		}
 	}
+	return ""
 }
 
 func parseLogLevel(level string) slog.Level {
@@ -0,0 +1,11 @@
+package api
+
+import (
+	"net/http"
+
+	"wyapi-golang/pkg/response"
+)
+
+func (h *Handler) AdminTokens(w http.ResponseWriter, r *http.Request) {
+	response.Success(w, h.tokens.Status(), "获取API Token用量成功")
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"wyapi-golang/internal/cover"
+	"wyapi-golang/internal/netease"
+	"wyapi-golang/pkg/response"
+)
+
+// coverMaxAge is the browser cache lifetime of a proxied cover; covers
+// practically never change for a given album.
+const coverMaxAge = "public, max-age=604800"
+
+func (h *Handler) Cover(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		response.Error(w, http.StatusBadRequest, "无效的ID")
+		return
+	}
+
+	size := parseInt(r.URL.Query().Get("size"), 0)
+	maxSize := 2000
+	if h.cfg != nil && h.cfg.Covers.MaxSize > 0 {
+		maxSize = h.cfg.Covers.MaxSize
+	}
+	if size < 0 || size > maxSize {
+		response.Error(w, http.StatusBadRequest, "size 必须在 0-"+strconv.Itoa(maxSize)+" 之间")
+		return
+	}
+
+	image, err := h.covers.Get(r.Context(), chi.URLParam(r, "kind"), id, size, strings.ToLower(r.URL.Query().Get("format")))
+	if err != nil {
+		switch {
+		case errors.Is(err, cover.ErrUnknownKind):
+			response.Error(w, http.StatusBadRequest, "无效的类型，支持: album, song")
+		case errors.Is(err, cover.ErrUnknownFormat):
+			response.Error(w, http.StatusBadRequest, "无效的格式，支持: jpg, webp")
+		case errors.Is(err, cover.ErrNoCover):
+			response.ErrorWithCode(w, http.StatusNotFound, string(netease.KindNotFound), "没有可用的封面")
+		default:
+			writeUpstreamError(w, err)
+		}
+		return
+	}
+
+	file, err := os.Open(image.Path)
+	if err != nil {
+		response.Error(w, http.StatusInternalServerError, "读取封面失败")
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", image.ContentType)
+	w.Header().Set("ETag", image.ETag)
+	w.Header().Set("Cache-Control", coverMaxAge)
+	http.ServeContent(w, r, "", image.ModTime, file)
+}
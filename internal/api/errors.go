@@ -0,0 +1,42 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"wyapi-golang/internal/downloader"
+	"wyapi-golang/internal/netease"
+	"wyapi-golang/pkg/response"
+)
+
+var upstreamErrorStatus = map[netease.ErrorKind]int{
+	netease.KindNotFound:      http.StatusNotFound,
+	netease.KindVIPRequired:   http.StatusPaymentRequired,
+	netease.KindRegionBlocked: http.StatusForbidden,
+	netease.KindCookieExpired: http.StatusUnauthorized,
+	netease.KindTimeout:       http.StatusGatewayTimeout,
+	netease.KindRateLimited:   http.StatusServiceUnavailable,
+	netease.KindDegraded:      http.StatusServiceUnavailable,
+	netease.KindUpstream:      http.StatusBadGateway,
+}
+
+// writeUpstreamError reports a failure from the NetEase client or downloader
+// with an HTTP status and error_code derived from its netease.ErrorKind.
+func writeUpstreamError(w http.ResponseWriter, err error) {
+	if errors.Is(err, downloader.ErrInsufficientSpace) {
+		response.ErrorWithCode(w, http.StatusInsufficientStorage, "insufficient_storage", "磁盘空间不足，已拒绝下载")
+		return
+	}
+	if errors.Is(err, downloader.ErrTruncated) {
+		response.ErrorWithCode(w, http.StatusBadGateway, string(netease.KindUpstream), "下载的文件不完整，请重试")
+		return
+	}
+
+	kind := netease.KindOf(err)
+	status, ok := upstreamErrorStatus[kind]
+	if !ok {
+		response.ErrorWithCode(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	response.ErrorWithCode(w, status, string(kind), err.Error())
+}
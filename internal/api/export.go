@@ -0,0 +1,196 @@
+package api
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+
+	"wyapi-golang/internal/auth"
+	"wyapi-golang/internal/downloader"
+	"wyapi-golang/internal/netease"
+	"wyapi-golang/internal/playlist"
+	"wyapi-golang/pkg/response"
+)
+
+// Track locations an export can point at.
+const (
+	exportLinkStream = "stream"
+	exportLinkSigned = "signed"
+	exportLinkLocal  = "local"
+)
+
+// PlaylistExport sends a playlist or album as an M3U8, XSPF, CSV or JSON
+// file whose tracks point at stream URLs, signed download links or files
+// in the local library.
+func (h *Handler) PlaylistExport(w http.ResponseWriter, r *http.Request) {
+	data := parseRequestData(r)
+	idInput := firstNonEmpty(data, "id", "url")
+	if idInput == "" {
+		response.Error(w, http.StatusBadRequest, "缺少歌单ID")
+		return
+	}
+
+	format := playlist.FormatM3U8
+	if name := firstNonEmpty(data, "format"); name != "" {
+		parsed, err := playlist.ParseFormat(name)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, "无效的格式参数，支持: m3u8, xspf, csv, json")
+			return
+		}
+		format = parsed
+	}
+
+	kind := firstNonEmpty(data, "type")
+	if kind == "" {
+		kind = "playlist"
+	}
+	if kind != "playlist" && kind != "album" {
+		response.Error(w, http.StatusBadRequest, "无效的类型参数，支持: playlist, album")
+		return
+	}
+
+	// With require_token a bare /stream URL is refused, so the list would
+	// not play; signed links are the default there instead.
+	requireToken := h.cfg != nil && h.cfg.Security.RequireToken
+	link := firstNonEmpty(data, "link")
+	if link == "" {
+		link = exportLinkStream
+		if requireToken {
+			link = exportLinkSigned
+		}
+	}
+	switch link {
+	case exportLinkStream:
+		if requireToken {
+			response.Error(w, http.StatusBadRequest, "已启用 API Token 校验，stream 链接无法直接播放，请使用 link=signed 或 local")
+			return
+		}
+	case exportLinkSigned:
+		if h.signer == nil {
+			response.Error(w, http.StatusServiceUnavailable, "未配置签名密钥")
+			return
+		}
+		// Signed links grant downloads, which the read scope alone does not.
+		if !hasScope(h.cfg, r, auth.ScopeDownload) {
+			response.Error(w, http.StatusForbidden, "API Token权限不足")
+			return
+		}
+	case exportLinkLocal:
+		if h.localLibrary() == nil {
+			response.Error(w, http.StatusNotFound, "本地曲库未启用")
+			return
+		}
+	default:
+		response.Error(w, http.StatusBadRequest, "无效的 link 参数，支持: stream, signed, local")
+		return
+	}
+
+	quality := firstNonEmpty(data, "quality", "level")
+	if quality == "" {
+		quality = defaultQuality
+	}
+	if !netease.IsValidLevel(quality) {
+		response.Error(w, http.StatusBadRequest, "无效的音质参数")
+		return
+	}
+
+	id, err := h.extractID(r.Context(), idInput)
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	cookies := h.loadCookies(r.Context())
+	var list *playlist.Playlist
+	if kind == "album" {
+		detail, err := h.netease.GetAlbumDetail(r.Context(), id, cookies)
+		if err != nil {
+			writeUpstreamError(w, err)
+			return
+		}
+		list = exportList(detail.Name, detail.Artist, cmp.Or(detail.CoverImgURL, detail.PicURL), detail.Tracks)
+	} else {
+		detail, err := h.netease.GetPlaylistDetail(r.Context(), id, cookies)
+		if err != nil {
+			writeUpstreamError(w, err)
+			return
+		}
+		list = exportList(detail.Name, detail.Creator, cmp.Or(detail.CoverImgURL, detail.PicURL), detail.Tracks)
+	}
+
+	base := requestBaseURL(r)
+	ttl := h.linkTTL(data)
+	for i := range list.Tracks {
+		track := &list.Tracks[i]
+		switch link {
+		case exportLinkStream:
+			query := url.Values{"id": {strconv.FormatInt(track.ID, 10)}, "quality": {quality}}
+			track.Location = base + "/stream?" + query.Encode()
+		case exportLinkSigned:
+			params := url.Values{"id": {strconv.FormatInt(track.ID, 10)}, "quality": {quality}}
+			values, _ := h.signer.Sign(auth.LinkDownload, params, ttl)
+			track.Location = base + "/" + auth.LinkDownload + "?" + values.Encode()
+		case exportLinkLocal:
+			track.Location = h.localTrackPath(track.ID, quality)
+		}
+	}
+
+	var body bytes.Buffer
+	if err := playlist.Write(&body, list, format); err != nil {
+		response.Error(w, http.StatusInternalServerError, "导出失败: "+err.Error())
+		return
+	}
+
+	name := list.Title
+	if name == "" {
+		name = strconv.FormatInt(id, 10)
+	}
+	filename := downloader.SanitizeComponent(name, "windows") + "." + format.Extension()
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename*=UTF-8''%s", url.PathEscape(filename)))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body.Bytes())
+}
+
+// localTrackPath returns where the best copy of songID lives, relative to
+// the download directory so a list saved there plays on any machine that
+// mounts it. Songs that are not in the library have no path.
+func (h *Handler) localTrackPath(songID int64, quality string) string {
+	lib := h.localLibrary()
+	entry, ok := lib.Best(songID, quality)
+	if !ok {
+		// Any copy beats leaving the track out.
+		entry, ok = lib.Best(songID, "")
+	}
+	if !ok {
+		return ""
+	}
+	if rel, err := filepath.Rel(lib.Dir(), entry.Path); err == nil && filepath.IsLocal(rel) {
+		return filepath.ToSlash(rel)
+	}
+	return filepath.ToSlash(entry.Path)
+}
+
+func exportList(title, creator, image string, tracks []netease.TrackInfo) *playlist.Playlist {
+	list := &playlist.Playlist{
+		Title:   title,
+		Creator: creator,
+		Image:   image,
+		Tracks:  make([]playlist.Track, 0, len(tracks)),
+	}
+	for _, track := range tracks {
+		list.Tracks = append(list.Tracks, playlist.Track{
+			ID:         track.ID,
+			Title:      track.Name,
+			Artists:    track.Artists,
+			Album:      track.Album,
+			DurationMs: track.Duration,
+			Image:      track.PicURL,
+		})
+	}
+	return list
+}
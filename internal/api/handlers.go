@@ -1,6 +1,7 @@
 package api
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -10,15 +11,23 @@ import (
 	"math"
 	"net/http"
 	"net/url"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
+	"wyapi-golang/internal/auth"
 	"wyapi-golang/internal/config"
 	"wyapi-golang/internal/cookie"
+	"wyapi-golang/internal/cover"
 	"wyapi-golang/internal/downloader"
+	"wyapi-golang/internal/jobs"
+	"wyapi-golang/internal/lyrics"
 	"wyapi-golang/internal/netease"
+	"wyapi-golang/internal/probe"
+	"wyapi-golang/internal/scheduler"
+	"wyapi-golang/internal/subscription"
 	"wyapi-golang/pkg/response"
 )
 
@@ -29,21 +38,35 @@ var (
 	songIDPattern   = regexp.MustCompile(`(?i)(song|album|playlist)[^\d]*(\d+)`)
 )
 
-type Handler struct {
-	cfg        *config.Config
-	netease    *netease.Client
-	cookies    *cookie.Manager
-	downloader *downloader.Downloader
-	openAPI    []byte
-}
+const defaultQuality = "lossless"
 
-func NewHandler(cfg *config.Config, neteaseClient *netease.Client, cookieManager *cookie.Manager, downloader *downloader.Downloader, openAPI []byte) *Handler {
+type Handler struct {
+	cfg           *config.Config
+	netease       *netease.Client
+	cookies       *cookie.Manager
+	downloader    *downloader.Downloader
+	subscriptions *subscription.Manager
+	scheduler     *scheduler.Scheduler
+	covers        *cover.Cache
+	tokens        *auth.Registry
+	signer        *auth.Signer
+	queue         *jobs.Queue
+	openAPI       []byte
+}
+
+func NewHandler(cfg *config.Config, neteaseClient *netease.Client, cookieManager *cookie.Manager, downloader *downloader.Downloader, subscriptions *subscription.Manager, scheduler *scheduler.Scheduler, covers *cover.Cache, tokens *auth.Registry, signer *auth.Signer, queue *jobs.Queue, openAPI []byte) *Handler {
 	return &Handler{
-		cfg:        cfg,
-		netease:    neteaseClient,
-		cookies:    cookieManager,
-		downloader: downloader,
-		openAPI:    openAPI,
+		cfg:           cfg,
+		netease:       neteaseClient,
+		cookies:       cookieManager,
+		downloader:    downloader,
+		subscriptions: subscriptions,
+		scheduler:     scheduler,
+		covers:        covers,
+		tokens:        tokens,
+		signer:        signer,
+		queue:         queue,
+		openAPI:       openAPI,
 	}
 }
 
@@ -54,10 +77,19 @@ func (h *Handler) Health(w http.ResponseWriter, r *http.Request) {
 		cookieValid = err == nil && len(parsed) > 0
 	}
 
+	breaker := h.netease.Health()
+	upstream := "ok"
+	if breaker.State != netease.BreakerClosed {
+		upstream = "upstream degraded"
+	}
+
 	data := map[string]interface{}{
 		"service":       "running",
 		"timestamp":     time.Now().Unix(),
 		"cookie_status": map[bool]string{true: "valid", false: "invalid"}[cookieValid],
+		"upstream":      upstream,
+		"breaker":       breaker,
+		"disk":          h.downloader.DiskStatus(),
 		"version":       "2.0.0",
 	}
 	response.Success(w, data, "API服务运行正常")
@@ -69,22 +101,37 @@ func (h *Handler) APIInfo(w http.ResponseWriter, r *http.Request) {
 		"version":     "2.0.0",
 		"description": "提供网易云音乐相关API服务",
 		"endpoints": map[string]string{
-			"/health":             "GET - 健康检查",
-			"/song":               "GET/POST - 获取歌曲信息",
-			"/search":             "GET/POST - 搜索音乐",
-			"/playlist":           "GET/POST - 获取歌单详情",
-			"/album":              "GET/POST - 获取专辑详情",
-			"/download":           "GET/POST - 下载音乐",
-			"/api/info":           "GET - API信息",
-			"/api/music/url":      "GET/POST - 获取歌曲链接",
-			"/api/music/detail":   "GET/POST - 获取歌曲详情",
-			"/api/music/lyric":    "GET/POST - 获取歌词",
-			"/api/music/playlist": "GET/POST - 获取歌单详情",
-			"/api/music/album":    "GET/POST - 获取专辑详情",
-		},
-		"supported_qualities": []string{
-			"standard", "exhigh", "lossless", "hires", "sky", "jyeffect", "jymaster", "dolby",
+			"/health":                    "GET - 健康检查",
+			"/song":                      "GET/POST - 获取歌曲信息",
+			"/search":                    "GET/POST - 搜索音乐",
+			"/playlist":                  "GET/POST - 获取歌单详情",
+			"/album":                     "GET/POST - 获取专辑详情",
+			"/download":                  "GET/POST - 下载音乐",
+			"/stream":                    "GET - 在线播放音乐（支持Range）",
+			"/api/sign":                  "GET/POST - 生成带签名的限时下载/播放链接",
+			"/api/download/preview":      "GET/POST - 预览下载文件的保存路径",
+			"/api/download/batch":        "POST - 批量下载歌曲（ids），返回可查询进度的任务",
+			"/api/download/jobs/{id}":    "GET - 查询批量下载任务进度，POST /api/download/jobs/{id}/cancel 取消任务",
+			"/api/info":                  "GET - API信息",
+			"/api/music/url":             "GET/POST - 获取歌曲链接（支持 fallback 备选音质）",
+			"/api/music/qualities":       "GET/POST - 查询歌曲可用音质",
+			"/api/music/detail":          "GET/POST - 获取歌曲详情",
+			"/api/music/lyric":           "GET/POST - 获取歌词，format 可转换为 lrc/srt/vtt/ass/ttml/txt，merge=true 合并翻译与罗马音，words=true 获取逐字歌词，download=true 下载文件",
+			"/api/music/playlist":        "GET/POST - 获取歌单详情",
+			"/api/music/album":           "GET/POST - 获取专辑详情",
+			"/api/music/playlist/export": "GET/POST - 导出歌单或专辑（type=album）为 m3u8/xspf/csv/json，link 指定 stream/signed/local 链接",
+			"/api/music/playlist/import": "POST - 导入其他平台导出的 CSV/文本歌单并匹配网易云歌曲，匹配结果的 ids 可提交到 /api/download/batch 下载",
+			"/api/library":               "GET/POST - 本地曲库列表与搜索",
+			"/api/library/delete":        "POST - 删除本地曲库中的歌曲（admin）",
+			"/api/cover":                 "GET /api/cover/{album|song}/{id} - 封面代理，size 指定边长，format=webp 输出 WebP",
+			"/api/library/pin":           "POST - 固定/取消固定歌曲，固定的歌曲不会被清理（admin）",
+			"/api/library/scan":          "POST - 重新扫描下载目录（admin）",
+			"/api/library/verify":        "GET/POST - 校验曲库文件完整性与格式，fix=true 修正错误的扩展名（admin）",
+			"/api/scheduler/tasks":       "GET - 定时任务列表与上次运行结果（admin）",
+			"/api/scheduler/run":         "POST - 立即运行定时任务（admin）",
+			"/api/admin/tokens":          "GET - API Token用量（admin）",
 		},
+		"supported_qualities": netease.Levels,
 	}
 
 	response.Success(w, data, "API信息获取成功")
@@ -110,7 +157,12 @@ func (h *Handler) Song(w http.ResponseWriter, r *http.Request) {
 
 	quality := firstNonEmpty(data, "level", "quality")
 	if quality == "" {
-		quality = "lossless"
+		quality = defaultQuality
+	}
+	levels, err := qualityChain(data, quality)
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, err.Error())
+		return
 	}
 
 	infoType := firstNonEmpty(data, "type")
@@ -126,13 +178,13 @@ func (h *Handler) Song(w http.ResponseWriter, r *http.Request) {
 
 	switch infoType {
 	case "url":
-		h.handleSongURL(w, r, songID, quality)
+		h.handleSongURL(w, r, songID, levels)
 	case "name":
 		h.handleSongName(w, r, songID)
 	case "lyric":
-		h.handleSongLyric(w, r, songID)
+		h.handleSongLyric(w, r, songID, data)
 	case "json":
-		h.handleSongJSON(w, r, songID, quality)
+		h.handleSongJSON(w, r, songID, levels)
 	default:
 		response.Error(w, http.StatusBadRequest, "无效的类型参数，支持: url, name, lyric, json")
 	}
@@ -148,7 +200,12 @@ func (h *Handler) SongURL(w http.ResponseWriter, r *http.Request) {
 
 	quality := firstNonEmpty(data, "level", "quality")
 	if quality == "" {
-		quality = "lossless"
+		quality = defaultQuality
+	}
+	levels, err := qualityChain(data, quality)
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, err.Error())
+		return
 	}
 
 	songID, err := h.extractID(r.Context(), idInput)
@@ -157,7 +214,7 @@ func (h *Handler) SongURL(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.handleSongURL(w, r, songID, quality)
+	h.handleSongURL(w, r, songID, levels)
 }
 
 func (h *Handler) SongDetail(w http.ResponseWriter, r *http.Request) {
@@ -191,7 +248,36 @@ func (h *Handler) SongLyric(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.handleSongLyric(w, r, songID)
+	h.handleSongLyric(w, r, songID, data)
+}
+
+func (h *Handler) SongQualities(w http.ResponseWriter, r *http.Request) {
+	data := parseRequestData(r)
+	idInput := firstNonEmpty(data, "id", "ids", "url")
+	if idInput == "" {
+		response.Error(w, http.StatusBadRequest, "缺少歌曲ID")
+		return
+	}
+
+	songID, err := h.extractID(r.Context(), idInput)
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	qualities := h.netease.ProbeQualities(r.Context(), songID, h.loadCookies(r.Context()))
+	available := make([]string, 0, len(qualities))
+	for _, item := range qualities {
+		if item.Available {
+			available = append(available, item.Level)
+		}
+	}
+
+	response.Success(w, map[string]interface{}{
+		"id":        songID,
+		"available": available,
+		"qualities": qualities,
+	}, "获取可用音质成功")
 }
 
 func (h *Handler) Playlist(w http.ResponseWriter, r *http.Request) {
@@ -208,10 +294,10 @@ func (h *Handler) Playlist(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cookies := h.loadCookies()
+	cookies := h.loadCookies(r.Context())
 	detail, err := h.netease.GetPlaylistDetail(r.Context(), playlistID, cookies)
 	if err != nil {
-		response.Error(w, http.StatusInternalServerError, err.Error())
+		writeUpstreamError(w, err)
 		return
 	}
 
@@ -232,10 +318,10 @@ func (h *Handler) PlaylistAPI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cookies := h.loadCookies()
+	cookies := h.loadCookies(r.Context())
 	detail, err := h.netease.GetPlaylistDetail(r.Context(), playlistID, cookies)
 	if err != nil {
-		response.Error(w, http.StatusInternalServerError, err.Error())
+		writeUpstreamError(w, err)
 		return
 	}
 
@@ -256,10 +342,10 @@ func (h *Handler) Album(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cookies := h.loadCookies()
+	cookies := h.loadCookies(r.Context())
 	detail, err := h.netease.GetAlbumDetail(r.Context(), albumID, cookies)
 	if err != nil {
-		response.Error(w, http.StatusInternalServerError, err.Error())
+		writeUpstreamError(w, err)
 		return
 	}
 
@@ -280,10 +366,10 @@ func (h *Handler) AlbumAPI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cookies := h.loadCookies()
+	cookies := h.loadCookies(r.Context())
 	detail, err := h.netease.GetAlbumDetail(r.Context(), albumID, cookies)
 	if err != nil {
-		response.Error(w, http.StatusInternalServerError, err.Error())
+		writeUpstreamError(w, err)
 		return
 	}
 
@@ -299,10 +385,10 @@ func (h *Handler) Search(w http.ResponseWriter, r *http.Request) {
 	}
 	limit := parseInt(firstNonEmpty(data, "limit"), 30)
 
-	cookies := h.loadCookies()
+	cookies := h.loadCookies(r.Context())
 	searchResp, err := h.netease.Search(r.Context(), keyword, limit, cookies)
 	if err != nil {
-		response.Error(w, http.StatusInternalServerError, err.Error())
+		writeUpstreamError(w, err)
 		return
 	}
 
@@ -335,10 +421,10 @@ func (h *Handler) NeteaseSearch(w http.ResponseWriter, r *http.Request) {
 	}
 	limit := parseInt(firstNonEmpty(data, "limit"), 20)
 
-	cookies := h.loadCookies()
+	cookies := h.loadCookies(r.Context())
 	searchResp, err := h.netease.Search(r.Context(), keyword, limit, cookies)
 	if err != nil {
-		response.Error(w, http.StatusInternalServerError, err.Error())
+		writeUpstreamError(w, err)
 		return
 	}
 
@@ -366,7 +452,12 @@ func (h *Handler) Download(w http.ResponseWriter, r *http.Request) {
 
 	quality := firstNonEmpty(data, "quality", "level")
 	if quality == "" {
-		quality = "lossless"
+		quality = defaultQuality
+	}
+	levels, err := qualityChain(data, quality)
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, err.Error())
+		return
 	}
 
 	returnFormat := firstNonEmpty(data, "format")
@@ -374,15 +465,30 @@ func (h *Handler) Download(w http.ResponseWriter, r *http.Request) {
 		returnFormat = "file"
 	}
 
+	var tmpl *downloader.Template
+	if raw := firstNonEmpty(data, "template"); raw != "" {
+		tmpl, err = downloader.ParseTemplate(raw)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, "文件名模板无效: "+err.Error())
+			return
+		}
+	}
+
+	target, err := parseTranscodeTarget(data)
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "转码参数无效: "+err.Error())
+		return
+	}
+
 	songID, err := h.extractID(r.Context(), idInput)
 	if err != nil {
 		response.Error(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	info, err := h.downloader.GetMusicInfo(r.Context(), songID, quality)
+	info, err := h.downloader.GetMusicInfoWithFallback(r.Context(), songID, levels)
 	if err != nil {
-		response.Error(w, http.StatusInternalServerError, err.Error())
+		writeUpstreamError(w, err)
 		return
 	}
 
@@ -396,65 +502,150 @@ func (h *Handler) Download(w http.ResponseWriter, r *http.Request) {
 			"file_type": info.FileType,
 			"file_size": info.FileSize,
 			"url":       info.URL,
+			"local":     info.LocalPath != "",
 		}, "下载信息获取成功")
 		return
 	}
 
-	h.sendDownloadFile(w, r, info)
+	if target != nil && downloader.NeedsTranscode(info, *target) {
+		h.sendTranscodedFile(w, r, info, tmpl, *target)
+		return
+	}
+	h.sendDownloadFile(w, r, info, tmpl)
 }
 
-func (h *Handler) handleSongURL(w http.ResponseWriter, r *http.Request, songID int64, quality string) {
-	cookies := h.loadCookies()
-	resp, err := h.netease.GetSongURL(r.Context(), songID, quality, cookies)
+// DownloadPreview renders the path a download would be saved under, using
+// the template parameter or the configured download.filename_template.
+func (h *Handler) DownloadPreview(w http.ResponseWriter, r *http.Request) {
+	data := parseRequestData(r)
+	idInput := firstNonEmpty(data, "id", "url")
+	if idInput == "" {
+		response.Error(w, http.StatusBadRequest, "缺少歌曲ID")
+		return
+	}
+
+	quality := firstNonEmpty(data, "quality", "level")
+	if quality == "" {
+		quality = defaultQuality
+	}
+	if !netease.IsValidLevel(quality) {
+		response.Error(w, http.StatusBadRequest, "无效的音质参数")
+		return
+	}
+
+	var tmpl *downloader.Template
+	if raw := firstNonEmpty(data, "template"); raw != "" {
+		parsed, err := downloader.ParseTemplate(raw)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, "文件名模板无效: "+err.Error())
+			return
+		}
+		tmpl = parsed
+	}
+
+	fileType := strings.TrimPrefix(firstNonEmpty(data, "ext", "file_type"), ".")
+	if fileType == "" {
+		fileType = "flac"
+		if quality == "standard" || quality == "exhigh" {
+			fileType = "mp3"
+		}
+	}
+
+	songID, err := h.extractID(r.Context(), idInput)
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rel, info, err := h.downloader.PreviewPath(r.Context(), songID, quality, fileType, tmpl)
+	if err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+
+	template := h.cfg.Download.FilenameTemplate
+	if tmpl != nil {
+		template = tmpl.String()
+	}
+	response.Success(w, map[string]interface{}{
+		"id":        songID,
+		"template":  template,
+		"path":      rel,
+		"full_path": filepath.Join(h.cfg.Download.Dir, filepath.FromSlash(rel)),
+		"info":      info,
+	}, "文件路径预览成功")
+}
+
+func (h *Handler) handleSongURL(w http.ResponseWriter, r *http.Request, songID int64, levels []string) {
+	cookies := h.loadCookies(r.Context())
+	urlData, err := h.netease.GetSongURLWithFallback(r.Context(), songID, levels, cookies)
 	if err != nil {
-		response.Error(w, http.StatusInternalServerError, err.Error())
+		writeUpstreamError(w, err)
 		return
 	}
 
-	response.Success(w, resp.Data, "获取歌曲URL成功")
+	response.Success(w, []netease.SongURLData{*urlData}, "获取歌曲URL成功")
 }
 
 func (h *Handler) handleSongName(w http.ResponseWriter, r *http.Request, songID int64) {
 	resp, err := h.netease.GetSongDetail(r.Context(), songID)
 	if err != nil {
-		response.Error(w, http.StatusInternalServerError, err.Error())
+		writeUpstreamError(w, err)
 		return
 	}
 
 	response.Success(w, resp, "获取歌曲信息成功")
 }
 
-func (h *Handler) handleSongLyric(w http.ResponseWriter, r *http.Request, songID int64) {
-	cookies := h.loadCookies()
+func (h *Handler) handleSongLyric(w http.ResponseWriter, r *http.Request, songID int64, data map[string]string) {
+	var format lyrics.Format
+	if name := firstNonEmpty(data, "format"); name != "" {
+		var err error
+		if format, err = lyrics.ParseFormat(name); err != nil {
+			response.Error(w, http.StatusBadRequest, "不支持的歌词格式，支持: lrc, srt, vtt, ass, ttml, txt")
+			return
+		}
+	}
+
+	if words, _ := strconv.ParseBool(firstNonEmpty(data, "words")); words {
+		h.handleWordLyric(w, r, songID, format, data)
+		return
+	}
+
+	cookies := h.loadCookies(r.Context())
 	resp, err := h.netease.GetLyrics(r.Context(), songID, cookies)
 	if err != nil {
-		response.Error(w, http.StatusInternalServerError, err.Error())
+		writeUpstreamError(w, err)
+		return
+	}
+	if format != "" || firstNonEmpty(data, "merge") != "" {
+		h.writeConvertedLyric(w, r, songID, resp, format, data)
 		return
 	}
 
-	data := map[string]string{
+	result := map[string]string{
 		"lrc":     safeLyric(resp),
 		"tlyric":  safeTLyric(resp),
 		"romalrc": "",
 		"klyric":  "",
 	}
 	if resp != nil {
-		data["romalrc"] = resp.Romalrc.Lyric
-		data["klyric"] = resp.Klyric.Lyric
+		result["romalrc"] = resp.Romalrc.Lyric
+		result["klyric"] = resp.Klyric.Lyric
 	}
 
-	response.Success(w, data, "获取歌词成功")
+	response.Success(w, result, "获取歌词成功")
 }
 
 func (h *Handler) handleSongDetail(w http.ResponseWriter, r *http.Request, songID int64) {
 	resp, err := h.netease.GetSongDetail(r.Context(), songID)
 	if err != nil {
-		response.Error(w, http.StatusInternalServerError, err.Error())
+		writeUpstreamError(w, err)
 		return
 	}
 
 	if resp == nil || len(resp.Songs) == 0 {
-		response.Error(w, http.StatusNotFound, "未找到歌曲信息")
+		response.ErrorWithCode(w, http.StatusNotFound, string(netease.KindNotFound), "未找到歌曲信息")
 		return
 	}
 
@@ -478,19 +669,19 @@ func (h *Handler) handleSongDetail(w http.ResponseWriter, r *http.Request, songI
 	response.Success(w, data, "获取歌曲信息成功")
 }
 
-func (h *Handler) handleSongJSON(w http.ResponseWriter, r *http.Request, songID int64, quality string) {
+func (h *Handler) handleSongJSON(w http.ResponseWriter, r *http.Request, songID int64, levels []string) {
 	detailResp, err := h.netease.GetSongDetail(r.Context(), songID)
 	if err != nil {
-		response.Error(w, http.StatusInternalServerError, err.Error())
+		writeUpstreamError(w, err)
 		return
 	}
 	if detailResp == nil || len(detailResp.Songs) == 0 {
-		response.Error(w, http.StatusNotFound, "未找到歌曲信息")
+		response.ErrorWithCode(w, http.StatusNotFound, string(netease.KindNotFound), "未找到歌曲信息")
 		return
 	}
 
-	cookies := h.loadCookies()
-	urlResp, _ := h.netease.GetSongURL(r.Context(), songID, quality, cookies)
+	cookies := h.loadCookies(r.Context())
+	urlData, _ := h.netease.GetSongURLWithFallback(r.Context(), songID, levels, cookies)
 	lyricResp, _ := h.netease.GetLyrics(r.Context(), songID, cookies)
 
 	song := detailResp.Songs[0]
@@ -507,13 +698,12 @@ func (h *Handler) handleSongJSON(w http.ResponseWriter, r *http.Request, songID
 		"ar_name": strings.Join(artists, ", "),
 		"al_name": song.Al.Name,
 		"pic":     song.Al.PicURL,
-		"level":   quality,
+		"level":   levels[0],
 		"lyric":   safeLyric(lyricResp),
 		"tlyric":  safeTLyric(lyricResp),
 	}
 
-	if urlResp != nil && len(urlResp.Data) > 0 {
-		urlData := urlResp.Data[0]
+	if urlData != nil {
 		data["url"] = urlData.URL
 		data["size"] = formatFileSize(urlData.Size)
 		data["level"] = urlData.Level
@@ -521,51 +711,79 @@ func (h *Handler) handleSongJSON(w http.ResponseWriter, r *http.Request, songID
 		data["url"] = ""
 		data["size"] = "获取失败"
 	}
+	if entry, ok := h.localLibrary().Best(songID, ""); ok && entry.ReplayGain != nil {
+		data["replaygain"] = entry.ReplayGain
+	}
 
 	response.Success(w, data, "获取歌曲信息成功")
 }
 
-func (h *Handler) sendDownloadFile(w http.ResponseWriter, r *http.Request, info *downloader.MusicInfo) {
+func (h *Handler) sendDownloadFile(w http.ResponseWriter, r *http.Request, info *downloader.MusicInfo, tmpl *downloader.Template) {
 	if info == nil {
 		response.Error(w, http.StatusInternalServerError, "下载信息为空")
 		return
 	}
 
-	filename := h.downloader.BuildFilename(info)
-	extension := info.FileType
-	if extension == "" {
-		extension = "mp3"
+	if info.LocalPath != "" {
+		h.setDownloadHeaders(w, r, info, tmpl, fileExtension(info))
+		http.ServeFile(w, r, info.LocalPath)
+		return
 	}
-	filename = fmt.Sprintf("%s.%s", filename, extension)
-
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
-	w.Header().Set("X-Download-Message", "Download completed successfully")
-	w.Header().Set("X-Download-Filename", url.QueryEscape(filename))
 
 	if h.cfg != nil && !h.cfg.Download.InMemory {
-		filePath, _, err := h.downloader.DownloadToFile(r.Context(), info)
+		filePath, _, err := h.downloader.DownloadToFile(r.Context(), info, tmpl)
 		if err != nil {
-			response.Error(w, http.StatusInternalServerError, err.Error())
+			writeUpstreamError(w, err)
 			return
 		}
+		// DownloadToFile corrects info.FileType from the saved content.
+		h.setDownloadHeaders(w, r, info, tmpl, fileExtension(info))
 		http.ServeFile(w, r, filePath)
 		return
 	}
 
 	stream, headers, err := h.netease.FetchSongStream(r.Context(), info.URL)
 	if err != nil {
-		response.Error(w, http.StatusInternalServerError, err.Error())
+		writeUpstreamError(w, err)
 		return
 	}
 	defer stream.Close()
 
-	if contentType := headers.Get("Content-Type"); contentType != "" {
-		w.Header().Set("Content-Type", contentType)
-	} else {
-		w.Header().Set("Content-Type", "audio/"+extension)
+	// Name the file after what the CDN actually sends rather than the
+	// type it was announced as.
+	extension := fileExtension(info)
+	contentType := headers.Get("Content-Type")
+	buffered := bufio.NewReader(stream)
+	head, _ := buffered.Peek(64)
+	if sniffed := probe.Sniff(head); sniffed != "" && sniffed != extension {
+		extension, contentType = sniffed, probe.ContentType(sniffed)
 	}
+	if contentType == "" {
+		contentType = "audio/" + extension
+	}
+	h.setDownloadHeaders(w, r, info, tmpl, extension)
+	w.Header().Set("Content-Type", contentType)
+
+	_, _ = io.Copy(w, buffered)
+}
 
-	_, _ = io.Copy(w, stream)
+func fileExtension(info *downloader.MusicInfo) string {
+	if info.FileType == "" {
+		return "mp3"
+	}
+	return info.FileType
+}
+
+// setDownloadHeaders names the attachment after info rendered through tmpl
+// (the configured template when nil) with the given extension.
+func (h *Handler) setDownloadHeaders(w http.ResponseWriter, r *http.Request, info *downloader.MusicInfo, tmpl *downloader.Template, extension string) {
+	filename := h.downloader.BuildFilename(r.Context(), info, tmpl)
+	filename = strings.ReplaceAll(filename, `"`, "'")
+	filename = fmt.Sprintf("%s.%s", filename, extension)
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	w.Header().Set("X-Download-Message", "Download completed successfully")
+	w.Header().Set("X-Download-Filename", url.QueryEscape(filename))
 }
 
 func (h *Handler) extractID(ctx context.Context, input string) (int64, error) {
@@ -600,15 +818,8 @@ func (h *Handler) extractID(ctx context.Context, input string) (int64, error) {
 	return 0, errors.New("无法从输入中提取ID")
 }
 
-func (h *Handler) loadCookies() map[string]string {
-	if h.cookies == nil {
-		return map[string]string{}
-	}
-	parsed, err := h.cookies.Parse()
-	if err != nil {
-		return map[string]string{}
-	}
-	return parsed
+func (h *Handler) loadCookies(ctx context.Context) map[string]string {
+	return h.cookies.Resolve(ctx)
 }
 
 func parseRequestData(r *http.Request) map[string]string {
@@ -655,6 +866,29 @@ func parseRequestData(r *http.Request) map[string]string {
 	return result
 }
 
+// qualityChain returns quality followed by the comma separated levels of the
+// optional fallback parameter, e.g. level=hires&fallback=lossless,exhigh.
+// Every level must be one netease.Levels lists.
+func qualityChain(data map[string]string, quality string) ([]string, error) {
+	if !netease.IsValidLevel(quality) {
+		return nil, errors.New("无效的音质参数")
+	}
+	levels := []string{quality}
+	seen := map[string]bool{quality: true}
+	for _, level := range strings.Split(firstNonEmpty(data, "fallback"), ",") {
+		level = strings.TrimSpace(level)
+		if level == "" || seen[level] {
+			continue
+		}
+		if !netease.IsValidLevel(level) {
+			return nil, fmt.Errorf("无效的备选音质: %s", level)
+		}
+		seen[level] = true
+		levels = append(levels, level)
+	}
+	return levels, nil
+}
+
 func firstNonEmpty(data map[string]string, keys ...string) string {
 	for _, key := range keys {
 		if value, ok := data[key]; ok && strings.TrimSpace(value) != "" {
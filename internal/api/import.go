@@ -0,0 +1,144 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"wyapi-golang/internal/playlist"
+	"wyapi-golang/pkg/response"
+)
+
+const (
+	maxImportBytes   = 2 << 20
+	maxImportEntries = 500
+)
+
+// PlaylistImport matches a track list exported from another service
+// against NetEase. The list comes as the content field, an uploaded file
+// or a text/csv request body. The matched ids can be passed on to
+// DownloadBatch.
+func (h *Handler) PlaylistImport(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+	content, err := importContent(r)
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "读取导入内容失败: "+err.Error())
+		return
+	}
+	data := parseRequestData(r)
+	if content == "" {
+		content = firstNonEmpty(data, "content", "text", "csv")
+	}
+	if content == "" {
+		response.Error(w, http.StatusBadRequest, "缺少导入内容")
+		return
+	}
+
+	entries, err := playlist.Parse(content, firstNonEmpty(data, "format"))
+	if err != nil {
+		if errors.Is(err, playlist.ErrNoTitleColumn) {
+			response.Error(w, http.StatusBadRequest, "CSV 中没有歌名列")
+			return
+		}
+		response.Error(w, http.StatusBadRequest, "解析导入内容失败: "+err.Error())
+		return
+	}
+	if len(entries) == 0 {
+		response.Error(w, http.StatusBadRequest, "导入内容中没有歌曲")
+		return
+	}
+	if len(entries) > maxImportEntries {
+		response.Error(w, http.StatusBadRequest, "导入的歌曲过多，单次最多 "+strconv.Itoa(maxImportEntries)+" 首")
+		return
+	}
+
+	opts := playlist.MatchOptions{
+		MinScore:   playlist.DefaultMinScore,
+		Alternates: min(parseInt(firstNonEmpty(data, "alternates"), 3), 10),
+	}
+	if raw := firstNonEmpty(data, "min_score"); raw != "" {
+		score, err := strconv.ParseFloat(raw, 64)
+		if err != nil || score <= 0 || score > 1 {
+			response.Error(w, http.StatusBadRequest, "无效的 min_score 参数，取值范围 (0, 1]")
+			return
+		}
+		opts.MinScore = score
+	}
+
+	// Downloads go through /api/download/batch, which sits behind the
+	// download scope, its rate limit and the job queue.
+	if download, _ := strconv.ParseBool(firstNonEmpty(data, "download")); download {
+		response.Error(w, http.StatusBadRequest, "导入接口不再直接下载，请将匹配结果的 ids 提交到 /api/download/batch")
+		return
+	}
+
+	cookies := h.loadCookies(r.Context())
+	search := func(ctx context.Context, query string) ([]playlist.Candidate, error) {
+		resp, err := h.netease.Search(ctx, query, 10, cookies)
+		if err != nil {
+			return nil, err
+		}
+		candidates := make([]playlist.Candidate, 0, len(resp.Result.Songs))
+		for _, song := range resp.Result.Songs {
+			artists := make([]string, 0, len(song.Ar))
+			for _, artist := range song.Ar {
+				artists = append(artists, artist.Name)
+			}
+			candidates = append(candidates, playlist.Candidate{
+				ID:         song.ID,
+				Title:      song.Name,
+				Artists:    strings.Join(artists, "/"),
+				Album:      song.Al.Name,
+				DurationMs: song.Dt,
+			})
+		}
+		return candidates, nil
+	}
+	results := playlist.Match(r.Context(), entries, search, opts)
+
+	ids := []int64{}
+	for _, result := range results {
+		if result.Matched {
+			ids = append(ids, result.ID)
+		}
+	}
+	matched := len(ids)
+	response.Success(w, map[string]interface{}{
+		"total":     len(results),
+		"matched":   matched,
+		"unmatched": len(results) - matched,
+		"min_score": opts.MinScore,
+		"ids":       ids,
+		"results":   results,
+	}, "匹配完成")
+}
+
+// importContent reads a list sent as an uploaded file or as a plain text
+// or CSV body. Other requests carry it in a form or JSON field, which is
+// left to parseRequestData.
+func importContent(r *http.Request) (string, error) {
+	contentType := r.Header.Get("Content-Type")
+	switch {
+	case strings.HasPrefix(contentType, "multipart/form-data"):
+		if err := r.ParseMultipartForm(maxImportBytes); err != nil {
+			return "", err
+		}
+		file, _, err := r.FormFile("file")
+		if errors.Is(err, http.ErrMissingFile) {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		defer file.Close()
+		body, err := io.ReadAll(file)
+		return string(body), err
+	case strings.HasPrefix(contentType, "text/"):
+		body, err := io.ReadAll(r.Body)
+		return string(body), err
+	}
+	return "", nil
+}
@@ -0,0 +1,199 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/go-chi/chi/v5"
+	"wyapi-golang/internal/auth"
+	"wyapi-golang/internal/cookie"
+	"wyapi-golang/internal/downloader"
+	"wyapi-golang/internal/jobs"
+	"wyapi-golang/internal/netease"
+	"wyapi-golang/pkg/response"
+)
+
+const (
+	jobKindBatch  = "download_batch"
+	maxBatchSongs = 500
+)
+
+// DownloadBatch queues a job that saves songs into the download directory,
+// e.g. the ids a playlist import matched. The job runs on the bounded job
+// queue; each song counts against the caller's token quota like a single
+// download would.
+func (h *Handler) DownloadBatch(w http.ResponseWriter, r *http.Request) {
+	if h.queue == nil {
+		response.Error(w, http.StatusServiceUnavailable, "下载任务队列未启用")
+		return
+	}
+	data := parseRequestData(r)
+	ids, err := parseIDList(firstNonEmpty(data, "ids", "id"))
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "无效的歌曲ID: "+err.Error())
+		return
+	}
+	if len(ids) == 0 {
+		response.Error(w, http.StatusBadRequest, "缺少歌曲ID")
+		return
+	}
+	if len(ids) > maxBatchSongs {
+		response.Error(w, http.StatusBadRequest, "歌曲过多，单次最多 "+strconv.Itoa(maxBatchSongs)+" 首")
+		return
+	}
+
+	quality := firstNonEmpty(data, "quality", "level")
+	if quality == "" {
+		quality = defaultQuality
+	}
+	if !netease.IsValidLevel(quality) {
+		response.Error(w, http.StatusBadRequest, "无效的音质参数")
+		return
+	}
+	var opts downloader.BatchOptions
+	if raw := firstNonEmpty(data, "dir"); raw != "" {
+		opts.Subdir, err = downloader.CleanSubdir(raw)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, "无效的目录参数: "+err.Error())
+			return
+		}
+	}
+	if raw := firstNonEmpty(data, "template"); raw != "" {
+		opts.Template, err = downloader.ParseTemplate(raw)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, "文件名模板无效: "+err.Error())
+			return
+		}
+	}
+
+	token := auth.TokenFromContext(r.Context())
+	owner := ""
+	if token != nil {
+		owner = token.Name
+	}
+	scope := requestScope(r)
+	job, err := h.queue.Submit(jobKindBatch, owner, len(ids), func(ctx context.Context, progress *jobs.Progress) error {
+		ctx = scope(ctx)
+		// The request that queued the job was already counted; every song
+		// after that is charged as a download of its own.
+		opts.Before = func(int64) error { return h.tokens.Reserve(token) }
+		opts.After = func(songID int64, written int64, err error) {
+			if err != nil {
+				progress.Fail(fmt.Errorf("%d: %w", songID, err))
+				return
+			}
+			h.tokens.Record(token, written)
+			progress.Done(written)
+		}
+		downloaded, err := h.downloader.DownloadBatch(ctx, ids, quality, opts)
+		attrs := []any{slog.Int("total", len(ids)), slog.Int("downloaded", downloaded), slog.String("dir", opts.Subdir)}
+		if errors.Is(err, auth.ErrQuotaExceeded) {
+			slog.Warn("download batch stopped by token quota", attrs...)
+			return auth.ErrQuotaExceeded
+		}
+		if err != nil {
+			slog.Warn("download batch finished with errors", append(attrs, slog.String("error", err.Error()))...)
+			return nil
+		}
+		slog.Info("download batch finished", attrs...)
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, jobs.ErrQueueFull) {
+			response.Error(w, http.StatusServiceUnavailable, "下载任务队列已满，请稍后重试")
+			return
+		}
+		response.Error(w, http.StatusServiceUnavailable, "无法创建下载任务: "+err.Error())
+		return
+	}
+	response.Write(w, http.StatusAccepted, http.StatusAccepted, "下载任务已加入队列", job)
+}
+
+// DownloadJob reports the progress of a queued download job.
+func (h *Handler) DownloadJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.findJob(r)
+	if !ok {
+		response.Error(w, http.StatusNotFound, "未找到该下载任务")
+		return
+	}
+	response.Success(w, job, "获取下载任务成功")
+}
+
+// DownloadJobCancel stops a queued or running download job. Songs already
+// saved are kept.
+func (h *Handler) DownloadJobCancel(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.findJob(r)
+	if !ok {
+		response.Error(w, http.StatusNotFound, "未找到该下载任务")
+		return
+	}
+	job, err := h.queue.Cancel(job.ID)
+	if err != nil {
+		if errors.Is(err, jobs.ErrJobFinished) {
+			response.Error(w, http.StatusConflict, "该下载任务已结束")
+			return
+		}
+		response.Error(w, http.StatusNotFound, "未找到该下载任务")
+		return
+	}
+	response.Success(w, job, "已取消下载任务")
+}
+
+// findJob looks up the job in the URL. Tokens only see their own jobs,
+// except admin tokens, which see all of them.
+func (h *Handler) findJob(r *http.Request) (jobs.Job, bool) {
+	if h.queue == nil {
+		return jobs.Job{}, false
+	}
+	job, ok := h.queue.Get(chi.URLParam(r, "id"))
+	if !ok {
+		return jobs.Job{}, false
+	}
+	if token := auth.TokenFromContext(r.Context()); token != nil && job.Owner != token.Name && !token.HasScope(auth.ScopeAdmin) {
+		return jobs.Job{}, false
+	}
+	return job, true
+}
+
+// requestScope captures the NetEase identity r runs under, its cookie
+// override and emulation profile, and returns a function that puts it on a
+// job's context. Jobs run on the queue's context rather than r's, so
+// without it a tenant's batch would download with the operator cookie.
+func requestScope(r *http.Request) func(context.Context) context.Context {
+	override, hasOverride := cookie.OverrideFromContext(r.Context())
+	profile := netease.ProfileFromContext(r.Context())
+	return func(ctx context.Context) context.Context {
+		if hasOverride {
+			ctx = cookie.WithOverride(ctx, override)
+		}
+		if profile != "" {
+			ctx = netease.WithProfile(ctx, profile)
+		}
+		return ctx
+	}
+}
+
+// parseIDList reads song IDs separated by commas or whitespace, dropping
+// duplicates.
+func parseIDList(raw string) ([]int64, error) {
+	fields := strings.FieldsFunc(raw, func(r rune) bool { return r == ',' || unicode.IsSpace(r) })
+	ids := make([]int64, 0, len(fields))
+	seen := map[int64]bool{}
+	for _, field := range fields {
+		id, err := strconv.ParseInt(field, 10, 64)
+		if err != nil || id <= 0 {
+			return nil, errors.New(field)
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
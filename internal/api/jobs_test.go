@@ -0,0 +1,60 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"wyapi-golang/internal/cookie"
+	"wyapi-golang/internal/jobs"
+	"wyapi-golang/internal/netease"
+)
+
+func TestRequestScopeCarriesIdentityIntoJob(t *testing.T) {
+	queue := jobs.NewQueue(1, 1)
+	reqCtx, cancelRequest := context.WithCancel(context.Background())
+	reqCtx = cookie.WithOverride(reqCtx, map[string]string{"MUSIC_U": "tenant"})
+	reqCtx = netease.WithProfile(reqCtx, "android")
+	r := httptest.NewRequest(http.MethodPost, "/download/batch", nil).WithContext(reqCtx)
+
+	scope := requestScope(r)
+	seen := make(chan context.Context, 1)
+	if _, err := queue.Submit(jobKindBatch, "tenant", 1, func(ctx context.Context, progress *jobs.Progress) error {
+		seen <- scope(ctx)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// The request is over by the time a queued job runs.
+	cancelRequest()
+	queueCtx, stop := context.WithCancel(context.Background())
+	defer stop()
+	go queue.Run(queueCtx)
+
+	var ctx context.Context
+	select {
+	case ctx = <-seen:
+	case <-time.After(5 * time.Second):
+		t.Fatal("job did not run")
+	}
+	override, ok := cookie.OverrideFromContext(ctx)
+	if !ok || override["MUSIC_U"] != "tenant" {
+		t.Errorf("job cookie override = %v, %v; want the request's", override, ok)
+	}
+	if got := netease.ProfileFromContext(ctx); got != "android" {
+		t.Errorf("job profile = %q, want android", got)
+	}
+}
+
+func TestRequestScopeWithoutOverride(t *testing.T) {
+	scope := requestScope(httptest.NewRequest(http.MethodPost, "/download/batch", nil))
+	ctx := scope(context.Background())
+	if _, ok := cookie.OverrideFromContext(ctx); ok {
+		t.Error("job got a cookie override the request did not have")
+	}
+	if got := netease.ProfileFromContext(ctx); got != "" {
+		t.Errorf("job profile = %q, want none", got)
+	}
+}
@@ -0,0 +1,189 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"wyapi-golang/internal/library"
+	"wyapi-golang/pkg/response"
+)
+
+func (h *Handler) localLibrary() *library.Library {
+	if h.downloader == nil {
+		return nil
+	}
+	return h.downloader.Library()
+}
+
+func (h *Handler) LibraryList(w http.ResponseWriter, r *http.Request) {
+	lib := h.localLibrary()
+	if lib == nil {
+		response.Error(w, http.StatusNotFound, "本地曲库未启用")
+		return
+	}
+
+	data := parseRequestData(r)
+	query := firstNonEmpty(data, "q", "keyword", "keywords")
+	offset := parseInt(firstNonEmpty(data, "offset"), 0)
+	limit := parseInt(firstNonEmpty(data, "limit"), 50)
+
+	var entries []library.Entry
+	var total int
+	if idInput := firstNonEmpty(data, "id"); idInput != "" {
+		songID, err := strconv.ParseInt(idInput, 10, 64)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, "无效的歌曲ID")
+			return
+		}
+		all, _ := lib.List(query, 0, 0)
+		for _, entry := range all {
+			if entry.ID == songID {
+				entries = append(entries, entry)
+			}
+		}
+		total = len(entries)
+	} else {
+		entries, total = lib.List(query, offset, limit)
+	}
+
+	response.Success(w, map[string]interface{}{
+		"total":   total,
+		"offset":  offset,
+		"limit":   limit,
+		"entries": entries,
+	}, "获取本地曲库成功")
+}
+
+func (h *Handler) LibraryDelete(w http.ResponseWriter, r *http.Request) {
+	lib := h.localLibrary()
+	if lib == nil {
+		response.Error(w, http.StatusNotFound, "本地曲库未启用")
+		return
+	}
+
+	data := parseRequestData(r)
+	idInput := firstNonEmpty(data, "id")
+	path := firstNonEmpty(data, "path")
+	if idInput == "" && path == "" {
+		response.Error(w, http.StatusBadRequest, "缺少歌曲ID或文件路径")
+		return
+	}
+
+	var songID int64
+	if idInput != "" {
+		parsed, err := strconv.ParseInt(idInput, 10, 64)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, "无效的歌曲ID")
+			return
+		}
+		songID = parsed
+	}
+
+	removed, err := lib.Delete(songID, path)
+	if err != nil {
+		if errors.Is(err, library.ErrNotFound) {
+			response.Error(w, http.StatusNotFound, "本地曲库中未找到该歌曲")
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "删除失败: "+err.Error())
+		return
+	}
+
+	response.Success(w, map[string]interface{}{"removed": removed}, "删除成功")
+}
+
+func (h *Handler) LibraryScan(w http.ResponseWriter, r *http.Request) {
+	lib := h.localLibrary()
+	if lib == nil {
+		response.Error(w, http.StatusNotFound, "本地曲库未启用")
+		return
+	}
+
+	added, removed, err := lib.Scan()
+	if err != nil {
+		response.Error(w, http.StatusInternalServerError, "扫描失败: "+err.Error())
+		return
+	}
+
+	response.Success(w, map[string]int{"added": added, "removed": removed}, "扫描完成")
+}
+
+func (h *Handler) LibraryPin(w http.ResponseWriter, r *http.Request) {
+	lib := h.localLibrary()
+	if lib == nil {
+		response.Error(w, http.StatusNotFound, "本地曲库未启用")
+		return
+	}
+
+	data := parseRequestData(r)
+	songID, err := strconv.ParseInt(firstNonEmpty(data, "id"), 10, 64)
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "无效的歌曲ID")
+		return
+	}
+	pinned := firstNonEmpty(data, "pinned") != "false"
+
+	count, err := lib.SetPinned(songID, pinned)
+	if err != nil {
+		if errors.Is(err, library.ErrNotFound) {
+			response.Error(w, http.StatusNotFound, "本地曲库中未找到该歌曲")
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "设置失败: "+err.Error())
+		return
+	}
+
+	response.Success(w, map[string]interface{}{"id": songID, "pinned": pinned, "files": count}, "设置成功")
+}
+
+// LibraryVerify probes indexed files for truncation, changed content and
+// extensions that do not match the format; fix=true renames mislabelled
+// files.
+func (h *Handler) LibraryVerify(w http.ResponseWriter, r *http.Request) {
+	lib := h.localLibrary()
+	if lib == nil {
+		response.Error(w, http.StatusNotFound, "本地曲库未启用")
+		return
+	}
+
+	data := parseRequestData(r)
+	var songID int64
+	if idInput := firstNonEmpty(data, "id"); idInput != "" {
+		parsed, err := strconv.ParseInt(idInput, 10, 64)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, "无效的歌曲ID")
+			return
+		}
+		songID = parsed
+	}
+	fix, _ := strconv.ParseBool(firstNonEmpty(data, "fix"))
+
+	results, err := lib.Verify(songID, fix)
+	if err != nil {
+		if errors.Is(err, library.ErrNotFound) {
+			response.Error(w, http.StatusNotFound, "本地曲库中未找到该歌曲")
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "校验失败: "+err.Error())
+		return
+	}
+
+	summary := map[string]int{}
+	problems := []library.Verification{}
+	for _, result := range results {
+		summary[result.Status]++
+		if result.Status != library.StatusOK && result.Status != library.StatusUnsupported {
+			problems = append(problems, result)
+		}
+	}
+	if all, _ := strconv.ParseBool(firstNonEmpty(data, "all")); all {
+		problems = results
+	}
+
+	response.Success(w, map[string]interface{}{
+		"total":   len(results),
+		"summary": summary,
+		"results": problems,
+	}, "校验完成")
+}
@@ -0,0 +1,206 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"wyapi-golang/internal/auth"
+	"wyapi-golang/internal/downloader"
+	"wyapi-golang/pkg/response"
+)
+
+var streamPassthroughHeaders = []string{"Content-Type", "Content-Length", "Content-Range", "Accept-Ranges", "Last-Modified", "ETag"}
+
+func (h *Handler) SignLink(w http.ResponseWriter, r *http.Request) {
+	if h.signer == nil {
+		response.Error(w, http.StatusServiceUnavailable, "未配置签名密钥")
+		return
+	}
+
+	data := parseRequestData(r)
+	idInput := firstNonEmpty(data, "id", "url")
+	if idInput == "" {
+		response.Error(w, http.StatusBadRequest, "缺少歌曲ID")
+		return
+	}
+
+	quality := firstNonEmpty(data, "quality", "level")
+	if quality == "" {
+		quality = defaultQuality
+	}
+	levels, err := qualityChain(data, quality)
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	kind := firstNonEmpty(data, "type")
+	if kind == "" {
+		kind = auth.LinkDownload
+	}
+	if kind != auth.LinkDownload && kind != auth.LinkStream {
+		response.Error(w, http.StatusBadRequest, "无效的类型参数，支持: download, stream")
+		return
+	}
+
+	// Every option is signed along with the song, so a link cannot be
+	// rewritten into a different download.
+	target, err := parseTranscodeTarget(data)
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "转码参数无效: "+err.Error())
+		return
+	}
+	if target != nil && kind != auth.LinkDownload {
+		response.Error(w, http.StatusBadRequest, "仅下载链接支持转码参数")
+		return
+	}
+	template := firstNonEmpty(data, "template")
+	if template != "" {
+		if kind != auth.LinkDownload {
+			response.Error(w, http.StatusBadRequest, "仅下载链接支持文件名模板")
+			return
+		}
+		if _, err := downloader.ParseTemplate(template); err != nil {
+			response.Error(w, http.StatusBadRequest, "文件名模板无效: "+err.Error())
+			return
+		}
+	}
+
+	ttl := h.linkTTL(data)
+
+	songID, err := h.extractID(r.Context(), idInput)
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	params := url.Values{}
+	params.Set("id", strconv.FormatInt(songID, 10))
+	params.Set("quality", quality)
+	result := map[string]interface{}{
+		"type":    kind,
+		"id":      songID,
+		"quality": quality,
+	}
+	if len(levels) > 1 {
+		params.Set("fallback", strings.Join(levels[1:], ","))
+		result["fallback"] = levels[1:]
+	}
+	if template != "" {
+		params.Set("template", template)
+		result["template"] = template
+	}
+	if target != nil {
+		params.Set("codec", target.Codec)
+		result["codec"] = target.Codec
+		if target.Bitrate > 0 {
+			params.Set("bitrate", strconv.Itoa(target.Bitrate))
+			result["bitrate"] = target.Bitrate
+		}
+	}
+
+	values, expiresAt := h.signer.Sign(kind, params, ttl)
+	path := "/" + kind + "?" + values.Encode()
+	result["path"] = path
+	result["url"] = requestBaseURL(r) + path
+	result["expires_at"] = expiresAt.Unix()
+
+	response.Success(w, result, "签名链接生成成功")
+}
+
+func (h *Handler) Stream(w http.ResponseWriter, r *http.Request) {
+	data := parseRequestData(r)
+	idInput := firstNonEmpty(data, "id", "url")
+	if idInput == "" {
+		response.Error(w, http.StatusBadRequest, "缺少歌曲ID")
+		return
+	}
+
+	quality := firstNonEmpty(data, "quality", "level")
+	if quality == "" {
+		quality = defaultQuality
+	}
+	levels, err := qualityChain(data, quality)
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	songID, err := h.extractID(r.Context(), idInput)
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	info, err := h.downloader.GetMusicInfoWithFallback(r.Context(), songID, levels)
+	if err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+
+	if info.LocalPath != "" {
+		filename := filepath.Base(info.LocalPath)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename*=UTF-8''%s", url.PathEscape(filename)))
+		http.ServeFile(w, r, info.LocalPath)
+		return
+	}
+
+	upstream, err := h.netease.OpenSongStream(r.Context(), info.URL, r.Header.Get("Range"))
+	if err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+	defer upstream.Body.Close()
+
+	for _, name := range streamPassthroughHeaders {
+		if value := upstream.Header.Get(name); value != "" {
+			w.Header().Set(name, value)
+		}
+	}
+	if w.Header().Get("Content-Type") == "" && info.FileType != "" {
+		w.Header().Set("Content-Type", "audio/"+info.FileType)
+	}
+
+	filename := h.downloader.BuildFilename(r.Context(), info, nil)
+	if info.FileType != "" {
+		filename = fmt.Sprintf("%s.%s", filename, info.FileType)
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename*=UTF-8''%s", url.PathEscape(filename)))
+	w.WriteHeader(upstream.StatusCode)
+
+	if r.Method == http.MethodHead {
+		return
+	}
+	_, _ = io.Copy(w, upstream.Body)
+}
+
+// linkTTL reads the requested lifetime of a signed link, capped at the
+// configured maximum.
+func (h *Handler) linkTTL(data map[string]string) time.Duration {
+	maxTTL := 3600
+	if h.cfg != nil && h.cfg.Security.LinkTTLSeconds > 0 {
+		maxTTL = h.cfg.Security.LinkTTLSeconds
+	}
+	ttl := parseInt(firstNonEmpty(data, "ttl", "expires_in"), maxTTL)
+	if ttl <= 0 || ttl > maxTTL {
+		ttl = maxTTL
+	}
+	return time.Duration(ttl) * time.Second
+}
+
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = strings.ToLower(strings.TrimSpace(strings.Split(proto, ",")[0]))
+	}
+	return scheme + "://" + r.Host
+}
@@ -0,0 +1,239 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"wyapi-golang/internal/downloader"
+	"wyapi-golang/internal/lyrics"
+	"wyapi-golang/internal/netease"
+	"wyapi-golang/pkg/response"
+)
+
+// writeConvertedLyric answers a lyric request that asked for a format or a
+// merged timeline. Without merge, the original, translated and romanized
+// lyrics are converted separately; with download=true the one selected by
+// source (or the merged timeline) is sent as a file.
+func (h *Handler) writeConvertedLyric(w http.ResponseWriter, r *http.Request, songID int64, resp *netease.LyricResponse, format lyrics.Format, data map[string]string) {
+	sources := map[string]string{"lrc": "", "tlyric": "", "romalrc": ""}
+	if resp != nil {
+		sources["lrc"] = resp.Lrc.Lyric
+		sources["tlyric"] = resp.Tlyric.Lyric
+		sources["romalrc"] = resp.Romalrc.Lyric
+	}
+	if format == "" {
+		format = lyrics.FormatLRC
+	}
+	download, _ := strconv.ParseBool(firstNonEmpty(data, "download"))
+
+	withTranslation, withRomanization, err := parseMergeMode(firstNonEmpty(data, "merge"))
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "无效的 merge 参数，支持: true, tlyric, romalrc")
+		return
+	}
+	if withTranslation || withRomanization {
+		tolerance := lyrics.DefaultTolerance
+		if raw := firstNonEmpty(data, "tolerance"); raw != "" {
+			ms, err := strconv.Atoi(raw)
+			if err != nil || ms < 0 || ms > 5000 {
+				response.Error(w, http.StatusBadRequest, "tolerance 必须是 0-5000 之间的毫秒数")
+				return
+			}
+			tolerance = time.Duration(ms) * time.Millisecond
+		}
+
+		var translation, romanization *lyrics.Lyrics
+		if withTranslation {
+			translation = lyrics.ParseLRC(sources["tlyric"])
+		}
+		if withRomanization {
+			romanization = lyrics.ParseLRC(sources["romalrc"])
+		}
+		merged := lyrics.Merge(lyrics.ParseLRC(sources["lrc"]), translation, romanization, tolerance)
+
+		if download {
+			h.sendLyricFile(w, r, songID, merged.Lyrics(), format, "merged")
+			return
+		}
+		lines := make([]map[string]interface{}, 0, len(merged.Lines))
+		for _, line := range merged.Lines {
+			lines = append(lines, map[string]interface{}{
+				"start_ms":     line.Start.Milliseconds(),
+				"end_ms":       line.End.Milliseconds(),
+				"text":         line.Text,
+				"translation":  line.Translation,
+				"romanization": line.Romanization,
+			})
+		}
+		response.Success(w, map[string]interface{}{
+			"format": format,
+			"lines":  lines,
+			"lyric":  lyrics.Render(merged.Lyrics(), format),
+		}, "获取歌词成功")
+		return
+	}
+
+	if !download {
+		result := map[string]string{"format": string(format)}
+		for key, text := range sources {
+			result[key] = lyrics.Render(lyrics.ParseLRC(text), format)
+		}
+		response.Success(w, result, "获取歌词成功")
+		return
+	}
+
+	source := strings.ToLower(firstNonEmpty(data, "source"))
+	if source == "" {
+		source = "lrc"
+	}
+	text, ok := sources[source]
+	if !ok {
+		response.Error(w, http.StatusBadRequest, "无效的歌词来源，支持: lrc, tlyric, romalrc")
+		return
+	}
+	suffix := ""
+	if source != "lrc" {
+		suffix = source
+	}
+	h.sendLyricFile(w, r, songID, lyrics.ParseLRC(text), format, suffix)
+}
+
+// parseMergeMode reads the merge parameter: true merges both companions,
+// tlyric or romalrc only that one.
+func parseMergeMode(raw string) (translation bool, romanization bool, err error) {
+	switch strings.ToLower(raw) {
+	case "", "0", "false":
+		return false, false, nil
+	case "1", "true", "all":
+		return true, true, nil
+	case "tlyric", "translation":
+		return true, false, nil
+	case "romalrc", "romanization":
+		return false, true, nil
+	}
+	return false, false, fmt.Errorf("invalid merge mode %q", raw)
+}
+
+// sendLyricFile writes parsed as an attachment named after the song, and
+// fills in the title and artist the lyric itself rarely carries.
+func (h *Handler) sendLyricFile(w http.ResponseWriter, r *http.Request, songID int64, parsed *lyrics.Lyrics, format lyrics.Format, suffix string) {
+	if len(parsed.Lines) == 0 {
+		response.ErrorWithCode(w, http.StatusNotFound, string(netease.KindNotFound), "该歌曲没有可用的歌词")
+		return
+	}
+
+	info := h.lyricSong(r.Context(), songID)
+	if parsed.Title == "" {
+		parsed.Title = info.Name
+	}
+	if parsed.Artist == "" {
+		parsed.Artist = info.Artists
+	}
+	if parsed.Album == "" {
+		parsed.Album = info.Album
+	}
+	writeLyricFile(w, h.lyricFilename(r.Context(), info, suffix, format), format, lyrics.Render(parsed, format))
+}
+
+// handleWordLyric answers words=true with the yrc lyric, or klyric when a
+// song has no yrc, as per-word timing.
+func (h *Handler) handleWordLyric(w http.ResponseWriter, r *http.Request, songID int64, format lyrics.Format, data map[string]string) {
+	resp, err := h.netease.GetWordLyrics(r.Context(), songID, h.loadCookies(r.Context()))
+	if err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+
+	source := "yrc"
+	karaoke := lyrics.ParseYRC(resp.Yrc.Lyric)
+	if len(karaoke.Lines) == 0 {
+		source = "klyric"
+		karaoke = lyrics.ParseKLyric(resp.Klyric.Lyric)
+	}
+	if len(karaoke.Lines) == 0 {
+		response.ErrorWithCode(w, http.StatusNotFound, string(netease.KindNotFound), "该歌曲没有逐字歌词")
+		return
+	}
+	if format == "" {
+		format = lyrics.FormatLRC
+	}
+
+	if download, _ := strconv.ParseBool(firstNonEmpty(data, "download")); download {
+		info := h.lyricSong(r.Context(), songID)
+		karaoke.Title, karaoke.Artist, karaoke.Album = info.Name, info.Artists, info.Album
+		writeLyricFile(w, h.lyricFilename(r.Context(), info, "karaoke", format), format, lyrics.RenderKaraoke(karaoke, format))
+		return
+	}
+
+	lines := make([]map[string]interface{}, 0, len(karaoke.Lines))
+	for _, line := range karaoke.Lines {
+		words := make([]map[string]interface{}, 0, len(line.Words))
+		for _, word := range line.Words {
+			words = append(words, map[string]interface{}{
+				"start_ms":    word.Start.Milliseconds(),
+				"duration_ms": word.Duration.Milliseconds(),
+				"text":        word.Text,
+			})
+		}
+		lines = append(lines, map[string]interface{}{
+			"start_ms":    line.Start.Milliseconds(),
+			"duration_ms": line.Duration.Milliseconds(),
+			"text":        line.Text(),
+			"words":       words,
+		})
+	}
+	response.Success(w, map[string]interface{}{
+		"source": source,
+		"format": format,
+		"lines":  lines,
+		"lyric":  lyrics.RenderKaraoke(karaoke, format),
+	}, "获取逐字歌词成功")
+}
+
+func writeLyricFile(w http.ResponseWriter, filename string, format lyrics.Format, content string) {
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename*=UTF-8''%s", url.PathEscape(filename)))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(content))
+}
+
+// lyricSong looks up the song a lyric download belongs to. Only the ID is
+// set when the detail lookup fails.
+func (h *Handler) lyricSong(ctx context.Context, songID int64) *downloader.MusicInfo {
+	info := &downloader.MusicInfo{ID: songID}
+	detail, err := h.netease.GetSongDetail(ctx, songID)
+	if err != nil || detail == nil || len(detail.Songs) == 0 {
+		return info
+	}
+
+	song := detail.Songs[0]
+	artists := make([]string, 0, len(song.Ar))
+	for _, artist := range song.Ar {
+		if artist.Name != "" {
+			artists = append(artists, artist.Name)
+		}
+	}
+	info.Name = song.Name
+	info.Artists = strings.Join(artists, "/")
+	info.Album = song.Al.Name
+	return info
+}
+
+// lyricFilename names a lyric download like the audio file it belongs to,
+// falling back to the song ID. suffix, when set, is added before the
+// extension to tell variants of the same song apart.
+func (h *Handler) lyricFilename(ctx context.Context, info *downloader.MusicInfo, suffix string, format lyrics.Format) string {
+	filename := strconv.FormatInt(info.ID, 10)
+	if info.Name != "" {
+		filename = h.downloader.BuildFilename(ctx, info, nil)
+	}
+	if suffix != "" {
+		filename += "." + suffix
+	}
+	return filename + "." + format.Extension()
+}
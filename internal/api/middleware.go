@@ -1,14 +1,23 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"strings"
 
 	"github.com/go-chi/cors"
+	"wyapi-golang/internal/auth"
 	"wyapi-golang/internal/config"
+	"wyapi-golang/internal/cookie"
+	"wyapi-golang/internal/netease"
 	"wyapi-golang/pkg/response"
 )
 
+const cookieOverrideHeader = "X-Netease-Cookie"
+
+type rawCookieOverrideKey struct{}
+
 func CORSMiddleware(cfg config.CORSConfig) func(http.Handler) http.Handler {
 	c := cors.New(cors.Options{
 		AllowedOrigins:   cfg.AllowedOrigins,
@@ -21,23 +30,193 @@ func CORSMiddleware(cfg config.CORSConfig) func(http.Handler) http.Handler {
 	return c.Handler
 }
 
-func AuthMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
+func AuthMiddleware(cfg *config.Config, tokens *auth.Registry, signer *auth.Signer) func(http.Handler) http.Handler {
 	if cfg == nil || !cfg.Security.RequireToken {
 		return func(next http.Handler) http.Handler { return next }
 	}
 
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			token := extractToken(r)
-			if token == "" || token != cfg.Security.APIToken {
+			if kind, ok, err := verifySignedLink(r, signer); ok {
+				if err != nil {
+					if errors.Is(err, auth.ErrLinkExpired) {
+						response.Error(w, http.StatusForbidden, "下载链接已过期")
+						return
+					}
+					response.Error(w, http.StatusForbidden, "无效的下载链接签名")
+					return
+				}
+				next.ServeHTTP(w, r.WithContext(auth.WithSignedLink(r.Context(), kind)))
+				return
+			}
+
+			token, err := tokens.Authenticate(extractToken(r))
+			if err != nil {
+				if errors.Is(err, auth.ErrTokenExpired) {
+					response.Error(w, http.StatusUnauthorized, "API Token已过期")
+					return
+				}
 				response.Error(w, http.StatusUnauthorized, "无效的API Token")
 				return
 			}
+			if err := tokens.Reserve(token); err != nil {
+				response.Error(w, http.StatusTooManyRequests, "API Token今日配额已用尽")
+				return
+			}
+
+			counter := &countingResponseWriter{ResponseWriter: w}
+			next.ServeHTTP(counter, r.WithContext(auth.WithToken(r.Context(), token)))
+			tokens.Record(token, counter.written)
+		})
+	}
+}
+
+// RequireScope rejects requests whose token lacks scope. It is a no-op when
+// token enforcement is disabled.
+func RequireScope(cfg *config.Config, scope string) func(http.Handler) http.Handler {
+	if cfg == nil || !cfg.Security.RequireToken {
+		return func(next http.Handler) http.Handler { return next }
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if scope == auth.ScopeDownload && auth.SignedLinkFromContext(r.Context()) != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !auth.TokenFromContext(r.Context()).HasScope(scope) {
+				response.Error(w, http.StatusForbidden, "API Token权限不足")
+				return
+			}
 			next.ServeHTTP(w, r)
 		})
 	}
 }
 
+// RequireAdmin guards the admin routes. Unlike RequireScope it applies
+// even when require_token is off: the admin routes delete files and start
+// downloads, so they always need an admin token, and stay closed while
+// none is configured. A token it authenticates itself is charged against
+// its quota like AuthMiddleware does.
+func RequireAdmin(cfg *config.Config, tokens *auth.Registry) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token := auth.TokenFromContext(r.Context()); token != nil {
+				// Already authenticated and charged by AuthMiddleware.
+				if !token.HasScope(auth.ScopeAdmin) {
+					response.Error(w, http.StatusForbidden, "API Token权限不足")
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if !tokens.HasScope(auth.ScopeAdmin) {
+				response.Error(w, http.StatusForbidden, "未配置 admin 权限的 API Token，管理接口已关闭")
+				return
+			}
+			token, err := tokens.Authenticate(extractToken(r))
+			if err != nil {
+				if errors.Is(err, auth.ErrTokenExpired) {
+					response.Error(w, http.StatusUnauthorized, "API Token已过期")
+					return
+				}
+				response.Error(w, http.StatusUnauthorized, "无效的API Token")
+				return
+			}
+			if !token.HasScope(auth.ScopeAdmin) {
+				response.Error(w, http.StatusForbidden, "API Token权限不足")
+				return
+			}
+			if err := tokens.Reserve(token); err != nil {
+				response.Error(w, http.StatusTooManyRequests, "API Token今日配额已用尽")
+				return
+			}
+
+			counter := &countingResponseWriter{ResponseWriter: w}
+			next.ServeHTTP(counter, r.WithContext(auth.WithToken(r.Context(), token)))
+			tokens.Record(token, counter.written)
+		})
+	}
+}
+
+// hasScope reports whether a handler in a less privileged group may do
+// what scope guards, e.g. hand out download links from a read endpoint.
+func hasScope(cfg *config.Config, r *http.Request, scope string) bool {
+	if cfg == nil || !cfg.Security.RequireToken {
+		return true
+	}
+	return auth.TokenFromContext(r.Context()).HasScope(scope)
+}
+
+// verifySignedLink checks a ?sig= link on the download and stream routes. ok
+// reports whether the request carries a signature at all; only GET and HEAD
+// qualify so the signed query parameters are the ones handlers act on.
+func verifySignedLink(r *http.Request, signer *auth.Signer) (string, bool, error) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return "", false, nil
+	}
+	query := r.URL.Query()
+	if query.Get("sig") == "" {
+		return "", false, nil
+	}
+
+	var kind string
+	switch strings.ToLower(r.URL.Path) {
+	case "/download":
+		kind = auth.LinkDownload
+	case "/stream":
+		kind = auth.LinkStream
+	default:
+		return "", false, nil
+	}
+
+	return kind, true, signer.Verify(kind, query)
+}
+
+type countingResponseWriter struct {
+	http.ResponseWriter
+	written int64
+}
+
+func (w *countingResponseWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+func (w *countingResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *countingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// ProfileMiddleware lets a request pick a NetEase emulation profile through
+// the profile parameter or X-Netease-Profile header.
+func ProfileMiddleware(client *netease.Client) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			name := strings.TrimSpace(r.Header.Get("X-Netease-Profile"))
+			if name == "" {
+				name = parseRequestData(r)["profile"]
+			}
+			if name == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if client == nil || !client.HasProfile(name) {
+				response.Error(w, http.StatusBadRequest, "无效的客户端配置，支持: "+strings.Join(netease.ProfileNames(), ", "))
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(netease.WithProfile(r.Context(), name)))
+		})
+	}
+}
+
 func extractToken(r *http.Request) string {
 	if r == nil {
 		return ""
@@ -67,3 +246,73 @@ func extractToken(r *http.Request) string {
 
 	return ""
 }
+
+// StripCookieOverride removes a caller-supplied NetEase cookie from the
+// request headers and query string before anything gets a chance to log it,
+// keeping it only in the request context. It must run before the logger.
+func StripCookieOverride(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw := strings.TrimSpace(r.Header.Get(cookieOverrideHeader))
+		r.Header.Del(cookieOverrideHeader)
+
+		query := r.URL.Query()
+		if _, ok := query["cookie"]; ok {
+			if raw == "" {
+				raw = strings.TrimSpace(query.Get("cookie"))
+			}
+			query.Del("cookie")
+			r.URL.RawQuery = query.Encode()
+			r.RequestURI = r.URL.RequestURI()
+		}
+
+		if raw != "" {
+			r = r.WithContext(context.WithValue(r.Context(), rawCookieOverrideKey{}, raw))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CookieOverrideMiddleware applies the configured cookie override policy and
+// attaches the parsed override to the request context for handlers to use.
+func CookieOverrideMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mode := config.CookieOverrideDisabled
+			if cfg != nil {
+				mode = cfg.Security.CookieOverride
+			}
+			if token := auth.TokenFromContext(r.Context()); token != nil && token.CookieOverride != "" {
+				mode = token.CookieOverride
+			}
+
+			raw, _ := r.Context().Value(rawCookieOverrideKey{}).(string)
+			if raw == "" && r.Method != http.MethodGet {
+				raw = parseRequestData(r)["cookie"]
+			}
+
+			var override map[string]string
+			if raw != "" {
+				override = cookie.ParseCookieString(raw)
+			}
+
+			switch mode {
+			case config.CookieOverrideOptional, config.CookieOverrideRequired:
+				if len(override) == 0 {
+					if mode == config.CookieOverrideRequired {
+						response.Error(w, http.StatusUnauthorized, "必须通过 X-Netease-Cookie 提供网易云 cookie")
+						return
+					}
+					break
+				}
+				r = r.WithContext(cookie.WithOverride(r.Context(), override))
+			default:
+				if raw != "" {
+					response.Error(w, http.StatusForbidden, "未启用自定义 cookie")
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"wyapi-golang/internal/auth"
+	"wyapi-golang/internal/config"
+)
+
+func adminRegistry(t *testing.T) *auth.Registry {
+	t.Helper()
+	tokens, err := auth.NewRegistry(config.SecurityConfig{Tokens: []config.APITokenConfig{
+		{Name: "ops", Token: "ops-secret", Scopes: []string{auth.ScopeAdmin}, DailyRequests: 2},
+		{Name: "reader", Token: "read-secret", Scopes: []string{auth.ScopeRead}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokens
+}
+
+func adminRequest(secret string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/api/admin/tokens", nil)
+	r.Header.Set("Authorization", "Bearer "+secret)
+	return r
+}
+
+func usageOf(tokens *auth.Registry, name string) auth.Usage {
+	for _, status := range tokens.Status() {
+		if status.Name == name {
+			return status.Usage
+		}
+	}
+	return auth.Usage{}
+}
+
+func TestRequireAdminChargesQuota(t *testing.T) {
+	tokens := adminRegistry(t)
+	handler := RequireAdmin(&config.Config{}, tokens)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, adminRequest("ops-secret"))
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d", i+1, w.Code)
+		}
+	}
+	if usage := usageOf(tokens, "ops"); usage.Requests != 2 || usage.Bytes != 4 {
+		t.Errorf("usage = %+v, want 2 requests and 4 bytes", usage)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, adminRequest("ops-secret"))
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("request over quota: status = %d, want 429", w.Code)
+	}
+}
+
+func TestRequireAdminRejectsOtherScopes(t *testing.T) {
+	tokens := adminRegistry(t)
+	handler := RequireAdmin(&config.Config{}, tokens)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler reached")
+	}))
+
+	for secret, want := range map[string]int{"read-secret": http.StatusForbidden, "wrong": http.StatusUnauthorized} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, adminRequest(secret))
+		if w.Code != want {
+			t.Errorf("%s: status = %d, want %d", secret, w.Code, want)
+		}
+	}
+	if usage := usageOf(tokens, "reader"); usage.Requests != 0 {
+		t.Errorf("rejected token charged: %+v", usage)
+	}
+}
+
+func TestRequireAdminBehindAuthMiddleware(t *testing.T) {
+	tokens := adminRegistry(t)
+	cfg := &config.Config{Security: config.SecurityConfig{RequireToken: true}}
+	handler := AuthMiddleware(cfg, tokens, nil)(RequireAdmin(cfg, tokens)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	handler.ServeHTTP(httptest.NewRecorder(), adminRequest("ops-secret"))
+	if usage := usageOf(tokens, "ops"); usage.Requests != 1 {
+		t.Errorf("usage = %+v, want the request charged once", usage)
+	}
+}
+
+func TestAdminRoutesApplyTokenCookiePolicy(t *testing.T) {
+	tokens, err := auth.NewRegistry(config.SecurityConfig{Tokens: []config.APITokenConfig{
+		{Name: "tenant", Token: "tenant-secret", Scopes: []string{auth.ScopeAdmin}, CookieOverride: config.CookieOverrideRequired},
+		{Name: "ops", Token: "ops-secret", Scopes: []string{auth.ScopeAdmin}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// require_token is off, so only RequireAdmin knows the token.
+	cfg := &config.Config{Security: config.SecurityConfig{CookieOverride: config.CookieOverrideDisabled}}
+	router := NewRouter(&Handler{cfg: cfg, tokens: tokens}, cfg, nil, nil)
+
+	tests := []struct {
+		name   string
+		secret string
+		cookie string
+		want   int
+	}{
+		{"token policy requires a cookie", "tenant-secret", "", http.StatusUnauthorized},
+		{"token policy accepts a cookie", "tenant-secret", "MUSIC_U=tenant", http.StatusOK},
+		{"global policy refuses a cookie", "ops-secret", "MUSIC_U=other", http.StatusForbidden},
+		{"global policy without a cookie", "ops-secret", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := adminRequest(tt.secret)
+		if tt.cookie != "" {
+			r.Header.Set(cookieOverrideHeader, tt.cookie)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
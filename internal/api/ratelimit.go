@@ -0,0 +1,163 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"wyapi-golang/internal/auth"
+	"wyapi-golang/internal/config"
+	"wyapi-golang/internal/ratelimit"
+	"wyapi-golang/pkg/response"
+)
+
+// RateLimiters holds the per-IP and per-token buckets for one route class.
+type RateLimiters struct {
+	perIP    *ratelimit.Limiter
+	perToken *ratelimit.Limiter
+}
+
+func NewRateLimiters(cfg config.RouteRateLimit) *RateLimiters {
+	return &RateLimiters{
+		perIP:    ratelimit.PerMinute(cfg.PerIP.RequestsPerMinute, cfg.PerIP.Burst),
+		perToken: ratelimit.PerMinute(cfg.PerToken.RequestsPerMinute, cfg.PerToken.Burst),
+	}
+}
+
+// RateLimitMiddleware enforces limiters and reports the most restrictive
+// bucket in X-RateLimit-* headers, answering 429 with Retry-After when empty.
+func RateLimitMiddleware(limiters *RateLimiters) func(http.Handler) http.Handler {
+	if limiters == nil || (limiters.perIP == nil && limiters.perToken == nil) {
+		return func(next http.Handler) http.Handler { return next }
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			decisions := []ratelimit.Decision{limiters.perIP.Allow(clientIP(r))}
+			if token := auth.TokenFromContext(r.Context()); token != nil && limiters.perToken != nil {
+				decisions = append(decisions, limiters.perToken.Allow(token.Name))
+			}
+
+			var limiting *ratelimit.Decision
+			allowed := true
+			for i := range decisions {
+				decision := &decisions[i]
+				if decision.Limit == 0 {
+					continue
+				}
+				if !decision.Allowed {
+					allowed = false
+				}
+				if limiting == nil || decision.Remaining < limiting.Remaining || (!decision.Allowed && limiting.Allowed) {
+					limiting = decision
+				}
+			}
+
+			if limiting != nil {
+				w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limiting.Limit))
+				w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(max(limiting.Remaining, 0)))
+				w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(ceilSeconds(limiting.Reset), 10))
+			}
+			if !allowed {
+				w.Header().Set("Retry-After", strconv.FormatInt(max(ceilSeconds(limiting.RetryAfter), 1), 10))
+				response.Error(w, http.StatusTooManyRequests, "请求过于频繁，请稍后再试")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ParseTrustedProxies reads server.trusted_proxies: single addresses or
+// CIDR ranges.
+func ParseTrustedProxies(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// RealIPMiddleware replaces RemoteAddr with the client address a trusted
+// proxy reports. X-Forwarded-For is read from the right, skipping the
+// trusted hops, since everything left of the last untrusted one is
+// whatever the client chose to send; X-Real-IP is the fallback. Requests
+// from other peers keep their connection address, so clients cannot pick
+// their own rate limit bucket.
+func RealIPMiddleware(trusted []netip.Prefix) func(http.Handler) http.Handler {
+	isTrusted := func(addr netip.Addr) bool {
+		for _, prefix := range trusted {
+			if prefix.Contains(addr.Unmap()) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(next http.Handler) http.Handler {
+		if len(trusted) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			peer, err := netip.ParseAddr(clientIP(r))
+			if err != nil || !isTrusted(peer) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			client := ""
+			hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+			for i := len(hops) - 1; i >= 0; i-- {
+				addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+				if err != nil {
+					break
+				}
+				client = addr.Unmap().String()
+				if !isTrusted(addr) {
+					break
+				}
+			}
+			if client == "" {
+				if addr, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+					client = addr.Unmap().String()
+				}
+			}
+			if client != "" {
+				r.RemoteAddr = client
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func ceilSeconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
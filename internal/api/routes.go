@@ -5,6 +5,7 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"wyapi-golang/internal/auth"
 	"wyapi-golang/internal/config"
 )
 
@@ -12,8 +13,13 @@ func NewRouter(handler *Handler, cfg *config.Config, staticHandler http.Handler,
 	r := chi.NewRouter()
 
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
+	if cfg != nil {
+		// Checked at startup; see ParseTrustedProxies.
+		trusted, _ := ParseTrustedProxies(cfg.Server.TrustedProxies)
+		r.Use(RealIPMiddleware(trusted))
+	}
 	r.Use(middleware.Recoverer)
+	r.Use(StripCookieOverride)
 	r.Use(middleware.Logger)
 
 	if cfg != nil {
@@ -28,51 +34,118 @@ func NewRouter(handler *Handler, cfg *config.Config, staticHandler http.Handler,
 		})
 	}
 
+	var metadataLimits, downloadLimits *RateLimiters
+	if cfg != nil && cfg.RateLimit.Enabled {
+		metadataLimits = NewRateLimiters(cfg.RateLimit.Metadata)
+		downloadLimits = NewRateLimiters(cfg.RateLimit.Download)
+	}
+
 	r.Group(func(api chi.Router) {
-		api.Use(AuthMiddleware(cfg))
-
-		api.Get("/api/info", handler.APIInfo)
-
-		api.MethodFunc(http.MethodGet, "/song", handler.Song)
-		api.MethodFunc(http.MethodPost, "/song", handler.Song)
-		api.MethodFunc(http.MethodGet, "/Song_V1", handler.Song)
-		api.MethodFunc(http.MethodPost, "/Song_V1", handler.Song)
-
-		api.MethodFunc(http.MethodGet, "/search", handler.Search)
-		api.MethodFunc(http.MethodPost, "/search", handler.Search)
-		api.MethodFunc(http.MethodGet, "/Search", handler.Search)
-		api.MethodFunc(http.MethodPost, "/Search", handler.Search)
-
-		api.MethodFunc(http.MethodGet, "/playlist", handler.Playlist)
-		api.MethodFunc(http.MethodPost, "/playlist", handler.Playlist)
-		api.MethodFunc(http.MethodGet, "/Playlist", handler.Playlist)
-		api.MethodFunc(http.MethodPost, "/Playlist", handler.Playlist)
-
-		api.MethodFunc(http.MethodGet, "/album", handler.Album)
-		api.MethodFunc(http.MethodPost, "/album", handler.Album)
-		api.MethodFunc(http.MethodGet, "/Album", handler.Album)
-		api.MethodFunc(http.MethodPost, "/Album", handler.Album)
-
-		api.MethodFunc(http.MethodGet, "/download", handler.Download)
-		api.MethodFunc(http.MethodPost, "/download", handler.Download)
-		api.MethodFunc(http.MethodGet, "/Download", handler.Download)
-		api.MethodFunc(http.MethodPost, "/Download", handler.Download)
-
-		api.MethodFunc(http.MethodGet, "/api/music/url", handler.SongURL)
-		api.MethodFunc(http.MethodPost, "/api/music/url", handler.SongURL)
-		api.MethodFunc(http.MethodGet, "/api/music/detail", handler.SongDetail)
-		api.MethodFunc(http.MethodPost, "/api/music/detail", handler.SongDetail)
-		api.MethodFunc(http.MethodGet, "/api/getMusicInfo", handler.SongDetail)
-		api.MethodFunc(http.MethodPost, "/api/getMusicInfo", handler.SongDetail)
-		api.MethodFunc(http.MethodGet, "/api/music/lyric", handler.SongLyric)
-		api.MethodFunc(http.MethodPost, "/api/music/lyric", handler.SongLyric)
-		api.MethodFunc(http.MethodGet, "/api/music/playlist", handler.PlaylistAPI)
-		api.MethodFunc(http.MethodPost, "/api/music/playlist", handler.PlaylistAPI)
-		api.MethodFunc(http.MethodGet, "/api/music/album", handler.AlbumAPI)
-		api.MethodFunc(http.MethodPost, "/api/music/album", handler.AlbumAPI)
-
-		api.MethodFunc(http.MethodGet, "/netease/search", handler.NeteaseSearch)
-		api.MethodFunc(http.MethodPost, "/netease/search", handler.NeteaseSearch)
+		api.Use(AuthMiddleware(cfg, handler.tokens, handler.signer))
+		api.Use(ProfileMiddleware(handler.netease))
+
+		// The cookie override policy can depend on the token, so it is
+		// applied in each group once the token is known.
+		api.Group(func(api chi.Router) {
+			api.Use(RequireScope(cfg, auth.ScopeRead))
+			api.Use(CookieOverrideMiddleware(cfg))
+			api.Use(RateLimitMiddleware(metadataLimits))
+
+			api.Get("/api/info", handler.APIInfo)
+
+			api.MethodFunc(http.MethodGet, "/song", handler.Song)
+			api.MethodFunc(http.MethodPost, "/song", handler.Song)
+			api.MethodFunc(http.MethodGet, "/Song_V1", handler.Song)
+			api.MethodFunc(http.MethodPost, "/Song_V1", handler.Song)
+
+			api.MethodFunc(http.MethodGet, "/search", handler.Search)
+			api.MethodFunc(http.MethodPost, "/search", handler.Search)
+			api.MethodFunc(http.MethodGet, "/Search", handler.Search)
+			api.MethodFunc(http.MethodPost, "/Search", handler.Search)
+
+			api.MethodFunc(http.MethodGet, "/playlist", handler.Playlist)
+			api.MethodFunc(http.MethodPost, "/playlist", handler.Playlist)
+			api.MethodFunc(http.MethodGet, "/Playlist", handler.Playlist)
+			api.MethodFunc(http.MethodPost, "/Playlist", handler.Playlist)
+
+			api.MethodFunc(http.MethodGet, "/album", handler.Album)
+			api.MethodFunc(http.MethodPost, "/album", handler.Album)
+			api.MethodFunc(http.MethodGet, "/Album", handler.Album)
+			api.MethodFunc(http.MethodPost, "/Album", handler.Album)
+
+			api.MethodFunc(http.MethodGet, "/api/music/url", handler.SongURL)
+			api.MethodFunc(http.MethodPost, "/api/music/url", handler.SongURL)
+			api.MethodFunc(http.MethodGet, "/api/music/qualities", handler.SongQualities)
+			api.MethodFunc(http.MethodPost, "/api/music/qualities", handler.SongQualities)
+			api.MethodFunc(http.MethodGet, "/api/music/detail", handler.SongDetail)
+			api.MethodFunc(http.MethodPost, "/api/music/detail", handler.SongDetail)
+			api.MethodFunc(http.MethodGet, "/api/getMusicInfo", handler.SongDetail)
+			api.MethodFunc(http.MethodPost, "/api/getMusicInfo", handler.SongDetail)
+			api.MethodFunc(http.MethodGet, "/api/music/lyric", handler.SongLyric)
+			api.MethodFunc(http.MethodPost, "/api/music/lyric", handler.SongLyric)
+			api.MethodFunc(http.MethodGet, "/api/music/playlist", handler.PlaylistAPI)
+			api.MethodFunc(http.MethodPost, "/api/music/playlist", handler.PlaylistAPI)
+			api.MethodFunc(http.MethodGet, "/api/music/album", handler.AlbumAPI)
+			api.MethodFunc(http.MethodPost, "/api/music/album", handler.AlbumAPI)
+			api.MethodFunc(http.MethodGet, "/api/music/playlist/export", handler.PlaylistExport)
+			api.MethodFunc(http.MethodPost, "/api/music/playlist/export", handler.PlaylistExport)
+			api.MethodFunc(http.MethodPost, "/api/music/playlist/import", handler.PlaylistImport)
+
+			api.MethodFunc(http.MethodGet, "/netease/search", handler.NeteaseSearch)
+			api.MethodFunc(http.MethodPost, "/netease/search", handler.NeteaseSearch)
+
+			api.MethodFunc(http.MethodGet, "/api/download/preview", handler.DownloadPreview)
+			api.MethodFunc(http.MethodPost, "/api/download/preview", handler.DownloadPreview)
+
+			api.MethodFunc(http.MethodGet, "/api/library", handler.LibraryList)
+			api.MethodFunc(http.MethodPost, "/api/library", handler.LibraryList)
+
+			api.MethodFunc(http.MethodGet, "/api/cover/{kind}/{id}", handler.Cover)
+			api.MethodFunc(http.MethodHead, "/api/cover/{kind}/{id}", handler.Cover)
+
+			api.Get("/api/subscriptions", handler.Subscriptions)
+			api.Get("/api/subscriptions/history", handler.SubscriptionHistory)
+		})
+
+		api.Group(func(api chi.Router) {
+			api.Use(RequireScope(cfg, auth.ScopeDownload))
+			api.Use(CookieOverrideMiddleware(cfg))
+			api.Use(RateLimitMiddleware(downloadLimits))
+
+			api.MethodFunc(http.MethodGet, "/download", handler.Download)
+			api.MethodFunc(http.MethodPost, "/download", handler.Download)
+			api.MethodFunc(http.MethodGet, "/Download", handler.Download)
+			api.MethodFunc(http.MethodPost, "/Download", handler.Download)
+			api.MethodFunc(http.MethodGet, "/stream", handler.Stream)
+			api.MethodFunc(http.MethodHead, "/stream", handler.Stream)
+
+			api.Post("/api/download/batch", handler.DownloadBatch)
+			api.Get("/api/download/jobs/{id}", handler.DownloadJob)
+			api.Post("/api/download/jobs/{id}/cancel", handler.DownloadJobCancel)
+
+			api.MethodFunc(http.MethodGet, "/api/sign", handler.SignLink)
+			api.MethodFunc(http.MethodPost, "/api/sign", handler.SignLink)
+		})
+
+		api.Group(func(api chi.Router) {
+			api.Use(RequireAdmin(cfg, handler.tokens))
+			api.Use(CookieOverrideMiddleware(cfg))
+			api.Use(RateLimitMiddleware(metadataLimits))
+
+			api.Get("/api/admin/tokens", handler.AdminTokens)
+			api.Post("/api/library/delete", handler.LibraryDelete)
+			api.Post("/api/library/scan", handler.LibraryScan)
+			api.Post("/api/library/pin", handler.LibraryPin)
+			api.Get("/api/library/verify", handler.LibraryVerify)
+			api.Post("/api/library/verify", handler.LibraryVerify)
+
+			api.Post("/api/subscriptions", handler.SubscriptionAdd)
+			api.Post("/api/subscriptions/delete", handler.SubscriptionDelete)
+			api.Post("/api/subscriptions/sync", handler.SubscriptionSync)
+
+			api.Get("/api/scheduler/tasks", handler.SchedulerTasks)
+			api.Post("/api/scheduler/run", handler.SchedulerRun)
+		})
 	})
 
 	if staticHandler != nil {
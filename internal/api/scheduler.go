@@ -0,0 +1,53 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"wyapi-golang/internal/scheduler"
+	"wyapi-golang/pkg/response"
+)
+
+func (h *Handler) SchedulerTasks(w http.ResponseWriter, r *http.Request) {
+	if h.scheduler == nil {
+		response.Success(w, []scheduler.TaskStatus{}, "获取定时任务成功")
+		return
+	}
+	response.Success(w, h.scheduler.Status(), "获取定时任务成功")
+}
+
+// SchedulerRun starts a task in the background; its outcome appears as
+// last_run in the task list.
+func (h *Handler) SchedulerRun(w http.ResponseWriter, r *http.Request) {
+	data := parseRequestData(r)
+	name := firstNonEmpty(data, "name", "task")
+	if name == "" {
+		response.Error(w, http.StatusBadRequest, "缺少任务名称")
+		return
+	}
+	if h.scheduler == nil {
+		response.Error(w, http.StatusNotFound, "未找到该任务")
+		return
+	}
+
+	err := h.scheduler.Trigger(name, func(name string, result scheduler.RunResult) {
+		if result.Error != "" {
+			slog.Warn("scheduled task failed", slog.String("task", name), slog.String("result", result.Result), slog.String("error", result.Error))
+			return
+		}
+		slog.Info("scheduled task finished", slog.String("task", name), slog.String("result", result.Result))
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, scheduler.ErrTaskNotFound):
+			response.Error(w, http.StatusNotFound, "未找到该任务")
+		case errors.Is(err, scheduler.ErrTaskRunning):
+			response.Error(w, http.StatusConflict, "该任务正在运行")
+		default:
+			response.Error(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	response.Write(w, http.StatusAccepted, http.StatusAccepted, "任务已开始运行", map[string]string{"name": name})
+}
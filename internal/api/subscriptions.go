@@ -0,0 +1,162 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"wyapi-golang/internal/subscription"
+	"wyapi-golang/pkg/response"
+)
+
+func (h *Handler) Subscriptions(w http.ResponseWriter, r *http.Request) {
+	if h.subscriptions == nil {
+		response.Error(w, http.StatusNotFound, "歌单订阅未启用")
+		return
+	}
+	response.Success(w, h.subscriptions.List(), "获取歌单订阅成功")
+}
+
+func (h *Handler) SubscriptionAdd(w http.ResponseWriter, r *http.Request) {
+	if h.subscriptions == nil {
+		response.Error(w, http.StatusNotFound, "歌单订阅未启用")
+		return
+	}
+
+	data := parseRequestData(r)
+	idInput := firstNonEmpty(data, "id", "url")
+	if idInput == "" {
+		response.Error(w, http.StatusBadRequest, "缺少歌单ID")
+		return
+	}
+	playlistID, err := h.extractID(r.Context(), idInput)
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	sub, err := h.subscriptions.Add(subscription.Subscription{
+		PlaylistID: playlistID,
+		Dir:        firstNonEmpty(data, "dir"),
+		Quality:    firstNonEmpty(data, "quality", "level"),
+		OnRemove:   firstNonEmpty(data, "on_remove"),
+		Template:   firstNonEmpty(data, "template"),
+	})
+	if err != nil {
+		if errors.Is(err, subscription.ErrExists) {
+			response.Error(w, http.StatusConflict, "该歌单已订阅")
+			return
+		}
+		response.Error(w, http.StatusBadRequest, "添加订阅失败: "+err.Error())
+		return
+	}
+
+	if firstNonEmpty(data, "sync") != "false" {
+		h.startSync(playlistID)
+	}
+	response.Success(w, sub, "添加订阅成功")
+}
+
+func (h *Handler) SubscriptionDelete(w http.ResponseWriter, r *http.Request) {
+	if h.subscriptions == nil {
+		response.Error(w, http.StatusNotFound, "歌单订阅未启用")
+		return
+	}
+
+	playlistID, ok := h.subscriptionID(w, r)
+	if !ok {
+		return
+	}
+	if err := h.subscriptions.Remove(playlistID); err != nil {
+		if errors.Is(err, subscription.ErrNotFound) {
+			response.Error(w, http.StatusNotFound, "未找到该订阅")
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "删除订阅失败: "+err.Error())
+		return
+	}
+	response.Success(w, map[string]int64{"playlist_id": playlistID}, "删除订阅成功")
+}
+
+// SubscriptionSync starts a sync in the background and returns at once;
+// the outcome shows up in the sync history.
+func (h *Handler) SubscriptionSync(w http.ResponseWriter, r *http.Request) {
+	if h.subscriptions == nil {
+		response.Error(w, http.StatusNotFound, "歌单订阅未启用")
+		return
+	}
+
+	data := parseRequestData(r)
+	if firstNonEmpty(data, "id", "url") == "" {
+		go h.subscriptions.SyncAll(context.Background())
+		response.Write(w, http.StatusAccepted, http.StatusAccepted, "已开始同步全部订阅", nil)
+		return
+	}
+
+	playlistID, ok := h.subscriptionID(w, r)
+	if !ok {
+		return
+	}
+	if _, found := h.subscriptions.Get(playlistID); !found {
+		response.Error(w, http.StatusNotFound, "未找到该订阅")
+		return
+	}
+	if h.subscriptions.Syncing(playlistID) {
+		response.Error(w, http.StatusConflict, "该歌单正在同步")
+		return
+	}
+
+	h.startSync(playlistID)
+	response.Write(w, http.StatusAccepted, http.StatusAccepted, "已开始同步", map[string]int64{"playlist_id": playlistID})
+}
+
+func (h *Handler) SubscriptionHistory(w http.ResponseWriter, r *http.Request) {
+	if h.subscriptions == nil {
+		response.Error(w, http.StatusNotFound, "歌单订阅未启用")
+		return
+	}
+
+	data := parseRequestData(r)
+	var playlistID int64
+	if idInput := firstNonEmpty(data, "id", "url"); idInput != "" {
+		parsed, err := h.extractID(r.Context(), idInput)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		playlistID = parsed
+	}
+	limit := parseInt(firstNonEmpty(data, "limit"), 20)
+
+	response.Success(w, h.subscriptions.History(playlistID, limit), "获取同步记录成功")
+}
+
+func (h *Handler) subscriptionID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	idInput := firstNonEmpty(parseRequestData(r), "id", "url")
+	if idInput == "" {
+		response.Error(w, http.StatusBadRequest, "缺少歌单ID")
+		return 0, false
+	}
+	playlistID, err := h.extractID(r.Context(), idInput)
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, err.Error())
+		return 0, false
+	}
+	return playlistID, true
+}
+
+// startSync runs detached from the request so the sync uses the operator
+// cookie and survives the client disconnecting.
+func (h *Handler) startSync(playlistID int64) {
+	go func() {
+		result, err := h.subscriptions.Sync(context.Background(), playlistID)
+		if err != nil && !errors.Is(err, subscription.ErrSyncInProgress) {
+			slog.Warn("playlist sync failed", slog.Int64("playlist_id", playlistID), slog.String("error", err.Error()))
+			return
+		}
+		if result != nil {
+			slog.Info("playlist synced", slog.Int64("playlist_id", playlistID), slog.Int("added", result.Added), slog.Int("removed", result.Removed), slog.Int("failed", len(result.Failed)))
+		}
+	}()
+}
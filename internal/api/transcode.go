@@ -0,0 +1,67 @@
+package api
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"wyapi-golang/internal/downloader"
+	"wyapi-golang/internal/transcode"
+	"wyapi-golang/pkg/response"
+)
+
+// parseTranscodeTarget reads the codec and bitrate parameters; it returns
+// nil when no codec is requested.
+func parseTranscodeTarget(data map[string]string) (*transcode.Target, error) {
+	codec := firstNonEmpty(data, "codec")
+	if codec == "" {
+		return nil, nil
+	}
+	bitrate := strings.TrimSuffix(strings.ToLower(firstNonEmpty(data, "bitrate")), "k")
+	target, err := transcode.ParseTarget(codec, parseInt(bitrate, 0))
+	if err != nil {
+		return nil, err
+	}
+	return &target, nil
+}
+
+// sendTranscodedFile streams info re-encoded to target. Without a usable
+// ffmpeg the original file is sent instead, marked by X-Transcode: skipped.
+func (h *Handler) sendTranscodedFile(w http.ResponseWriter, r *http.Request, info *downloader.MusicInfo, tmpl *downloader.Template, target transcode.Target) {
+	persist := h.cfg != nil && !h.cfg.Download.InMemory
+	stream, err := h.downloader.Transcode(r.Context(), info, target, persist, tmpl)
+	if errors.Is(err, transcode.ErrUnavailable) {
+		slog.Warn("ffmpeg unavailable, sending original file", slog.Int64("song_id", info.ID), slog.String("target", target.String()))
+		w.Header().Set("X-Transcode", "skipped")
+		h.sendDownloadFile(w, r, info, tmpl)
+		return
+	}
+	if err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+
+	// Wait for the first encoded bytes so an input ffmpeg rejects still gets
+	// an error status rather than an empty 200.
+	buffered := bufio.NewReader(stream)
+	if _, err := buffered.Peek(1); err != nil {
+		if closeErr := stream.Close(); closeErr != nil {
+			err = closeErr
+		}
+		response.Error(w, http.StatusBadGateway, "转码失败: "+err.Error())
+		return
+	}
+
+	// The encoded size is unknown up front, so the response is chunked and
+	// cannot serve ranges.
+	h.setDownloadHeaders(w, r, info, tmpl, target.Extension())
+	w.Header().Set("Content-Type", target.ContentType())
+	w.Header().Set("X-Transcode", target.String())
+	_, copyErr := io.Copy(w, buffered)
+	if err := stream.Close(); err != nil && copyErr == nil && r.Context().Err() == nil {
+		slog.Warn("transcode failed", slog.Int64("song_id", info.ID), slog.String("target", target.String()), slog.String("error", err.Error()))
+	}
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"wyapi-golang/internal/downloader"
+	"wyapi-golang/internal/transcode"
+)
+
+// transcodeHandler returns a handler whose downloader transcodes with tc
+// and a song whose original is a local file.
+func transcodeHandler(t *testing.T, tc *transcode.Transcoder) (*Handler, *downloader.MusicInfo) {
+	t.Helper()
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source.flac")
+	if err := os.WriteFile(source, []byte("original audio"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d := downloader.NewDownloader(nil, nil, dir)
+	d.SetTranscoder(tc)
+	info := &downloader.MusicInfo{ID: 1, Name: "Song", Artists: "Artist", FileType: "flac", LocalPath: source}
+	return &Handler{downloader: d}, info
+}
+
+func fakeFFmpeg(t *testing.T, mode string) *transcode.Transcoder {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffmpeg is a shell script")
+	}
+	binary, err := filepath.Abs(filepath.Join("..", "transcode", "testdata", "fake-ffmpeg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("FAKE_FFMPEG", mode)
+	return transcode.New(binary, 1)
+}
+
+func TestSendTranscodedFile(t *testing.T) {
+	h, info := transcodeHandler(t, fakeFFmpeg(t, ""))
+	target := transcode.Target{Codec: "mp3", Bitrate: 320}
+
+	w := httptest.NewRecorder()
+	h.sendTranscodedFile(w, httptest.NewRequest(http.MethodGet, "/download", nil), info, nil, target)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	if got := w.Header().Get("X-Transcode"); got != "mp3-320k" {
+		t.Errorf("X-Transcode = %q", got)
+	}
+	if got := w.Header().Get("Content-Type"); got != "audio/mpeg" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if got := w.Header().Get("Content-Disposition"); !strings.Contains(got, ".mp3") {
+		t.Errorf("Content-Disposition = %q, want an .mp3 name", got)
+	}
+	if w.Body.String() != "original audio" {
+		t.Errorf("body = %q", w.Body)
+	}
+}
+
+func TestSendTranscodedFileUnavailable(t *testing.T) {
+	h, info := transcodeHandler(t, transcode.New(filepath.Join(t.TempDir(), "missing-ffmpeg"), 1))
+
+	w := httptest.NewRecorder()
+	h.sendTranscodedFile(w, httptest.NewRequest(http.MethodGet, "/download", nil), info, nil, transcode.Target{Codec: "mp3", Bitrate: 320})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	if got := w.Header().Get("X-Transcode"); got != "skipped" {
+		t.Errorf("X-Transcode = %q, want skipped", got)
+	}
+	if got := w.Header().Get("Content-Disposition"); !strings.Contains(got, ".flac") {
+		t.Errorf("Content-Disposition = %q, want the original .flac name", got)
+	}
+	if w.Body.String() != "original audio" {
+		t.Errorf("body = %q, want the original file", w.Body)
+	}
+}
+
+func TestSendTranscodedFileFailure(t *testing.T) {
+	h, info := transcodeHandler(t, fakeFFmpeg(t, "fail"))
+
+	w := httptest.NewRecorder()
+	h.sendTranscodedFile(w, httptest.NewRequest(http.MethodGet, "/download", nil), info, nil, transcode.Target{Codec: "mp3", Bitrate: 320})
+
+	if w.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d, want 502", w.Code)
+	}
+	if w.Header().Get("X-Transcode") != "" || w.Header().Get("Content-Disposition") != "" {
+		t.Errorf("failed transcode sent download headers: %v", w.Header())
+	}
+	if !strings.Contains(w.Body.String(), "Invalid data found") {
+		t.Errorf("body = %s, want ffmpeg's message", w.Body)
+	}
+}
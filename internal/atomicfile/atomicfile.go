@@ -0,0 +1,44 @@
+// Package atomicfile replaces files so readers never see them half written.
+package atomicfile
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Write copies r into a temporary file next to path and renames it over
+// path. Every call gets its own temporary file, so concurrent writers of
+// the same path never interleave; the last rename wins. A temporary left
+// behind by a crash is a hidden file ending in ".tmp".
+func Write(path string, r io.Reader, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// CreateTemp opens the file private to the owner.
+	if err := os.Chmod(tmpName, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, path)
+}
+
+// WriteBytes is Write for data already in memory.
+func WriteBytes(path string, data []byte, perm os.FileMode) error {
+	return Write(path, bytes.NewReader(data), perm)
+}
@@ -0,0 +1,57 @@
+package atomicfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentWritesOfOnePath(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cover.jpg")
+
+	var wg sync.WaitGroup
+	errs := make([]error, 16)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = Write(path, strings.NewReader(strings.Repeat(fmt.Sprint(i%10), 4096)), 0644)
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("writer %d: %v", i, err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 4096 || strings.Trim(string(data), string(data[:1])) != "" {
+		t.Errorf("cover.jpg mixes the output of several writers")
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d files, want only cover.jpg", len(entries))
+	}
+}
+
+func TestWriteSetsMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cookie.txt")
+	if err := WriteBytes(path, []byte("MUSIC_U=1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("mode = %v, want 0644", info.Mode().Perm())
+	}
+}
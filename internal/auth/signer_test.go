@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestSignerCoversQuery(t *testing.T) {
+	signer, err := NewSigner("0123456789abcdef")
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := url.Values{"id": {"1"}, "quality": {"lossless"}, "codec": {"mp3"}, "bitrate": {"128"}}
+	values, _ := signer.Sign(LinkDownload, params, time.Minute)
+
+	if err := signer.Verify(LinkDownload, values); err != nil {
+		t.Fatalf("Verify() = %v", err)
+	}
+	if err := signer.Verify(LinkStream, values); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Verify() for another kind = %v", err)
+	}
+	for key, value := range map[string]string{"id": "2", "quality": "hires", "codec": "flac", "bitrate": "320", "expires": "9999999999"} {
+		tampered := url.Values{}
+		for k, v := range values {
+			tampered[k] = v
+		}
+		tampered.Set(key, value)
+		if err := signer.Verify(LinkDownload, tampered); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("Verify() with %s changed = %v", key, err)
+		}
+	}
+	for _, key := range []string{"fallback", "template", "level"} {
+		added := url.Values{}
+		for k, v := range values {
+			added[k] = v
+		}
+		added.Set(key, "x")
+		if err := signer.Verify(LinkDownload, added); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("Verify() with %s added = %v", key, err)
+		}
+	}
+	dropped := url.Values{}
+	for k, v := range values {
+		dropped[k] = v
+	}
+	dropped.Del("codec")
+	if err := signer.Verify(LinkDownload, dropped); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Verify() without codec = %v", err)
+	}
+
+	signer.now = func() time.Time { return time.Now().Add(time.Hour) }
+	if err := signer.Verify(LinkDownload, values); !errors.Is(err, ErrLinkExpired) {
+		t.Errorf("Verify() after expiry = %v", err)
+	}
+}
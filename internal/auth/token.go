@@ -0,0 +1,381 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"wyapi-golang/internal/atomicfile"
+	"wyapi-golang/internal/config"
+)
+
+const (
+	ScopeRead     = "read"
+	ScopeDownload = "download"
+	ScopeAdmin    = "admin"
+
+	legacyTokenName = "default"
+)
+
+var (
+	ErrInvalidToken  = errors.New("invalid api token")
+	ErrTokenExpired  = errors.New("api token expired")
+	ErrQuotaExceeded = errors.New("api token daily quota exceeded")
+)
+
+// Token is a configured API credential. Admin scope implies every other scope.
+type Token struct {
+	Name           string
+	CookieOverride string
+	ExpiresAt      time.Time
+	DailyRequests  int64
+	DailyBytes     int64
+
+	secret []byte
+	scopes map[string]bool
+}
+
+func (t *Token) HasScope(scope string) bool {
+	if t == nil {
+		return false
+	}
+	return t.scopes[ScopeAdmin] || t.scopes[scope]
+}
+
+func (t *Token) Scopes() []string {
+	scopes := make([]string, 0, len(t.scopes))
+	for scope := range t.scopes {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+	return scopes
+}
+
+func (t *Token) Expired(now time.Time) bool {
+	return !t.ExpiresAt.IsZero() && now.After(t.ExpiresAt)
+}
+
+type Usage struct {
+	Day           string     `json:"day"`
+	Requests      int64      `json:"requests"`
+	Bytes         int64      `json:"bytes"`
+	TotalRequests int64      `json:"total_requests"`
+	TotalBytes    int64      `json:"total_bytes"`
+	LastUsedAt    *time.Time `json:"last_used_at,omitempty"`
+}
+
+type TokenStatus struct {
+	Name          string     `json:"name"`
+	Scopes        []string   `json:"scopes"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	Expired       bool       `json:"expired"`
+	DailyRequests int64      `json:"daily_requests"`
+	DailyBytes    int64      `json:"daily_bytes"`
+	Usage         Usage      `json:"usage"`
+}
+
+type Registry struct {
+	tokens []*Token
+
+	mu        sync.Mutex
+	usage     map[string]*Usage
+	usagePath string
+	dirty     bool
+	now       func() time.Time
+}
+
+// NewRegistry builds the token set from the security config. The legacy
+// single api_token, when set, is kept as an admin token named "default".
+func NewRegistry(cfg config.SecurityConfig) (*Registry, error) {
+	r := &Registry{
+		usage: map[string]*Usage{},
+		now:   time.Now,
+	}
+
+	if cfg.APIToken != "" {
+		r.tokens = append(r.tokens, &Token{
+			Name:   legacyTokenName,
+			secret: []byte(cfg.APIToken),
+			scopes: map[string]bool{ScopeAdmin: true},
+		})
+	}
+
+	if err := validCookieOverride(cfg.CookieOverride); err != nil {
+		return nil, errors.New("security " + err.Error())
+	}
+
+	seen := map[string]bool{legacyTokenName: cfg.APIToken != ""}
+	for _, item := range cfg.Tokens {
+		name := strings.TrimSpace(item.Name)
+		if name == "" {
+			return nil, errors.New("api token name empty")
+		}
+		if seen[name] {
+			return nil, errors.New("duplicate api token name: " + name)
+		}
+		seen[name] = true
+		if item.Token == "" {
+			return nil, errors.New("api token " + name + " has empty secret")
+		}
+
+		cookieOverride := strings.ToLower(strings.TrimSpace(item.CookieOverride))
+		if err := validCookieOverride(cookieOverride); err != nil {
+			return nil, errors.New("api token " + name + " " + err.Error())
+		}
+
+		token := &Token{
+			Name:           name,
+			CookieOverride: cookieOverride,
+			DailyRequests:  item.DailyRequests,
+			DailyBytes:     item.DailyMB * 1024 * 1024,
+			secret:         []byte(item.Token),
+			scopes:         map[string]bool{},
+		}
+		for _, scope := range item.Scopes {
+			scope = strings.ToLower(strings.TrimSpace(scope))
+			switch scope {
+			case ScopeRead, ScopeDownload, ScopeAdmin:
+				token.scopes[scope] = true
+			default:
+				return nil, errors.New("api token " + name + " has unknown scope: " + scope)
+			}
+		}
+		if len(token.scopes) == 0 {
+			token.scopes[ScopeRead] = true
+		}
+		if item.ExpiresAt != "" {
+			expiresAt, err := time.Parse(time.RFC3339, item.ExpiresAt)
+			if err != nil {
+				return nil, errors.New("api token " + name + " has invalid expires_at: " + err.Error())
+			}
+			token.ExpiresAt = expiresAt
+		}
+		r.tokens = append(r.tokens, token)
+	}
+
+	return r, nil
+}
+
+func validCookieOverride(mode string) error {
+	switch mode {
+	case "", config.CookieOverrideDisabled, config.CookieOverrideOptional, config.CookieOverrideRequired:
+		return nil
+	}
+	return errors.New("has unknown cookie_override: " + mode)
+}
+
+// Authenticate looks up the token matching secret. Every configured token is
+// compared in constant time so timing does not reveal which one matched.
+func (r *Registry) Authenticate(secret string) (*Token, error) {
+	if r == nil || secret == "" {
+		return nil, ErrInvalidToken
+	}
+
+	var matched *Token
+	input := []byte(secret)
+	for _, token := range r.tokens {
+		if subtle.ConstantTimeCompare(input, token.secret) == 1 && matched == nil {
+			matched = token
+		}
+	}
+	if matched == nil {
+		return nil, ErrInvalidToken
+	}
+	if matched.Expired(r.now()) {
+		return nil, ErrTokenExpired
+	}
+	return matched, nil
+}
+
+// HasScope reports whether any configured token grants scope.
+func (r *Registry) HasScope(scope string) bool {
+	if r == nil {
+		return false
+	}
+	for _, token := range r.tokens {
+		if token.HasScope(scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reserve counts a request against token's daily quota, or reports
+// ErrQuotaExceeded once today's request or byte quota is used up. Checking
+// and counting happen under one lock, so concurrent requests cannot all
+// slip through on the last unit of quota.
+func (r *Registry) Reserve(token *Token) error {
+	if r == nil || token == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	usage := r.usageLocked(token.Name)
+	if token.DailyRequests > 0 && usage.Requests >= token.DailyRequests {
+		return ErrQuotaExceeded
+	}
+	if token.DailyBytes > 0 && usage.Bytes >= token.DailyBytes {
+		return ErrQuotaExceeded
+	}
+	usage.Requests++
+	usage.TotalRequests++
+	now := r.now()
+	usage.LastUsedAt = &now
+	r.dirty = true
+	return nil
+}
+
+// Record adds the response bytes of a request reserved with Reserve to the
+// usage of token.
+func (r *Registry) Record(token *Token, bytes int64) {
+	if r == nil || token == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	usage := r.usageLocked(token.Name)
+	usage.Bytes += bytes
+	usage.TotalBytes += bytes
+	r.dirty = true
+}
+
+// LoadUsage restores the usage counters saved in path and makes SaveUsage
+// write there. A missing file starts every token from zero; counters of
+// tokens no longer configured are dropped.
+func (r *Registry) LoadUsage(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.usagePath = path
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	saved := map[string]*Usage{}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+	for _, token := range r.tokens {
+		if usage, ok := saved[token.Name]; ok && usage != nil {
+			r.usage[token.Name] = usage
+		}
+	}
+	return nil
+}
+
+// SaveUsage writes the usage counters to the file given to LoadUsage if
+// they changed since the last save.
+func (r *Registry) SaveUsage() error {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.usagePath == "" || !r.dirty {
+		return nil
+	}
+	data, err := json.MarshalIndent(r.usage, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := atomicfile.WriteBytes(r.usagePath, data, 0644); err != nil {
+		return err
+	}
+	r.dirty = false
+	return nil
+}
+
+// PersistUsage saves the usage counters every interval until ctx is done.
+// Usage recorded after the last save is lost if the process dies, so the
+// caller should SaveUsage once more on shutdown.
+func (r *Registry) PersistUsage(ctx context.Context, interval time.Duration, onError func(error)) {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.SaveUsage(); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+func (r *Registry) Status() []TokenStatus {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	result := make([]TokenStatus, 0, len(r.tokens))
+	for _, token := range r.tokens {
+		status := TokenStatus{
+			Name:          token.Name,
+			Scopes:        token.Scopes(),
+			Expired:       token.Expired(now),
+			DailyRequests: token.DailyRequests,
+			DailyBytes:    token.DailyBytes,
+			Usage:         *r.usageLocked(token.Name),
+		}
+		if !token.ExpiresAt.IsZero() {
+			expiresAt := token.ExpiresAt
+			status.ExpiresAt = &expiresAt
+		}
+		result = append(result, status)
+	}
+	return result
+}
+
+func (r *Registry) usageLocked(name string) *Usage {
+	day := r.now().Format("2006-01-02")
+	usage, ok := r.usage[name]
+	if !ok {
+		usage = &Usage{Day: day}
+		r.usage[name] = usage
+	}
+	if usage.Day != day {
+		usage.Day = day
+		usage.Requests = 0
+		usage.Bytes = 0
+	}
+	return usage
+}
+
+type tokenKey struct{}
+
+func WithToken(ctx context.Context, token *Token) context.Context {
+	return context.WithValue(ctx, tokenKey{}, token)
+}
+
+func TokenFromContext(ctx context.Context) *Token {
+	if ctx == nil {
+		return nil
+	}
+	token, _ := ctx.Value(tokenKey{}).(*Token)
+	return token
+}
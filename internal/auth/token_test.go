@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"wyapi-golang/internal/config"
+)
+
+var quotaConfig = config.SecurityConfig{Tokens: []config.APITokenConfig{
+	{Name: "bot", Token: "bot-secret", DailyRequests: 2},
+}}
+
+// restart builds a fresh registry over the same usage file, as the server
+// does on startup.
+func restart(t *testing.T, path string, now time.Time) (*Registry, *Token) {
+	t.Helper()
+	r, err := NewRegistry(quotaConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.now = func() time.Time { return now }
+	if err := r.LoadUsage(path); err != nil {
+		t.Fatal(err)
+	}
+	token, err := r.Authenticate("bot-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, token
+}
+
+func TestQuotaSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token_usage.json")
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.Local)
+
+	r, token := restart(t, path, now)
+	for i := 0; i < 2; i++ {
+		if err := r.Reserve(token); err != nil {
+			t.Fatalf("request %d: %v", i+1, err)
+		}
+		r.Record(token, 100)
+	}
+	if err := r.SaveUsage(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, token = restart(t, path, now.Add(time.Hour))
+	if err := r.Reserve(token); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Reserve() after restart = %v, want ErrQuotaExceeded", err)
+	}
+	usage := r.Status()[0].Usage
+	if usage.Requests != 2 || usage.Bytes != 200 || usage.TotalRequests != 2 {
+		t.Errorf("usage after restart = %+v", usage)
+	}
+
+	// The next day starts over, keeping the totals.
+	r, token = restart(t, path, now.Add(24*time.Hour))
+	if err := r.Reserve(token); err != nil {
+		t.Errorf("Reserve() the next day = %v", err)
+	}
+	if usage := r.Status()[0].Usage; usage.Requests != 1 || usage.TotalRequests != 3 {
+		t.Errorf("usage the next day = %+v", usage)
+	}
+}
+
+func TestSaveUsageOnlyWhenChanged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token_usage.json")
+	r, token := restart(t, path, time.Now())
+
+	if err := r.SaveUsage(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("usage file written without any usage: %v", err)
+	}
+
+	r.Reserve(token)
+	if err := r.SaveUsage(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("usage file not written: %v", err)
+	}
+}
+
+func TestLoadUsageDropsRemovedTokens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token_usage.json")
+	data := `{"bot": {"day": "2026-03-01", "requests": 1}, "retired": {"day": "2026-03-01", "requests": 9}}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, _ := restart(t, path, time.Date(2026, 3, 1, 12, 0, 0, 0, time.Local))
+	if _, ok := r.usage["retired"]; ok {
+		t.Error("usage of a token no longer configured was kept")
+	}
+	if usage := r.Status()[0].Usage; usage.Requests != 1 {
+		t.Errorf("usage = %+v, want 1 request", usage)
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := new(Registry).LoadUsage(path); err == nil {
+		t.Error("LoadUsage() of a corrupt file succeeded")
+	}
+}
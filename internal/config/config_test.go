@@ -0,0 +1,78 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// legacyCORS is the cors section of a config file written before the
+// cookie override, profile and rate limit headers existed.
+const legacyCORS = `{
+  "cors": {
+    "allowed_origins": ["https://music.example.com"],
+    "allowed_methods": ["GET", "POST", "OPTIONS"],
+    "allowed_headers": ["Content-Type", "Authorization", "X-API-Token", "X-API-Key"],
+    "exposed_headers": ["X-Download-Message", "X-Download-Filename"]
+  }
+}`
+
+func loadConfig(t *testing.T, data string) *Config {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, created, err := LoadOrCreate(path)
+	if err != nil || created {
+		t.Fatalf("LoadOrCreate() = %v, created %v", err, created)
+	}
+	return cfg
+}
+
+func TestApplyDefaultsUpgradesAllowedHeaders(t *testing.T) {
+	cfg := loadConfig(t, legacyCORS)
+	want := []string{"Content-Type", "Authorization", "X-API-Token", "X-API-Key", "X-Netease-Cookie", "X-Netease-Profile"}
+	if !reflect.DeepEqual(cfg.CORS.AllowedHeaders, want) {
+		t.Errorf("allowed_headers = %q, want %q", cfg.CORS.AllowedHeaders, want)
+	}
+
+	// Applying the defaults again adds nothing twice.
+	cfg.ApplyDefaults()
+	if !reflect.DeepEqual(cfg.CORS.AllowedHeaders, want) {
+		t.Errorf("allowed_headers after a second pass = %q", cfg.CORS.AllowedHeaders)
+	}
+}
+
+func TestApplyDefaultsUpgradesExposedHeaders(t *testing.T) {
+	cfg := loadConfig(t, legacyCORS)
+	want := []string{"X-Download-Message", "X-Download-Filename", "X-Transcode", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After"}
+	if !reflect.DeepEqual(cfg.CORS.ExposedHeaders, want) {
+		t.Errorf("exposed_headers = %q, want %q", cfg.CORS.ExposedHeaders, want)
+	}
+
+	defaults := DefaultConfig()
+	fresh := &Config{}
+	fresh.ApplyDefaults()
+	if !reflect.DeepEqual(fresh.CORS.ExposedHeaders, defaults.CORS.ExposedHeaders) {
+		t.Errorf("empty exposed_headers = %q, want the defaults", fresh.CORS.ExposedHeaders)
+	}
+}
+
+func TestAppendMissingHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []string
+		want    []string
+	}{
+		{name: "present in another case", headers: []string{"x-netease-cookie"}, want: []string{"x-netease-cookie", "X-Netease-Profile"}},
+		{name: "wildcard", headers: []string{"*"}, want: []string{"*"}},
+		{name: "all present", headers: []string{"X-Netease-Profile", "X-Netease-Cookie"}, want: []string{"X-Netease-Profile", "X-Netease-Cookie"}},
+	}
+	for _, tt := range tests {
+		if got := appendMissingHeaders(tt.headers, featureRequestHeaders); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: appendMissingHeaders() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package cookie
+
+import "context"
+
+type overrideKey struct{}
+
+// WithOverride attaches caller-supplied cookies to ctx. They take precedence
+// over the operator cookie for that request only and are never persisted.
+func WithOverride(ctx context.Context, cookies map[string]string) context.Context {
+	return context.WithValue(ctx, overrideKey{}, cookies)
+}
+
+// OverrideFromContext returns the per-request cookies set by WithOverride.
+func OverrideFromContext(ctx context.Context) (map[string]string, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	cookies, ok := ctx.Value(overrideKey{}).(map[string]string)
+	return cookies, ok
+}
+
+// Resolve returns the cookies a request should use: the per-request override
+// if present, otherwise the operator cookie managed by m.
+func (m *Manager) Resolve(ctx context.Context) map[string]string {
+	if override, ok := OverrideFromContext(ctx); ok {
+		return copyCookies(override)
+	}
+	if m == nil {
+		return map[string]string{}
+	}
+	parsed, err := m.Parse()
+	if err != nil {
+		return map[string]string{}
+	}
+	return parsed
+}
@@ -1,13 +1,37 @@
 package cookie
 
 import (
+	"context"
 	"errors"
+	"net/http"
 	"os"
+	"sort"
 	"strings"
+	"sync"
+	"time"
+
+	"wyapi-golang/internal/atomicfile"
 )
 
+// sessionCookieNames are the NetEase cookies worth persisting when the
+// upstream rotates them via Set-Cookie, even if the file does not hold them yet.
+var sessionCookieNames = map[string]bool{
+	"MUSIC_U":       true,
+	"MUSIC_A":       true,
+	"MUSIC_R_T":     true,
+	"MUSIC_A_T":     true,
+	"__csrf":        true,
+	"__remember_me": true,
+}
+
 type Manager struct {
 	FilePath string
+
+	mu      sync.RWMutex
+	cache   map[string]string
+	modTime time.Time
+	size    int64
+	loaded  bool
 }
 
 func NewManager(path string) *Manager {
@@ -49,18 +73,156 @@ func (m *Manager) Write(content string) error {
 	if m.FilePath == "" {
 		return errors.New("cookie file path empty")
 	}
-	if strings.TrimSpace(content) == "" {
+	content = strings.TrimSpace(content)
+	if content == "" {
 		return errors.New("cookie content empty")
 	}
-	return os.WriteFile(m.FilePath, []byte(strings.TrimSpace(content)), 0644)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.storeLocked(ParseCookieString(content), content)
 }
 
+// Parse returns the cached cookies, re-reading the file only when its
+// modification time or size changed since the last load.
 func (m *Manager) Parse() (map[string]string, error) {
+	if _, err := m.reloadIfChanged(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return copyCookies(m.cache), nil
+}
+
+// Watch polls the cookie file until ctx is done and reloads the cache when it
+// changes on disk. onChange, if set, is called after each reload.
+func (m *Manager) Watch(ctx context.Context, interval time.Duration, onChange func(error)) {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := m.reloadIfChanged()
+			if (changed || err != nil) && onChange != nil {
+				onChange(err)
+			}
+		}
+	}
+}
+
+// Merge folds Set-Cookie values returned by NetEase into the store and
+// persists them. Only cookies already present in the store or known session
+// cookies are kept; deletions are ignored so a stray logout response cannot
+// wipe the operator cookie. It reports whether the file was rewritten.
+func (m *Manager) Merge(cookies []*http.Cookie) (bool, error) {
+	if len(cookies) == 0 || m.FilePath == "" {
+		return false, nil
+	}
+	if _, err := m.reloadIfChanged(); err != nil {
+		return false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.cache) == 0 {
+		return false, nil
+	}
+
+	now := time.Now()
+	next := copyCookies(m.cache)
+	changed := false
+	for _, c := range cookies {
+		if c == nil || c.Name == "" || c.Value == "" {
+			continue
+		}
+		if c.MaxAge < 0 || (!c.Expires.IsZero() && c.Expires.Before(now)) {
+			continue
+		}
+		if _, ok := next[c.Name]; !ok && !sessionCookieNames[c.Name] {
+			continue
+		}
+		if next[c.Name] == c.Value {
+			continue
+		}
+		next[c.Name] = c.Value
+		changed = true
+	}
+
+	if !changed {
+		return false, nil
+	}
+	if err := m.storeLocked(next, FormatCookieString(next)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (m *Manager) reloadIfChanged() (bool, error) {
+	if m.FilePath == "" {
+		return false, errors.New("cookie file path empty")
+	}
+
+	stat, err := os.Stat(m.FilePath)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	var modTime time.Time
+	var size int64
+	if err == nil {
+		modTime = stat.ModTime()
+		size = stat.Size()
+	}
+
+	m.mu.RLock()
+	fresh := m.loaded && m.modTime.Equal(modTime) && m.size == size
+	m.mu.RUnlock()
+	if fresh {
+		return false, nil
+	}
+
 	content, err := m.Read()
 	if err != nil {
-		return nil, err
+		return false, err
 	}
-	return ParseCookieString(content), nil
+
+	m.mu.Lock()
+	m.cache = ParseCookieString(content)
+	m.modTime = modTime
+	m.size = size
+	m.loaded = true
+	m.mu.Unlock()
+	return true, nil
+}
+
+func (m *Manager) storeLocked(parsed map[string]string, content string) error {
+	if err := atomicfile.WriteBytes(m.FilePath, []byte(content), 0644); err != nil {
+		return err
+	}
+
+	m.cache = parsed
+	m.loaded = true
+	if stat, err := os.Stat(m.FilePath); err == nil {
+		m.modTime = stat.ModTime()
+		m.size = stat.Size()
+	}
+	return nil
+}
+
+func copyCookies(src map[string]string) map[string]string {
+	out := make(map[string]string, len(src))
+	for k, v := range src {
+		out[k] = v
+	}
+	return out
 }
 
 func ParseCookieString(cookieString string) map[string]string {
@@ -102,3 +264,19 @@ func ParseCookieString(cookieString string) map[string]string {
 
 	return result
 }
+
+// FormatCookieString serialises cookies as a single "k=v; k=v" line with
+// stable key order, the same shape cookie.txt is documented to use.
+func FormatCookieString(cookies map[string]string) string {
+	keys := make([]string, 0, len(cookies))
+	for k := range cookies {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+cookies[k])
+	}
+	return strings.Join(pairs, "; ")
+}
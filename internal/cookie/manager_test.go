@@ -0,0 +1,159 @@
+package cookie
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestManager(t *testing.T, content string) *Manager {
+	t.Helper()
+	m := NewManager(filepath.Join(t.TempDir(), "cookie.txt"))
+	if err := os.WriteFile(m.FilePath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func parsed(t *testing.T, m *Manager) map[string]string {
+	t.Helper()
+	cookies, err := m.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cookies
+}
+
+func TestParseReloadsOnModTimeOrSize(t *testing.T) {
+	m := newTestManager(t, "MUSIC_U=aaaa")
+	stamp := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(m.FilePath, stamp, stamp); err != nil {
+		t.Fatal(err)
+	}
+	if got := parsed(t, m)["MUSIC_U"]; got != "aaaa" {
+		t.Fatalf("MUSIC_U = %q, want aaaa", got)
+	}
+
+	// Same size and modification time: the cache is trusted.
+	if err := os.WriteFile(m.FilePath, []byte("MUSIC_U=bbbb"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(m.FilePath, stamp, stamp); err != nil {
+		t.Fatal(err)
+	}
+	if got := parsed(t, m)["MUSIC_U"]; got != "aaaa" {
+		t.Errorf("unchanged stat: MUSIC_U = %q, want the cached aaaa", got)
+	}
+
+	// A new modification time alone triggers a reload.
+	later := stamp.Add(time.Minute)
+	if err := os.Chtimes(m.FilePath, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if got := parsed(t, m)["MUSIC_U"]; got != "bbbb" {
+		t.Errorf("new mtime: MUSIC_U = %q, want bbbb", got)
+	}
+
+	// So does a new size with the old modification time.
+	if err := os.WriteFile(m.FilePath, []byte("MUSIC_U=cccccc"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(m.FilePath, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if got := parsed(t, m)["MUSIC_U"]; got != "cccccc" {
+		t.Errorf("new size: MUSIC_U = %q, want cccccc", got)
+	}
+
+	if err := os.Remove(m.FilePath); err != nil {
+		t.Fatal(err)
+	}
+	if got := parsed(t, m); len(got) != 0 {
+		t.Errorf("deleted file: cookies = %v, want none", got)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	expired := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name    string
+		cookies []*http.Cookie
+		changed bool
+		want    map[string]string
+	}{
+		{
+			name:    "rotated value",
+			cookies: []*http.Cookie{{Name: "MUSIC_U", Value: "new"}},
+			changed: true,
+			want:    map[string]string{"MUSIC_U": "new", "NMTID": "abc"},
+		},
+		{
+			name:    "known session cookie",
+			cookies: []*http.Cookie{{Name: "MUSIC_A_T", Value: "123"}, {Name: "__csrf", Value: "token"}},
+			changed: true,
+			want:    map[string]string{"MUSIC_U": "old", "NMTID": "abc", "MUSIC_A_T": "123", "__csrf": "token"},
+		},
+		{
+			name:    "cookie already in the file",
+			cookies: []*http.Cookie{{Name: "NMTID", Value: "xyz"}},
+			changed: true,
+			want:    map[string]string{"MUSIC_U": "old", "NMTID": "xyz"},
+		},
+		{
+			name:    "unknown cookie",
+			cookies: []*http.Cookie{{Name: "JSESSIONID-WYYY", Value: "tracking"}, {Name: "_iuqxldmzr_", Value: "32"}},
+			want:    map[string]string{"MUSIC_U": "old", "NMTID": "abc"},
+		},
+		{
+			name: "deletions",
+			cookies: []*http.Cookie{
+				{Name: "MUSIC_U", Value: "deleted", MaxAge: -1},
+				{Name: "NMTID", Value: "deleted", Expires: expired},
+				{Name: "MUSIC_U", Value: ""},
+			},
+			want: map[string]string{"MUSIC_U": "old", "NMTID": "abc"},
+		},
+		{
+			name:    "same value",
+			cookies: []*http.Cookie{{Name: "MUSIC_U", Value: "old"}, nil},
+			want:    map[string]string{"MUSIC_U": "old", "NMTID": "abc"},
+		},
+	}
+	for _, tt := range tests {
+		m := newTestManager(t, "MUSIC_U=old; NMTID=abc")
+		changed, err := m.Merge(tt.cookies)
+		if err != nil {
+			t.Errorf("%s: Merge() error = %v", tt.name, err)
+			continue
+		}
+		if changed != tt.changed {
+			t.Errorf("%s: Merge() = %v, want %v", tt.name, changed, tt.changed)
+		}
+		if got := parsed(t, m); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: cookies = %v, want %v", tt.name, got, tt.want)
+		}
+
+		// What Merge reports as written must be on disk, not only cached.
+		data, err := os.ReadFile(m.FilePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ParseCookieString(string(data)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: file = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMergeLeavesEmptyStoreAlone(t *testing.T) {
+	m := newTestManager(t, "")
+	changed, err := m.Merge([]*http.Cookie{{Name: "MUSIC_U", Value: "guest"}})
+	if err != nil || changed {
+		t.Errorf("Merge() into an empty store = %v, %v; want nothing written", changed, err)
+	}
+	if data, _ := os.ReadFile(m.FilePath); len(data) != 0 {
+		t.Errorf("cookie file = %q, want it left empty", data)
+	}
+}
@@ -0,0 +1,269 @@
+package cover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"wyapi-golang/internal/atomicfile"
+	"wyapi-golang/internal/cookie"
+	"wyapi-golang/internal/netease"
+)
+
+const (
+	KindAlbum = "album"
+	KindSong  = "song"
+
+	FormatJPEG = "jpg"
+	FormatWebP = "webp"
+)
+
+var (
+	ErrUnknownKind   = errors.New("unknown cover kind")
+	ErrUnknownFormat = errors.New("unknown cover format")
+	ErrNoCover       = errors.New("no cover available")
+)
+
+// Image is a cover held in the disk cache.
+type Image struct {
+	Path        string
+	ContentType string
+	ModTime     time.Time
+	ETag        string
+}
+
+// Sizes are the edge lengths covers are cached at. Requested sizes are
+// rounded up to one of them so a cover is stored a handful of times, not
+// once per pixel count a client happens to ask for.
+var Sizes = []int{64, 128, 256, 512, 1000, 2000}
+
+// RoundSize returns the smallest of Sizes that is at least size, or 0 (the
+// original) for 0 and sizes beyond the largest.
+func RoundSize(size int) int {
+	for _, s := range Sizes {
+		if size > 0 && size <= s {
+			return s
+		}
+	}
+	return 0
+}
+
+// Cache fetches album and song covers from the NetEase image CDN and keeps
+// them on disk for maxAge. Nothing is resized or converted locally: size
+// and WebP are CDN query parameters, and the Content-Type the CDN answers
+// with decides what is cached. Prune keeps the directory within maxBytes.
+type Cache struct {
+	dir      string
+	maxAge   time.Duration
+	maxBytes int64
+	client   *netease.Client
+	cookies  *cookie.Manager
+}
+
+func NewCache(dir string, maxAge time.Duration, maxBytes int64, client *netease.Client, cookies *cookie.Manager) *Cache {
+	return &Cache{dir: dir, maxAge: maxAge, maxBytes: maxBytes, client: client, cookies: cookies}
+}
+
+// Get returns the cover of the album or song id at size x size pixels (0
+// for the original), rounded with RoundSize, in format ("" or jpg for the
+// format NetEase stores, or webp). A WebP request the CDN does not honour
+// yields the original format, and later WebP requests are served that copy
+// until it expires instead of asking the CDN again; callers must use
+// Image.ContentType, read from the cached bytes, rather than assume the
+// requested format. A stale cached copy is returned when refreshing it
+// fails.
+func (c *Cache) Get(ctx context.Context, kind string, id int64, size int, format string) (*Image, error) {
+	if kind != KindAlbum && kind != KindSong {
+		return nil, ErrUnknownKind
+	}
+	if format == "" || format == "jpeg" {
+		format = FormatJPEG
+	}
+	if format != FormatJPEG && format != FormatWebP {
+		return nil, ErrUnknownFormat
+	}
+
+	size = RoundSize(size)
+	if format == FormatWebP && c.fresh(c.fallbackPath(kind, id, size)) {
+		format = FormatJPEG
+	}
+	path := c.path(kind, id, size, format)
+	stat, statErr := os.Stat(path)
+	if statErr == nil && (c.maxAge <= 0 || time.Since(stat.ModTime()) < c.maxAge) {
+		return openImage(path)
+	}
+
+	fetched, err := c.fetch(ctx, kind, id, size, format)
+	if err != nil {
+		if statErr == nil {
+			return openImage(path)
+		}
+		return nil, err
+	}
+	return openImage(fetched)
+}
+
+func (c *Cache) path(kind string, id int64, size int, format string) string {
+	return filepath.Join(c.dir, fmt.Sprintf("%s-%d-%d.%s", kind, id, size, format))
+}
+
+// fallbackPath is an empty marker recording that the CDN answered a WebP
+// request for this cover with the original format.
+func (c *Cache) fallbackPath(kind string, id int64, size int) string {
+	return c.path(kind, id, size, "nowebp")
+}
+
+func (c *Cache) fresh(path string) bool {
+	stat, err := os.Stat(path)
+	return err == nil && (c.maxAge <= 0 || time.Since(stat.ModTime()) < c.maxAge)
+}
+
+// fetch downloads the cover into the cache and returns where it was
+// stored. When WebP was asked for but the CDN sent something else, the
+// image is what an original-format request would get and is cached as
+// that, so a .webp file always holds WebP, and the fallback is recorded.
+func (c *Cache) fetch(ctx context.Context, kind string, id int64, size int, format string) (string, error) {
+	picURL, err := c.picURL(ctx, kind, id)
+	if err != nil {
+		return "", err
+	}
+	if picURL == "" {
+		return "", ErrNoCover
+	}
+
+	stream, contentType, err := c.client.FetchImage(ctx, netease.SizedPicURL(picURL, size, format))
+	if err != nil {
+		return "", err
+	}
+	defer stream.Close()
+	fallback := format == FormatWebP && !strings.HasPrefix(contentType, "image/webp")
+	if fallback {
+		format = FormatJPEG
+	}
+	path := c.path(kind, id, size, format)
+
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return "", err
+	}
+	if fallback {
+		if err := os.WriteFile(c.fallbackPath(kind, id, size), nil, 0644); err != nil {
+			return "", err
+		}
+	}
+	// Concurrent requests for the same cover each write their own temporary
+	// file; whichever rename lands last wins with identical content.
+	if err := atomicfile.Write(path, stream, 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// Prune deletes covers fetched more than twice maxAge ago, which Get no
+// longer serves even as a fallback, and leftover temporary files; then the
+// oldest covers until the cache fits in maxBytes.
+func (c *Cache) Prune(ctx context.Context) (removed int, freed int64, err error) {
+	entries, err := os.ReadDir(c.dir)
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	type cached struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []cached
+	var total int64
+	now := time.Now()
+	remove := func(path string, size int64) {
+		if err := os.Remove(path); err == nil {
+			removed++
+			freed += size
+		}
+	}
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return removed, freed, ctx.Err()
+		}
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		path := filepath.Join(c.dir, entry.Name())
+		age := now.Sub(info.ModTime())
+		switch {
+		case strings.HasSuffix(entry.Name(), ".tmp"):
+			if age > time.Hour {
+				remove(path, info.Size())
+			}
+		case c.maxAge > 0 && age > 2*c.maxAge:
+			remove(path, info.Size())
+		default:
+			files = append(files, cached{path: path, size: info.Size(), modTime: info.ModTime()})
+			total += info.Size()
+		}
+	}
+
+	if c.maxBytes <= 0 || total <= c.maxBytes {
+		return removed, freed, nil
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	for _, file := range files {
+		if total <= c.maxBytes {
+			break
+		}
+		remove(file.path, file.size)
+		total -= file.size
+	}
+	return removed, freed, nil
+}
+
+func (c *Cache) picURL(ctx context.Context, kind string, id int64) (string, error) {
+	if kind == KindAlbum {
+		album, err := c.client.GetAlbumDetail(ctx, id, c.cookies.Resolve(ctx))
+		if err != nil {
+			return "", err
+		}
+		return album.PicURL, nil
+	}
+
+	detail, err := c.client.GetSongDetail(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if len(detail.Songs) == 0 {
+		return "", &netease.Error{Kind: netease.KindNotFound, Op: "song detail", Message: "song not found"}
+	}
+	return detail.Songs[0].Al.PicURL, nil
+}
+
+func openImage(path string) (*Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(file, head)
+	return &Image{
+		Path:        path,
+		ContentType: http.DetectContentType(head[:n]),
+		ModTime:     stat.ModTime(),
+		ETag:        fmt.Sprintf(`"%x-%x"`, stat.ModTime().UnixNano(), stat.Size()),
+	}, nil
+}
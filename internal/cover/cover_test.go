@@ -0,0 +1,62 @@
+package cover
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// jpegHead is enough of a JPEG for http.DetectContentType.
+var jpegHead = []byte{0xff, 0xd8, 0xff, 0xe0, 0x00, 0x10, 'J', 'F', 'I', 'F', 0x00}
+
+func TestGetServesRecordedWebPFallback(t *testing.T) {
+	// No client: any attempt to reach NetEase would panic.
+	c := NewCache(t.TempDir(), time.Hour, 0, nil, nil)
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	jpeg := c.path(KindAlbum, 1, 512, FormatJPEG)
+	if err := os.WriteFile(jpeg, jpegHead, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(c.fallbackPath(KindAlbum, 1, 512), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	image, err := c.Get(context.Background(), KindAlbum, 1, 500, FormatWebP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if image.Path != jpeg || image.ContentType != "image/jpeg" {
+		t.Errorf("Get(webp) = %s (%s), want the cached JPEG", image.Path, image.ContentType)
+	}
+}
+
+func TestGetIgnoresExpiredWebPFallback(t *testing.T) {
+	c := NewCache(t.TempDir(), time.Hour, 0, nil, nil)
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	webp := c.path(KindSong, 2, 0, FormatWebP)
+	if err := os.WriteFile(webp, []byte("RIFF\x00\x00\x00\x00WEBPVP8 "), 0644); err != nil {
+		t.Fatal(err)
+	}
+	marker := c.fallbackPath(KindSong, 2, 0)
+	if err := os.WriteFile(marker, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(marker, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	image, err := c.Get(context.Background(), KindSong, 2, 0, FormatWebP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(image.Path) != filepath.Base(webp) || image.ContentType != "image/webp" {
+		t.Errorf("Get(webp) = %s (%s), want the cached WebP", image.Path, image.ContentType)
+	}
+}
@@ -0,0 +1,33 @@
+package diskusage
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Usage describes the filesystem holding a path, in bytes. Free is the
+// space available to the current user.
+type Usage struct {
+	Total uint64 `json:"total_bytes"`
+	Free  uint64 `json:"free_bytes"`
+}
+
+// Stat reports usage for the filesystem containing path. If path does not
+// exist yet, its nearest existing parent is used.
+func Stat(path string) (Usage, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return Usage{}, err
+	}
+	for {
+		if _, err := os.Stat(abs); err == nil {
+			break
+		}
+		parent := filepath.Dir(abs)
+		if parent == abs {
+			break
+		}
+		abs = parent
+	}
+	return stat(abs)
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+
+package diskusage
+
+import "syscall"
+
+func stat(path string) (Usage, error) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return Usage{}, err
+	}
+	return Usage{
+		Total: uint64(fs.Blocks) * uint64(fs.Bsize),
+		Free:  uint64(fs.Bavail) * uint64(fs.Bsize),
+	}, nil
+}
@@ -0,0 +1,53 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// BatchOptions tune DownloadBatch. Before runs ahead of each song, and an
+// error from it stops the batch; After reports how each song went.
+type BatchOptions struct {
+	Subdir   string
+	Template *Template
+	Before   func(songID int64) error
+	After    func(songID int64, written int64, err error)
+}
+
+// DownloadBatch downloads songIDs one after another into opts.Subdir of
+// the download directory. A failed song does not stop the others; the
+// errors of all failures are joined.
+func (d *Downloader) DownloadBatch(ctx context.Context, songIDs []int64, quality string, opts BatchOptions) (int, error) {
+	downloaded := 0
+	var errs []error
+	for _, songID := range songIDs {
+		if ctx.Err() != nil {
+			return downloaded, errors.Join(append(errs, ctx.Err())...)
+		}
+		if opts.Before != nil {
+			if err := opts.Before(songID); err != nil {
+				return downloaded, errors.Join(append(errs, err)...)
+			}
+		}
+		written, err := d.downloadOne(ctx, songID, quality, opts)
+		if opts.After != nil {
+			opts.After(songID, written, err)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%d: %w", songID, err))
+			continue
+		}
+		downloaded++
+	}
+	return downloaded, errors.Join(errs...)
+}
+
+func (d *Downloader) downloadOne(ctx context.Context, songID int64, quality string, opts BatchOptions) (int64, error) {
+	info, err := d.GetMusicInfo(ctx, songID, quality)
+	if err != nil {
+		return 0, err
+	}
+	_, written, err := d.DownloadInto(ctx, info, opts.Subdir, opts.Template)
+	return written, err
+}
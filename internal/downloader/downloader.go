@@ -8,14 +8,23 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
-	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"wyapi-golang/internal/cookie"
+	"wyapi-golang/internal/library"
 	"wyapi-golang/internal/netease"
+	"wyapi-golang/internal/probe"
+	"wyapi-golang/internal/transcode"
 )
 
-var illegalFilenameChars = regexp.MustCompile(`[<>:"/\\|?*]`)
+var chinaTime = time.FixedZone("CST", 8*3600)
+
+// ErrTruncated is returned when a downloaded file ends before its
+// container says it should.
+var ErrTruncated = errors.New("downloaded file is truncated")
 
 // MusicInfo represents normalized music metadata for download.
 type MusicInfo struct {
@@ -23,6 +32,7 @@ type MusicInfo struct {
 	Name     string `json:"name"`
 	Artists  string `json:"artists"`
 	Album    string `json:"album"`
+	AlbumID  int64  `json:"album_id,omitempty"`
 	PicURL   string `json:"pic_url"`
 	Duration int64  `json:"duration"`
 	FileType string `json:"file_type"`
@@ -31,12 +41,32 @@ type MusicInfo struct {
 	URL      string `json:"url"`
 	Lyric    string `json:"lyric"`
 	TLyric   string `json:"tlyric"`
+
+	AlbumArtist string `json:"album_artist,omitempty"`
+	Year        int    `json:"year,omitempty"`
+	Track       int    `json:"track,omitempty"`
+	Disc        int    `json:"disc,omitempty"`
+	// LocalPath is set when the song is served from the local library
+	// instead of the NetEase CDN; URL is empty in that case.
+	LocalPath string `json:"local_path,omitempty"`
 }
 
 type Downloader struct {
 	client        *netease.Client
 	cookieManager *cookie.Manager
 	downloadDir   string
+	library       *library.Library
+	template      *Template
+	retention     Retention
+	sidecar       Sidecar
+	transcoder    *transcode.Transcoder
+	replayGain    bool
+
+	sidecarMu   sync.Mutex
+	sidecarBusy map[string]bool
+
+	gainMu      sync.Mutex
+	pendingGain map[string]bool
 }
 
 func NewDownloader(client *netease.Client, cookieManager *cookie.Manager, downloadDir string) *Downloader {
@@ -47,46 +77,96 @@ func NewDownloader(client *netease.Client, cookieManager *cookie.Manager, downlo
 		client:        client,
 		cookieManager: cookieManager,
 		downloadDir:   downloadDir,
+		template:      defaultTemplate,
+	}
+}
+
+var defaultTemplate, _ = ParseTemplate(DefaultFilenameTemplate)
+
+// SetTemplate changes the template used when a request does not supply
+// its own; nil restores the default.
+func (d *Downloader) SetTemplate(tmpl *Template) {
+	if tmpl == nil {
+		tmpl = defaultTemplate
 	}
+	d.template = tmpl
+}
+
+// SetLibrary makes the downloader record written files in lib and reuse
+// copies already indexed there.
+func (d *Downloader) SetLibrary(lib *library.Library) {
+	d.library = lib
+}
+
+func (d *Downloader) Library() *library.Library {
+	return d.library
+}
+
+func (d *Downloader) Dir() string {
+	return d.downloadDir
 }
 
 func (d *Downloader) GetMusicInfo(ctx context.Context, songID int64, quality string) (*MusicInfo, error) {
+	return d.GetMusicInfoWithFallback(ctx, songID, []string{quality})
+}
+
+// GetMusicInfoWithFallback resolves the song at the first level in levels
+// that NetEase actually serves; MusicInfo.Quality reports the level obtained.
+func (d *Downloader) GetMusicInfoWithFallback(ctx context.Context, songID int64, levels []string) (*MusicInfo, error) {
 	if d.client == nil {
 		return nil, errors.New("netease client is nil")
 	}
-	cookies := map[string]string{}
-	if d.cookieManager != nil {
-		if parsed, err := d.cookieManager.Parse(); err == nil {
-			cookies = parsed
-		}
+	if len(levels) == 0 {
+		return nil, errors.New("no quality level requested")
+	}
+	if entry, ok := d.library.Best(songID, levels[0]); ok {
+		d.library.Touch(entry.Path)
+		return musicInfoFromEntry(entry), nil
 	}
+	cookies := d.cookieManager.Resolve(ctx)
 
-	urlResp, err := d.client.GetSongURL(ctx, songID, quality, cookies)
+	urlData, err := d.client.GetSongURLWithFallback(ctx, songID, levels, cookies)
 	if err != nil {
 		return nil, err
 	}
-	if urlResp == nil || len(urlResp.Data) == 0 || urlResp.Data[0].URL == "" {
-		return nil, errors.New("music url not available")
+
+	info, err := d.songMetadata(ctx, songID)
+	if err != nil {
+		return nil, err
+	}
+
+	lyricResp, _ := d.client.GetLyrics(ctx, songID, cookies)
+	if lyricResp != nil {
+		info.Lyric = lyricResp.Lrc.Lyric
+		info.TLyric = lyricResp.Tlyric.Lyric
+	}
+
+	info.Quality = urlData.Level
+	if info.Quality == "" {
+		info.Quality = levels[0]
+	}
+	info.FileType = strings.ToLower(urlData.Type)
+	if info.FileType == "" {
+		info.FileType = detectExtension(urlData.URL)
 	}
-	urlData := urlResp.Data[0]
+	info.FileSize = urlData.Size
+	info.URL = urlData.URL
+
+	return info, nil
+}
 
+// songMetadata fills the descriptive fields of MusicInfo from the song
+// detail API, without resolving a download URL.
+func (d *Downloader) songMetadata(ctx context.Context, songID int64) (*MusicInfo, error) {
 	detailResp, err := d.client.GetSongDetail(ctx, songID)
 	if err != nil {
 		return nil, err
 	}
 	if detailResp == nil || len(detailResp.Songs) == 0 {
-		return nil, errors.New("music detail not available")
+		return nil, &netease.Error{Kind: netease.KindNotFound, Op: "song detail", Message: "music detail not available"}
 	}
 	song := detailResp.Songs[0]
 
-	lyricResp, _ := d.client.GetLyrics(ctx, songID, cookies)
-	lyricText := ""
-	tlyricText := ""
-	if lyricResp != nil {
-		lyricText = lyricResp.Lrc.Lyric
-		tlyricText = lyricResp.Tlyric.Lyric
-	}
-
 	artists := make([]string, 0, len(song.Ar))
 	for _, artist := range song.Ar {
 		if artist.Name != "" {
@@ -94,81 +174,250 @@ func (d *Downloader) GetMusicInfo(ctx context.Context, songID int64, quality str
 		}
 	}
 
-	fileType := strings.ToLower(urlData.Type)
-	if fileType == "" {
-		fileType = detectExtension(urlData.URL)
-	}
-
 	info := &MusicInfo{
 		ID:       songID,
 		Name:     song.Name,
 		Artists:  strings.Join(artists, "/"),
 		Album:    song.Al.Name,
+		AlbumID:  song.Al.ID,
 		PicURL:   song.Al.PicURL,
 		Duration: song.Dt,
-		FileType: fileType,
-		FileSize: urlData.Size,
-		Quality:  quality,
-		URL:      urlData.URL,
-		Lyric:    lyricText,
-		TLyric:   tlyricText,
+		Track:    song.No,
+	}
+	if disc, err := strconv.Atoi(strings.TrimSpace(song.Cd)); err == nil {
+		info.Disc = disc
+	}
+	if song.PublishTime > 0 {
+		info.Year = time.UnixMilli(song.PublishTime).In(chinaTime).Year()
 	}
-
 	return info, nil
 }
 
-func (d *Downloader) DownloadToFile(ctx context.Context, info *MusicInfo) (string, int64, error) {
+// PreviewPath renders the path a download of songID would be saved under,
+// relative to the download directory. A nil tmpl uses the configured one.
+func (d *Downloader) PreviewPath(ctx context.Context, songID int64, quality string, fileType string, tmpl *Template) (string, *MusicInfo, error) {
+	if d.client == nil {
+		return "", nil, errors.New("netease client is nil")
+	}
+	info, err := d.songMetadata(ctx, songID)
+	if err != nil {
+		return "", nil, err
+	}
+	info.Quality = quality
+	info.FileType = fileType
+	return d.BuildPath(ctx, info, tmpl), info, nil
+}
+
+// BuildPath renders info through tmpl (the configured template when nil)
+// and appends the file extension. The album artist is looked up only when
+// the template uses it.
+func (d *Downloader) BuildPath(ctx context.Context, info *MusicInfo, tmpl *Template) string {
+	rel := d.renderPath(ctx, info, tmpl)
+	if info.FileType != "" {
+		rel += "." + info.FileType
+	}
+	return rel
+}
+
+func (d *Downloader) renderPath(ctx context.Context, info *MusicInfo, tmpl *Template) string {
+	if tmpl == nil {
+		tmpl = d.template
+	}
+	if tmpl.Uses("album_artist") {
+		d.fillAlbumArtist(ctx, info)
+	}
+	return tmpl.Render(info)
+}
+
+// fillAlbumArtist looks up the album artist, which the song detail API
+// does not return. Failures leave it empty.
+func (d *Downloader) fillAlbumArtist(ctx context.Context, info *MusicInfo) {
+	if info.AlbumArtist != "" || info.AlbumID == 0 || d.client == nil {
+		return
+	}
+	if album, err := d.client.GetAlbumDetail(ctx, info.AlbumID, d.cookieManager.Resolve(ctx)); err == nil && album != nil {
+		info.AlbumArtist = album.Artist
+	}
+}
+
+func (d *Downloader) DownloadToFile(ctx context.Context, info *MusicInfo, tmpl *Template) (string, int64, error) {
 	if info == nil {
 		return "", 0, errors.New("music info is nil")
 	}
-	if info.URL == "" {
+	if info.LocalPath != "" {
+		if stat, err := os.Stat(info.LocalPath); err == nil {
+			return info.LocalPath, stat.Size(), nil
+		}
+	}
+	if entry, ok := d.library.Best(info.ID, info.Quality); ok {
+		d.library.Touch(entry.Path)
+		return entry.Path, entry.Size, nil
+	}
+	return d.DownloadInto(ctx, info, "", tmpl)
+}
+
+// DownloadInto saves info under subdir of the download directory even when
+// a copy already exists elsewhere; a library copy is duplicated locally
+// instead of being fetched again.
+func (d *Downloader) DownloadInto(ctx context.Context, info *MusicInfo, subdir string, tmpl *Template) (string, int64, error) {
+	if info == nil {
+		return "", 0, errors.New("music info is nil")
+	}
+	if info.URL == "" && info.LocalPath == "" {
 		return "", 0, errors.New("download url empty")
 	}
 
-	if err := os.MkdirAll(d.downloadDir, 0755); err != nil {
+	filePath := filepath.Join(d.downloadDir, filepath.FromSlash(subdir), filepath.FromSlash(d.BuildPath(ctx, info, tmpl)))
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
 		return "", 0, err
 	}
 
-	filename := d.BuildFilename(info)
-	if !strings.HasSuffix(filename, "."+info.FileType) {
-		filename = filename + "." + info.FileType
+	filePath, reuse := d.resolveCollision(filePath, info)
+	if reuse {
+		stat, err := os.Stat(filePath)
+		if err == nil {
+			d.library.Touch(filePath)
+			d.queueSidecars(ctx, info, filePath)
+			return filePath, stat.Size(), nil
+		}
 	}
 
-	filePath := filepath.Join(d.downloadDir, filename)
-	if stat, err := os.Stat(filePath); err == nil && stat.Size() > 0 {
-		return filePath, stat.Size(), nil
+	if err := d.ensureSpace(info.FileSize); err != nil {
+		return "", 0, err
 	}
 
-	stream, _, err := d.client.FetchSongStream(ctx, info.URL)
+	var stream io.ReadCloser
+	var err error
+	if info.LocalPath != "" {
+		stream, err = os.Open(info.LocalPath)
+	} else {
+		stream, _, err = d.client.FetchSongStream(ctx, info.URL)
+	}
 	if err != nil {
 		return "", 0, err
 	}
 	defer stream.Close()
 
-	file, err := os.Create(filePath)
+	// Write to a temporary name first so an interrupted download never
+	// leaves a truncated file that a later request would reuse. The name
+	// is unique so concurrent downloads of one song do not share it.
+	file, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".*.part")
 	if err != nil {
 		return "", 0, err
 	}
-	defer file.Close()
-
+	partPath := file.Name()
+	// CreateTemp opens the file private to the owner; give it the mode
+	// os.Create would have.
+	_ = file.Chmod(0644)
 	written, err := io.Copy(file, stream)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
+		os.Remove(partPath)
 		return "", 0, err
 	}
+
+	// The type NetEase reports (or the URL extension) is not always what
+	// it serves; name the file after its content, and never keep a cut off
+	// transfer that later requests would reuse.
+	if probed, err := probe.File(partPath); err == nil {
+		if probed.Truncated {
+			os.Remove(partPath)
+			return "", 0, fmt.Errorf("%w: song %d", ErrTruncated, info.ID)
+		}
+		if ext := probed.Extension(); ext != info.FileType {
+			stem := strings.TrimSuffix(filePath, "."+info.FileType)
+			info.FileType = ext
+			filePath, _ = d.resolveCollision(stem+"."+ext, info)
+		}
+	}
+	if err := os.Rename(partPath, filePath); err != nil {
+		os.Remove(partPath)
+		return "", 0, err
+	}
+
+	if d.library != nil {
+		_, _ = d.library.Add(library.Entry{
+			ID:       info.ID,
+			Quality:  info.Quality,
+			Path:     filePath,
+			FileType: info.FileType,
+			AlbumID:  info.AlbumID,
+			Tags: library.Tags{
+				Title:   info.Name,
+				Artists: info.Artists,
+				Album:   info.Album,
+			},
+			DownloadedAt: time.Now(),
+		})
+	}
+	d.queueReplayGain(filePath)
+	d.queueSidecars(ctx, info, filePath)
 	return filePath, written, nil
 }
 
-func (d *Downloader) BuildFilename(info *MusicInfo) string {
-	base := fmt.Sprintf("%s - %s", info.Artists, info.Name)
-	base = illegalFilenameChars.ReplaceAllString(base, "_")
-	base = strings.TrimSpace(base)
-	if len(base) > 200 {
-		base = base[:200]
+// resolveCollision decides what to do when filePath already exists. The
+// file is reused only when it is known to hold the same song in the same
+// quality, by its library entry or else by its metadata sidecar. Any other
+// file, including one of unknown origin, gets a " (N)" suffix instead of
+// being overwritten or served in place of the requested song.
+func (d *Downloader) resolveCollision(filePath string, info *MusicInfo) (string, bool) {
+	ext := filepath.Ext(filePath)
+	stem := strings.TrimSuffix(filePath, ext)
+
+	candidate := filePath
+	for n := 2; ; n++ {
+		stat, err := os.Stat(candidate)
+		if err != nil || stat.Size() == 0 {
+			return candidate, false
+		}
+		if d.holdsSong(candidate, info) {
+			return candidate, true
+		}
+		candidate = fmt.Sprintf("%s (%d)%s", stem, n, ext)
+	}
+}
+
+func (d *Downloader) holdsSong(path string, info *MusicInfo) bool {
+	if info.ID == 0 {
+		return false
 	}
-	if base == "" {
-		base = "unknown"
+	if entry, ok := d.library.Lookup(path); ok && entry.ID != 0 {
+		return entry.ID == info.ID && entry.Quality == info.Quality
 	}
-	return base
+	id, quality := sidecarIdentity(path)
+	return id == info.ID && (quality == "" || quality == info.Quality)
+}
+
+// musicInfoFromEntry describes a library copy. The duration comes from
+// its loudness analysis or else from probing the file, so NeedsTranscode
+// can tell whether the copy already fits a bitrate target.
+func musicInfoFromEntry(entry *library.Entry) *MusicInfo {
+	info := &MusicInfo{
+		ID:        entry.ID,
+		Name:      entry.Tags.Title,
+		Artists:   entry.Tags.Artists,
+		Album:     entry.Tags.Album,
+		FileType:  entry.FileType,
+		FileSize:  entry.Size,
+		Quality:   entry.Quality,
+		LocalPath: entry.Path,
+	}
+	if entry.ReplayGain != nil && entry.ReplayGain.DurationMs > 0 {
+		info.Duration = entry.ReplayGain.DurationMs
+	} else if probed, err := probe.File(entry.Path); err == nil {
+		info.Duration = probed.DurationMs
+	}
+	return info
+}
+
+// BuildFilename returns the file name (without directories or extension)
+// tmpl (the configured template when nil) produces for info, e.g. for
+// Content-Disposition. It matches the last component of BuildPath.
+func (d *Downloader) BuildFilename(ctx context.Context, info *MusicInfo, tmpl *Template) string {
+	rel := d.renderPath(ctx, info, tmpl)
+	return rel[strings.LastIndex(rel, "/")+1:]
 }
 
 func detectExtension(rawURL string) string {
@@ -0,0 +1,204 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+
+	"wyapi-golang/internal/library"
+	"wyapi-golang/internal/probe"
+	"wyapi-golang/internal/transcode"
+)
+
+// ReferenceLoudness is the ReplayGain 2.0 target in LUFS.
+const ReferenceLoudness = -18.0
+
+// SetReplayGain enables loudness analysis of new downloads. It needs a
+// transcoder, since the analysis and tagging run through ffmpeg.
+func (d *Downloader) SetReplayGain(enabled bool) {
+	d.replayGain = enabled
+}
+
+// ReplayGainEnabled reports whether new downloads are analyzed.
+func (d *Downloader) ReplayGainEnabled() bool {
+	return d.replayGain && d.CanTranscode()
+}
+
+// queueReplayGain leaves a new download for the next UpdateReplayGain run.
+// Measuring and retagging are two full ffmpeg passes over the file, too
+// slow to make the download wait for, and the retag would change the file
+// after its size was reported.
+func (d *Downloader) queueReplayGain(path string) {
+	if !d.ReplayGainEnabled() {
+		return
+	}
+	d.gainMu.Lock()
+	defer d.gainMu.Unlock()
+	if d.pendingGain == nil {
+		d.pendingGain = map[string]bool{}
+	}
+	d.pendingGain[path] = true
+}
+
+func (d *Downloader) takePendingReplayGain() []string {
+	d.gainMu.Lock()
+	defer d.gainMu.Unlock()
+	paths := make([]string, 0, len(d.pendingGain))
+	for path := range d.pendingGain {
+		paths = append(paths, path)
+	}
+	d.pendingGain = nil
+	sort.Strings(paths)
+	return paths
+}
+
+func (d *Downloader) analyzeReplayGain(ctx context.Context, path string) (*library.ReplayGain, error) {
+	loudness, err := d.transcoder.Measure(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	gain := &library.ReplayGain{
+		Loudness:  loudness.Integrated,
+		TrackGain: round2(ReferenceLoudness - loudness.Integrated),
+		TrackPeak: amplitude(loudness.TruePeak),
+	}
+	if probed, err := probe.File(path); err == nil {
+		gain.DurationMs = probed.DurationMs
+	}
+	if err := d.transcoder.WriteTags(ctx, path, replayGainTags(gain)); err != nil {
+		return nil, err
+	}
+	return gain, d.library.SetReplayGain(path, gain)
+}
+
+// UpdateReplayGain analyzes the downloads queued since the last run and
+// downloaded library entries that have no ReplayGain yet (e.g. because
+// their analysis failed), then recomputes the album gain of every album
+// and retags the tracks whose album values changed. Files a scan found
+// (ID 0) are the operator's own and are never rewritten.
+func (d *Downloader) UpdateReplayGain(ctx context.Context) (analyzed int, retagged int, err error) {
+	if !d.ReplayGainEnabled() {
+		return 0, 0, nil
+	}
+
+	var errs []error
+	failed := map[string]bool{}
+	for _, path := range d.takePendingReplayGain() {
+		if ctx.Err() != nil {
+			// Keep the rest for the next run.
+			d.queueReplayGain(path)
+			continue
+		}
+		if _, err := os.Stat(path); err != nil || !transcode.CanTag(path) {
+			continue
+		}
+		if _, err := d.analyzeReplayGain(ctx, path); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+			failed[path] = true
+			continue
+		}
+		analyzed++
+	}
+	if ctx.Err() != nil {
+		return analyzed, retagged, ctx.Err()
+	}
+	if d.library == nil {
+		return analyzed, retagged, errors.Join(errs...)
+	}
+
+	entries, _ := d.library.List("", 0, 0)
+	albums := map[int64][]*library.Entry{}
+	for i := range entries {
+		entry := &entries[i]
+		if ctx.Err() != nil {
+			return analyzed, retagged, ctx.Err()
+		}
+		if entry.ID == 0 {
+			continue
+		}
+		if entry.ReplayGain == nil {
+			if failed[entry.Path] || !transcode.CanTag(entry.Path) {
+				continue
+			}
+			gain, err := d.analyzeReplayGain(ctx, entry.Path)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", entry.Path, err))
+				continue
+			}
+			entry.ReplayGain = gain
+			analyzed++
+		}
+		if entry.AlbumID != 0 {
+			albums[entry.AlbumID] = append(albums[entry.AlbumID], entry)
+		}
+	}
+
+	for _, tracks := range albums {
+		albumGain, albumPeak := albumReplayGain(tracks)
+		for _, entry := range tracks {
+			current := entry.ReplayGain
+			if current.AlbumTracks == len(tracks) && math.Abs(current.AlbumGain-albumGain) < 0.005 && current.AlbumPeak == albumPeak {
+				continue
+			}
+			if ctx.Err() != nil {
+				return analyzed, retagged, ctx.Err()
+			}
+			updated := *current
+			updated.AlbumGain, updated.AlbumPeak, updated.AlbumTracks = albumGain, albumPeak, len(tracks)
+			if err := d.transcoder.WriteTags(ctx, entry.Path, replayGainTags(&updated)); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", entry.Path, err))
+				continue
+			}
+			if err := d.library.SetReplayGain(entry.Path, &updated); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			retagged++
+		}
+	}
+	return analyzed, retagged, errors.Join(errs...)
+}
+
+// albumReplayGain combines track loudness into album loudness by averaging
+// their energy weighted by duration, and takes the highest track peak.
+func albumReplayGain(tracks []*library.Entry) (gain float64, peak float64) {
+	var energy, weight float64
+	for _, entry := range tracks {
+		w := float64(entry.ReplayGain.DurationMs)
+		if w <= 0 {
+			w = 1
+		}
+		energy += w * math.Pow(10, entry.ReplayGain.Loudness/10)
+		weight += w
+		peak = math.Max(peak, entry.ReplayGain.TrackPeak)
+	}
+	loudness := 10 * math.Log10(energy/weight)
+	return round2(ReferenceLoudness - loudness), peak
+}
+
+func replayGainTags(gain *library.ReplayGain) map[string]string {
+	tags := map[string]string{
+		"REPLAYGAIN_TRACK_GAIN": fmt.Sprintf("%.2f dB", gain.TrackGain),
+		"REPLAYGAIN_TRACK_PEAK": fmt.Sprintf("%.6f", gain.TrackPeak),
+	}
+	if gain.AlbumTracks > 0 {
+		tags["REPLAYGAIN_ALBUM_GAIN"] = fmt.Sprintf("%.2f dB", gain.AlbumGain)
+		tags["REPLAYGAIN_ALBUM_PEAK"] = fmt.Sprintf("%.6f", gain.AlbumPeak)
+	}
+	return tags
+}
+
+// amplitude converts a true peak in dBTP to a linear sample value.
+func amplitude(db float64) float64 {
+	if math.IsInf(db, -1) {
+		return 0
+	}
+	return math.Round(math.Pow(10, db/20)*1e6) / 1e6
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
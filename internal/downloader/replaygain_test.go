@@ -0,0 +1,119 @@
+package downloader
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"wyapi-golang/internal/library"
+	"wyapi-golang/internal/transcode"
+)
+
+func TestAmplitude(t *testing.T) {
+	tests := []struct {
+		db   float64
+		want float64
+	}{
+		{db: 0, want: 1},
+		{db: -6, want: 0.501187},
+		{db: -20, want: 0.1},
+		{db: 1, want: 1.122018},
+		{db: math.Inf(-1), want: 0},
+	}
+	for _, tt := range tests {
+		if got := amplitude(tt.db); got != tt.want {
+			t.Errorf("amplitude(%v) = %v, want %v", tt.db, got, tt.want)
+		}
+	}
+}
+
+func TestAlbumReplayGain(t *testing.T) {
+	track := func(loudness float64, durationMs int64, peak float64) *library.Entry {
+		return &library.Entry{ReplayGain: &library.ReplayGain{Loudness: loudness, DurationMs: durationMs, TrackPeak: peak}}
+	}
+
+	tests := []struct {
+		name     string
+		tracks   []*library.Entry
+		wantGain float64
+		wantPeak float64
+	}{
+		{name: "single track", tracks: []*library.Entry{track(-10, 200000, 0.9)}, wantGain: -8, wantPeak: 0.9},
+		{name: "equal loudness", tracks: []*library.Entry{track(-14, 100000, 0.5), track(-14, 300000, 0.7)}, wantGain: -4, wantPeak: 0.7},
+		// Equal durations: 10*log10((10^-1 + 10^-2)/2) = -12.596 LUFS.
+		{name: "energy average", tracks: []*library.Entry{track(-10, 1000, 1), track(-20, 1000, 0.2)}, wantGain: -5.4, wantPeak: 1},
+		// The long quiet track outweighs the short loud one.
+		{name: "duration weighted", tracks: []*library.Entry{track(-10, 1000, 1), track(-20, 9000, 0.2)}, wantGain: -0.79, wantPeak: 1},
+		{name: "unknown duration", tracks: []*library.Entry{track(-10, 0, 1), track(-20, 0, 0.2)}, wantGain: -5.4, wantPeak: 1},
+	}
+	for _, tt := range tests {
+		gain, peak := albumReplayGain(tt.tracks)
+		if gain != tt.wantGain || peak != tt.wantPeak {
+			t.Errorf("%s: albumReplayGain() = %v, %v; want %v, %v", tt.name, gain, peak, tt.wantGain, tt.wantPeak)
+		}
+	}
+}
+
+func TestReplayGainTags(t *testing.T) {
+	gain := &library.ReplayGain{TrackGain: -7.456, TrackPeak: 0.987654}
+	tags := replayGainTags(gain)
+	want := map[string]string{
+		"REPLAYGAIN_TRACK_GAIN": "-7.46 dB",
+		"REPLAYGAIN_TRACK_PEAK": "0.987654",
+	}
+	if len(tags) != len(want) {
+		t.Errorf("tags without album values = %v", tags)
+	}
+	for key, value := range want {
+		if tags[key] != value {
+			t.Errorf("%s = %q, want %q", key, tags[key], value)
+		}
+	}
+
+	gain.AlbumGain, gain.AlbumPeak, gain.AlbumTracks = 2, 1, 3
+	tags = replayGainTags(gain)
+	if tags["REPLAYGAIN_ALBUM_GAIN"] != "2.00 dB" || tags["REPLAYGAIN_ALBUM_PEAK"] != "1.000000" {
+		t.Errorf("album tags = %v", tags)
+	}
+}
+
+func TestUpdateReplayGainSkipsScannedFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffmpeg is a shell script")
+	}
+	binary, err := filepath.Abs(filepath.Join("..", "transcode", "testdata", "fake-ffmpeg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Any ffmpeg run fails, so touching the file would surface as an error.
+	t.Setenv("FAKE_FFMPEG", "fail")
+
+	dir := t.TempDir()
+	own := filepath.Join(dir, "Artist - Own Song.flac")
+	if err := os.WriteFile(own, []byte("operator audio"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	lib, err := library.Open(dir, filepath.Join(t.TempDir(), "library.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added, _, err := lib.Scan(); err != nil || added != 1 {
+		t.Fatalf("Scan() = %d, %v; want 1 file added", added, err)
+	}
+
+	d := NewDownloader(nil, nil, dir)
+	d.SetLibrary(lib)
+	d.SetTranscoder(transcode.New(binary, 1))
+	d.SetReplayGain(true)
+
+	analyzed, retagged, err := d.UpdateReplayGain(context.Background())
+	if analyzed != 0 || retagged != 0 || err != nil {
+		t.Errorf("UpdateReplayGain() = %d, %d, %v; want the scanned file left alone", analyzed, retagged, err)
+	}
+	if data, _ := os.ReadFile(own); string(data) != "operator audio" {
+		t.Errorf("scanned file changed to %q", data)
+	}
+}
@@ -0,0 +1,92 @@
+package downloader
+
+import (
+	"errors"
+
+	"wyapi-golang/internal/diskusage"
+	"wyapi-golang/internal/library"
+)
+
+var ErrInsufficientSpace = errors.New("insufficient disk space for download")
+
+// Retention limits what the download directory may hold. The library policy
+// is applied before every download; MinFreeBytes refuses downloads that
+// would leave less free space than that on the filesystem.
+type Retention struct {
+	Policy       library.Policy
+	MinFreeBytes int64
+}
+
+// DiskStatus summarises the download directory for health reporting.
+type DiskStatus struct {
+	Dir          string `json:"dir"`
+	TotalBytes   uint64 `json:"total_bytes"`
+	FreeBytes    uint64 `json:"free_bytes"`
+	LibraryFiles int    `json:"library_files"`
+	LibraryBytes int64  `json:"library_bytes"`
+	MaxBytes     int64  `json:"max_bytes,omitempty"`
+	MinFreeBytes int64  `json:"min_free_bytes,omitempty"`
+	LowSpace     bool   `json:"low_space"`
+	Error        string `json:"error,omitempty"`
+}
+
+func (d *Downloader) SetRetention(retention Retention) {
+	d.retention = retention
+}
+
+// EnforceRetention evicts library entries that exceed the retention policy.
+func (d *Downloader) EnforceRetention() ([]library.Entry, error) {
+	return d.library.Evict(d.retention.Policy, 0)
+}
+
+func (d *Downloader) DiskStatus() DiskStatus {
+	status := DiskStatus{
+		Dir:          d.downloadDir,
+		MinFreeBytes: d.retention.MinFreeBytes,
+	}
+	// Size limits are enforced through the library index; without it only
+	// the free space check applies.
+	if d.library != nil {
+		status.LibraryFiles, status.LibraryBytes = d.library.Usage()
+		status.MaxBytes = d.retention.Policy.MaxBytes
+	}
+
+	usage, err := diskusage.Stat(d.downloadDir)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.TotalBytes = usage.Total
+	status.FreeBytes = usage.Free
+	status.LowSpace = d.retention.MinFreeBytes > 0 && usage.Free < uint64(d.retention.MinFreeBytes)
+	return status
+}
+
+// ensureSpace makes room for a download of size bytes (0 when unknown):
+// the retention policy is enforced with size reserved, then the free space
+// check is applied. A download that could not fit in the size limit even
+// with every unpinned file gone is refused before anything is evicted.
+func (d *Downloader) ensureSpace(size int64) error {
+	if size < 0 {
+		size = 0
+	}
+	if _, err := d.library.Evict(d.retention.Policy, size); err != nil {
+		if errors.Is(err, library.ErrNoRoom) {
+			return ErrInsufficientSpace
+		}
+		return err
+	}
+	if d.retention.MinFreeBytes <= 0 {
+		return nil
+	}
+
+	// Filesystems that cannot report free space are not a reason to refuse.
+	usage, err := diskusage.Stat(d.downloadDir)
+	if err != nil {
+		return nil
+	}
+	if usage.Free < uint64(size)+uint64(d.retention.MinFreeBytes) {
+		return ErrInsufficientSpace
+	}
+	return nil
+}
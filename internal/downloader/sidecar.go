@@ -0,0 +1,303 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"wyapi-golang/internal/atomicfile"
+	"wyapi-golang/internal/lyrics"
+	"wyapi-golang/internal/netease"
+)
+
+const (
+	SidecarLyricsOriginal   = "original"
+	SidecarLyricsTranslated = "translated"
+	SidecarLyricsMerged     = "merged"
+
+	SidecarCoverFile   = "cover"  // cover.jpg in the track's directory
+	SidecarCoverFolder = "folder" // folder.jpg in the track's directory
+	SidecarCoverTrack  = "track"  // <track>.jpg next to the track
+
+	SidecarMetadataNFO  = "nfo"
+	SidecarMetadataJSON = "json"
+)
+
+// Sidecar selects the files written next to each downloaded track. Empty
+// fields disable that file; CoverSize 0 keeps the original resolution.
+type Sidecar struct {
+	Lyrics    string
+	Cover     string
+	CoverSize int
+	Metadata  string
+}
+
+func (s Sidecar) Validate() error {
+	switch s.Lyrics {
+	case "", SidecarLyricsOriginal, SidecarLyricsTranslated, SidecarLyricsMerged:
+	default:
+		return fmt.Errorf("invalid sidecar lyrics %q (want original, translated or merged)", s.Lyrics)
+	}
+	switch s.Cover {
+	case "", SidecarCoverFile, SidecarCoverFolder, SidecarCoverTrack:
+	default:
+		return fmt.Errorf("invalid sidecar cover %q (want cover, folder or track)", s.Cover)
+	}
+	switch s.Metadata {
+	case "", SidecarMetadataNFO, SidecarMetadataJSON:
+	default:
+		return fmt.Errorf("invalid sidecar metadata %q (want nfo or json)", s.Metadata)
+	}
+	if s.CoverSize < 0 {
+		return errors.New("sidecar cover size must not be negative")
+	}
+	return nil
+}
+
+func (d *Downloader) SetSidecar(sidecar Sidecar) {
+	d.sidecar = sidecar
+}
+
+// sidecarTimeout bounds the fetches behind one track's sidecars.
+const sidecarTimeout = time.Minute
+
+// queueSidecars writes the sidecars of the track at audioPath in the
+// background, so their lyric, cover and detail fetches never hold up the
+// download that triggered them. A track whose sidecars are already being
+// written is skipped. Failures are only logged.
+func (d *Downloader) queueSidecars(ctx context.Context, info *MusicInfo, audioPath string) {
+	if d.sidecar == (Sidecar{}) {
+		return
+	}
+	d.sidecarMu.Lock()
+	if d.sidecarBusy[audioPath] {
+		d.sidecarMu.Unlock()
+		return
+	}
+	if d.sidecarBusy == nil {
+		d.sidecarBusy = map[string]bool{}
+	}
+	d.sidecarBusy[audioPath] = true
+	d.sidecarMu.Unlock()
+
+	// The caller keeps using info; completeInfo fills in a copy.
+	track := *info
+	go func() {
+		defer func() {
+			d.sidecarMu.Lock()
+			delete(d.sidecarBusy, audioPath)
+			d.sidecarMu.Unlock()
+		}()
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), sidecarTimeout)
+		defer cancel()
+		if err := d.writeSidecars(ctx, &track, audioPath); err != nil {
+			slog.Warn("failed to write sidecar files", slog.String("path", audioPath), slog.String("error", err.Error()))
+		}
+	}()
+}
+
+// writeSidecars writes the configured companion files for the track at
+// audioPath. Existing files are left alone, so a shared cover.jpg keeps the
+// first album written into a directory.
+func (d *Downloader) writeSidecars(ctx context.Context, info *MusicInfo, audioPath string) error {
+	stem := strings.TrimSuffix(audioPath, filepath.Ext(audioPath))
+	var errs []error
+
+	if d.sidecar.Lyrics != "" && !fileExists(stem+".lrc") {
+		errs = append(errs, d.writeLyricSidecar(ctx, info, stem+".lrc"))
+	}
+
+	if d.sidecar.Cover != "" {
+		coverPath := stem + ".jpg"
+		switch d.sidecar.Cover {
+		case SidecarCoverFile:
+			coverPath = filepath.Join(filepath.Dir(audioPath), "cover.jpg")
+		case SidecarCoverFolder:
+			coverPath = filepath.Join(filepath.Dir(audioPath), "folder.jpg")
+		}
+		if !fileExists(coverPath) {
+			errs = append(errs, d.writeCoverSidecar(ctx, info, coverPath))
+		}
+	}
+
+	if d.sidecar.Metadata != "" {
+		metadataPath := stem + "." + d.sidecar.Metadata
+		if !fileExists(metadataPath) {
+			errs = append(errs, d.writeMetadataSidecar(ctx, info, metadataPath))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (d *Downloader) writeLyricSidecar(ctx context.Context, info *MusicInfo, path string) error {
+	// Library copies carry no lyrics; fetch them for the sidecar.
+	original, translated := info.Lyric, info.TLyric
+	if original == "" && d.client != nil && info.ID != 0 {
+		resp, err := d.client.GetLyrics(ctx, info.ID, d.cookieManager.Resolve(ctx))
+		if err != nil {
+			return fmt.Errorf("lyrics: %w", err)
+		}
+		original, translated = resp.Lrc.Lyric, resp.Tlyric.Lyric
+	}
+
+	text := original
+	switch d.sidecar.Lyrics {
+	case SidecarLyricsTranslated:
+		if strings.TrimSpace(translated) != "" {
+			text = translated
+		}
+	case SidecarLyricsMerged:
+		merged := lyrics.Merge(lyrics.ParseLRC(original), lyrics.ParseLRC(translated), nil, lyrics.DefaultTolerance)
+		text = lyrics.Render(merged.Lyrics(), lyrics.FormatLRC)
+	}
+	// Instrumentals have no lyrics; that is not an error. An empty .lrc
+	// records the answer so later downloads of the song do not ask again.
+	if strings.TrimSpace(text) == "" {
+		text = ""
+	}
+	return atomicfile.Write(path, strings.NewReader(text), 0644)
+}
+
+func (d *Downloader) writeCoverSidecar(ctx context.Context, info *MusicInfo, path string) error {
+	if err := d.completeInfo(ctx, info); err != nil {
+		return fmt.Errorf("cover: %w", err)
+	}
+	if info.PicURL == "" || d.client == nil {
+		return nil
+	}
+
+	stream, _, err := d.client.FetchImage(ctx, netease.SizedPicURL(info.PicURL, d.sidecar.CoverSize, ""))
+	if err != nil {
+		return fmt.Errorf("cover: %w", err)
+	}
+	defer stream.Close()
+	return atomicfile.Write(path, stream, 0644)
+}
+
+// completeInfo fills the descriptive fields a library copy lacks (it only
+// carries what the index stores) from the song detail API.
+func (d *Downloader) completeInfo(ctx context.Context, info *MusicInfo) error {
+	if info.PicURL != "" || info.ID == 0 || d.client == nil {
+		return nil
+	}
+	meta, err := d.songMetadata(ctx, info.ID)
+	if err != nil {
+		return err
+	}
+	info.PicURL = meta.PicURL
+	info.AlbumID = meta.AlbumID
+	info.Duration = meta.Duration
+	info.Year, info.Track, info.Disc = meta.Year, meta.Track, meta.Disc
+	if info.Name == "" {
+		info.Name, info.Artists, info.Album = meta.Name, meta.Artists, meta.Album
+	}
+	return nil
+}
+
+// songNFO is the Kodi-style <song> document Jellyfin and similar servers
+// read from a track's .nfo.
+type songNFO struct {
+	XMLName     xml.Name `xml:"song"`
+	Title       string   `xml:"title"`
+	Artists     []string `xml:"artist"`
+	Album       string   `xml:"album,omitempty"`
+	AlbumArtist string   `xml:"albumartist,omitempty"`
+	Year        int      `xml:"year,omitempty"`
+	Track       int      `xml:"track,omitempty"`
+	Disc        int      `xml:"disc,omitempty"`
+	Duration    int64    `xml:"duration,omitempty"`
+	NeteaseID   int64    `xml:"neteaseid,omitempty"`
+}
+
+// trackMetadata is the JSON sidecar.
+type trackMetadata struct {
+	ID          int64    `json:"id"`
+	Title       string   `json:"title"`
+	Artists     []string `json:"artists"`
+	Album       string   `json:"album"`
+	AlbumID     int64    `json:"album_id,omitempty"`
+	AlbumArtist string   `json:"album_artist,omitempty"`
+	Year        int      `json:"year,omitempty"`
+	Track       int      `json:"track,omitempty"`
+	Disc        int      `json:"disc,omitempty"`
+	DurationMs  int64    `json:"duration_ms,omitempty"`
+	Quality     string   `json:"quality,omitempty"`
+	FileType    string   `json:"file_type,omitempty"`
+	CoverURL    string   `json:"cover_url,omitempty"`
+}
+
+func (d *Downloader) writeMetadataSidecar(ctx context.Context, info *MusicInfo, path string) error {
+	if err := d.completeInfo(ctx, info); err != nil {
+		return fmt.Errorf("metadata: %w", err)
+	}
+	d.fillAlbumArtist(ctx, info)
+	artists := strings.Split(info.Artists, "/")
+
+	var body []byte
+	var err error
+	if d.sidecar.Metadata == SidecarMetadataNFO {
+		body, err = xml.MarshalIndent(songNFO{
+			Title:       info.Name,
+			Artists:     artists,
+			Album:       info.Album,
+			AlbumArtist: info.AlbumArtist,
+			Year:        info.Year,
+			Track:       info.Track,
+			Disc:        info.Disc,
+			Duration:    info.Duration / 1000,
+			NeteaseID:   info.ID,
+		}, "", "  ")
+		body = append([]byte(xml.Header), body...)
+	} else {
+		body, err = json.MarshalIndent(trackMetadata{
+			ID:          info.ID,
+			Title:       info.Name,
+			Artists:     artists,
+			Album:       info.Album,
+			AlbumID:     info.AlbumID,
+			AlbumArtist: info.AlbumArtist,
+			Year:        info.Year,
+			Track:       info.Track,
+			Disc:        info.Disc,
+			DurationMs:  info.Duration,
+			Quality:     info.Quality,
+			FileType:    info.FileType,
+			CoverURL:    info.PicURL,
+		}, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+	return atomicfile.Write(path, strings.NewReader(string(body)+"\n"), 0644)
+}
+
+// sidecarIdentity reads the song ID, and the quality when recorded, from
+// the metadata sidecar of the track at audioPath. It returns 0 without one.
+func sidecarIdentity(audioPath string) (int64, string) {
+	stem := strings.TrimSuffix(audioPath, filepath.Ext(audioPath))
+	if data, err := os.ReadFile(stem + "." + SidecarMetadataJSON); err == nil {
+		var meta trackMetadata
+		if json.Unmarshal(data, &meta) == nil && meta.ID != 0 {
+			return meta.ID, meta.Quality
+		}
+	}
+	if data, err := os.ReadFile(stem + "." + SidecarMetadataNFO); err == nil {
+		var nfo songNFO
+		if xml.Unmarshal(data, &nfo) == nil && nfo.NeteaseID != 0 {
+			return nfo.NeteaseID, ""
+		}
+	}
+	return 0, ""
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,257 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const DefaultFilenameTemplate = "{artists} - {title}"
+
+// maxComponentBytes keeps each path component well under the usual 255-byte
+// filesystem limit, leaving room for the extension and a collision suffix.
+const maxComponentBytes = 200
+
+var templateFields = map[string]bool{
+	"title":        false,
+	"artist":       false,
+	"artists":      false,
+	"album":        false,
+	"album_artist": false,
+	"year":         true,
+	"track":        true,
+	"disc":         true,
+	"id":           true,
+	"quality":      false,
+}
+
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+type templateToken struct {
+	literal string
+	field   string
+	width   int
+}
+
+// Template renders download paths from song metadata. Placeholders are
+// written as {field} or {field:0N} (numeric fields only, zero-padded to N
+// digits); "/" in the template separates directories.
+type Template struct {
+	raw        string
+	components [][]templateToken
+}
+
+func ParseTemplate(raw string) (*Template, error) {
+	raw = strings.TrimSpace(strings.ReplaceAll(raw, "\\", "/"))
+	if raw == "" {
+		raw = DefaultFilenameTemplate
+	}
+
+	tmpl := &Template{raw: raw}
+	for _, part := range strings.Split(raw, "/") {
+		if part == "" || part == "." || part == ".." {
+			return nil, fmt.Errorf("invalid path component %q in template", part)
+		}
+		tokens, err := parseComponent(part)
+		if err != nil {
+			return nil, err
+		}
+		tmpl.components = append(tmpl.components, tokens)
+	}
+	return tmpl, nil
+}
+
+func (t *Template) String() string {
+	return t.raw
+}
+
+func (t *Template) Uses(field string) bool {
+	for _, tokens := range t.components {
+		for _, token := range tokens {
+			if token.field == field {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func parseComponent(part string) ([]templateToken, error) {
+	var tokens []templateToken
+	for part != "" {
+		start := strings.IndexByte(part, '{')
+		if start < 0 {
+			if strings.IndexByte(part, '}') >= 0 {
+				return nil, errors.New("unmatched } in template")
+			}
+			tokens = append(tokens, templateToken{literal: part})
+			break
+		}
+		if start > 0 {
+			if strings.IndexByte(part[:start], '}') >= 0 {
+				return nil, errors.New("unmatched } in template")
+			}
+			tokens = append(tokens, templateToken{literal: part[:start]})
+		}
+		end := strings.IndexByte(part[start:], '}')
+		if end < 0 {
+			return nil, errors.New("unclosed { in template")
+		}
+		token, err := parsePlaceholder(part[start+1 : start+end])
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+		part = part[start+end+1:]
+	}
+	return tokens, nil
+}
+
+func parsePlaceholder(spec string) (templateToken, error) {
+	name, format, hasFormat := strings.Cut(spec, ":")
+	name = strings.TrimSpace(name)
+	numeric, ok := templateFields[name]
+	if !ok {
+		return templateToken{}, fmt.Errorf("unknown template field %q", name)
+	}
+
+	token := templateToken{field: name}
+	if hasFormat {
+		if !numeric {
+			return templateToken{}, fmt.Errorf("field %q does not accept a format", name)
+		}
+		width, err := strconv.Atoi(strings.TrimPrefix(format, "0"))
+		if err != nil || !strings.HasPrefix(format, "0") || width <= 0 || width > 9 {
+			return templateToken{}, fmt.Errorf("invalid format %q for field %q", format, name)
+		}
+		token.width = width
+	}
+	return token, nil
+}
+
+// Render returns the relative path (without extension) for info, using
+// the naming rules of the current OS.
+func (t *Template) Render(info *MusicInfo) string {
+	return t.render(info, runtime.GOOS)
+}
+
+func (t *Template) render(info *MusicInfo, goos string) string {
+	parts := make([]string, 0, len(t.components))
+	for _, tokens := range t.components {
+		var b strings.Builder
+		for _, token := range tokens {
+			if token.field == "" {
+				b.WriteString(token.literal)
+				continue
+			}
+			b.WriteString(fieldValue(info, token))
+		}
+		parts = append(parts, SanitizeComponent(b.String(), goos))
+	}
+	return strings.Join(parts, "/")
+}
+
+func fieldValue(info *MusicInfo, token templateToken) string {
+	var number int64
+	switch token.field {
+	case "title":
+		return info.Name
+	case "artist":
+		artist, _, _ := strings.Cut(info.Artists, "/")
+		return artist
+	case "artists":
+		return info.Artists
+	case "album":
+		return info.Album
+	case "album_artist":
+		if info.AlbumArtist != "" {
+			return info.AlbumArtist
+		}
+		artist, _, _ := strings.Cut(info.Artists, "/")
+		return artist
+	case "quality":
+		return info.Quality
+	case "year":
+		number = int64(info.Year)
+	case "track":
+		number = int64(info.Track)
+	case "disc":
+		number = int64(info.Disc)
+	case "id":
+		number = info.ID
+	}
+
+	if number == 0 && token.field != "track" && token.field != "disc" {
+		return ""
+	}
+	if token.width > 0 {
+		return fmt.Sprintf("%0*d", token.width, number)
+	}
+	return strconv.FormatInt(number, 10)
+}
+
+// CleanSubdir normalizes dir, a folder given by a client, to a slash
+// separated path and rejects anything that would leave the download
+// directory.
+func CleanSubdir(dir string) (string, error) {
+	cleaned := filepath.ToSlash(filepath.Clean(filepath.FromSlash(strings.TrimSpace(dir))))
+	if cleaned == "." || filepath.IsAbs(cleaned) || strings.HasPrefix(cleaned, "/") || cleaned == ".." || strings.HasPrefix(cleaned, "../") || filepath.VolumeName(cleaned) != "" {
+		return "", fmt.Errorf("dir %q must be a relative path inside the download directory", dir)
+	}
+	return cleaned, nil
+}
+
+// SanitizeComponent makes s safe as a single file or directory name on
+// goos: separators and the characters Windows rejects are replaced with
+// "_" on every OS, so a download directory can be copied or shared to a
+// Windows machine; on Windows reserved device names are prefixed and
+// trailing dots dropped. The result is truncated to maxComponentBytes
+// without splitting a UTF-8 sequence.
+func SanitizeComponent(s string, goos string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r < 0x20 || r == 0x7f:
+			continue
+		case r == '/' || r == '\\' || strings.ContainsRune(`<>:"|?*`, r):
+			b.WriteRune('_')
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	out := truncateBytes(strings.TrimSpace(b.String()), maxComponentBytes)
+	if goos == "windows" {
+		out = strings.TrimRight(out, ". ")
+		stem, _, _ := strings.Cut(out, ".")
+		if windowsReservedNames[strings.ToUpper(stem)] {
+			out = "_" + out
+		}
+	}
+	out = strings.TrimLeft(out, ".")
+	if strings.TrimSpace(out) == "" {
+		return "unknown"
+	}
+	return out
+}
+
+func truncateBytes(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return strings.TrimSpace(s[:cut])
+}
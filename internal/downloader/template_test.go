@@ -0,0 +1,135 @@
+package downloader
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestParseTemplateRejectsTraversal(t *testing.T) {
+	for _, raw := range []string{
+		"../{title}",
+		"{artist}/../../{title}",
+		`..\{title}`,
+		`{album}\..\{title}`,
+		"/etc/{title}",
+		"{artist}//{title}",
+		"./{title}",
+		"{title}/",
+	} {
+		if _, err := ParseTemplate(raw); err == nil {
+			t.Errorf("ParseTemplate(%q) succeeded, want an error", raw)
+		}
+	}
+	for _, raw := range []string{"", "{artists} - {title}", `{album_artist}\{album}/{track:02} {title}`, "..{title}"} {
+		if _, err := ParseTemplate(raw); err != nil {
+			t.Errorf("ParseTemplate(%q) = %v", raw, err)
+		}
+	}
+}
+
+func TestRenderStaysInsideDownloadDir(t *testing.T) {
+	tmpl, err := ParseTemplate("{album_artist}/{album}/{artists} - {title}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostile := []MusicInfo{
+		{Name: "../../etc/passwd", Artists: "..", Album: ".."},
+		{Name: `..\..\windows\system32`, Artists: `C:\`, Album: "/"},
+		{Name: ".", Artists: "...", Album: " .. "},
+		{Name: "\x00\x01", AlbumArtist: "../..", Album: "a/../../b"},
+	}
+	root := filepath.FromSlash("/srv/music")
+	for _, goos := range []string{"linux", "windows"} {
+		for _, info := range hostile {
+			rendered := tmpl.render(&info, goos)
+			for _, part := range strings.Split(rendered, "/") {
+				if part == "" || part == "." || part == ".." || strings.ContainsAny(part, `\:`) {
+					t.Errorf("%s: %+v renders unsafe component %q in %q", goos, info, part, rendered)
+				}
+			}
+			path := filepath.Join(root, filepath.FromSlash(rendered))
+			if rel, err := filepath.Rel(root, path); err != nil || strings.HasPrefix(rel, "..") {
+				t.Errorf("%s: %+v renders %q outside the download directory", goos, info, rendered)
+			}
+		}
+	}
+}
+
+func TestSanitizeComponent(t *testing.T) {
+	tests := []struct {
+		in   string
+		goos string
+		want string
+	}{
+		{"AC/DC", "linux", "AC_DC"},
+		{`What? <Live> "Mix": a|b*`, "linux", "What_ _Live_ _Mix__ a_b_"},
+		{"..", "linux", "unknown"},
+		{"...hidden", "linux", "hidden"},
+		{"   ", "linux", "unknown"},
+		{"tab\there", "linux", "tabhere"},
+		{"CON", "linux", "CON"},
+		{"CON", "windows", "_CON"},
+		{"con.mp3", "windows", "_con.mp3"},
+		{"Lpt1", "windows", "_Lpt1"},
+		{"COM10", "windows", "COM10"},
+		{"NUL. ", "windows", "_NUL"},
+		{"Console", "windows", "Console"},
+		{"Song...", "windows", "Song"},
+		{"Song...", "linux", "Song..."},
+		{"..", "windows", "unknown"},
+	}
+	for _, tt := range tests {
+		if got := SanitizeComponent(tt.in, tt.goos); got != tt.want {
+			t.Errorf("SanitizeComponent(%q, %s) = %q, want %q", tt.in, tt.goos, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeComponentTruncatesOnRuneBoundary(t *testing.T) {
+	// Shift multi-byte runes across the 200-byte limit one byte at a time.
+	for _, r := range []string{"晴", "🎵", "é"} {
+		for pad := 0; pad < 4; pad++ {
+			in := strings.Repeat("a", pad) + strings.Repeat(r, 150)
+			for _, goos := range []string{"linux", "windows"} {
+				got := SanitizeComponent(in, goos)
+				if len(got) > maxComponentBytes {
+					t.Errorf("%q x150 after %d bytes: %d bytes, want at most %d", r, pad, len(got), maxComponentBytes)
+				}
+				if !utf8.ValidString(got) {
+					t.Errorf("%q x150 after %d bytes: split a rune", r, pad)
+				}
+				if len(got) <= maxComponentBytes-len(r) {
+					t.Errorf("%q x150 after %d bytes: cut to %d bytes, more than needed", r, pad, len(got))
+				}
+			}
+		}
+	}
+}
+
+func TestCleanSubdir(t *testing.T) {
+	tests := []struct {
+		dir  string
+		want string
+	}{
+		{"jazz", "jazz"},
+		{" playlists/123/ ", "playlists/123"},
+		{"a/./b//c", "a/b/c"},
+		{"a/../b", "b"},
+		{"a/b/../../c", "c"},
+		{"..hidden", "..hidden"},
+	}
+	for _, tt := range tests {
+		got, err := CleanSubdir(tt.dir)
+		if err != nil || got != tt.want {
+			t.Errorf("CleanSubdir(%q) = %q, %v; want %q", tt.dir, got, err, tt.want)
+		}
+	}
+
+	for _, dir := range []string{"", ".", "..", "../music", "a/../../music", "a/b/../../..", "/etc", "//server/share"} {
+		if got, err := CleanSubdir(dir); err == nil {
+			t.Errorf("CleanSubdir(%q) = %q, want an error", dir, got)
+		}
+	}
+}
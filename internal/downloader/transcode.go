@@ -0,0 +1,95 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+
+	"wyapi-golang/internal/transcode"
+)
+
+// SetTranscoder enables Transcode; nil disables it.
+func (d *Downloader) SetTranscoder(t *transcode.Transcoder) {
+	d.transcoder = t
+}
+
+// CanTranscode reports whether a working ffmpeg is configured.
+func (d *Downloader) CanTranscode() bool {
+	return d.transcoder.Available()
+}
+
+// NeedsTranscode reports whether info has to be re-encoded to reach target.
+// A file already in the target codec at no more than the target bitrate is
+// served as is, since re-encoding it would only lose quality.
+func NeedsTranscode(info *MusicInfo, target transcode.Target) bool {
+	if info.FileType != target.Extension() {
+		return true
+	}
+	if target.Bitrate == 0 || info.Duration <= 0 || info.FileSize <= 0 {
+		return target.Bitrate != 0
+	}
+	// bytes*8 per millisecond is kbit/s.
+	return info.FileSize*8/info.Duration > int64(target.Bitrate)
+}
+
+// Transcode streams info re-encoded to target. With persist the original is
+// first saved (or reused) in the download directory like DownloadToFile;
+// otherwise it is read straight from the library or the CDN. Only the
+// encoded output is streamed, it is never written to disk. It returns
+// transcode.ErrUnavailable when ffmpeg is not configured or missing.
+func (d *Downloader) Transcode(ctx context.Context, info *MusicInfo, target transcode.Target, persist bool, tmpl *Template) (io.ReadCloser, error) {
+	if info == nil {
+		return nil, errors.New("music info is nil")
+	}
+	if !d.CanTranscode() {
+		return nil, transcode.ErrUnavailable
+	}
+
+	var source io.ReadCloser
+	var err error
+	switch {
+	case persist:
+		var path string
+		if path, _, err = d.DownloadToFile(ctx, info, tmpl); err == nil {
+			source, err = os.Open(path)
+		}
+	case info.LocalPath != "":
+		source, err = os.Open(info.LocalPath)
+	case info.URL != "":
+		source, _, err = d.client.FetchSongStream(ctx, info.URL)
+	default:
+		err = errors.New("download url empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	d.fillAlbumArtist(ctx, info)
+	output, err := d.transcoder.Stream(ctx, source, target, transcode.Tags{
+		Title:       info.Name,
+		Artist:      info.Artists,
+		Album:       info.Album,
+		AlbumArtist: info.AlbumArtist,
+		Year:        info.Year,
+		Track:       info.Track,
+		Disc:        info.Disc,
+	})
+	if err != nil {
+		source.Close()
+		return nil, err
+	}
+	return &transcodeStream{ReadCloser: output, source: source}, nil
+}
+
+type transcodeStream struct {
+	io.ReadCloser
+	source io.Closer
+}
+
+func (s *transcodeStream) Close() error {
+	// Closing the source first unblocks ffmpeg if the client went away
+	// before it finished reading its input.
+	s.source.Close()
+	return s.ReadCloser.Close()
+}
@@ -0,0 +1,64 @@
+package downloader
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"wyapi-golang/internal/library"
+	"wyapi-golang/internal/transcode"
+)
+
+func TestNeedsTranscode(t *testing.T) {
+	mp3 := transcode.Target{Codec: "mp3", Bitrate: 192}
+	flac := transcode.Target{Codec: "flac"}
+
+	tests := []struct {
+		name   string
+		info   MusicInfo
+		target transcode.Target
+		want   bool
+	}{
+		{name: "other codec", info: MusicInfo{FileType: "flac", FileSize: 30 << 20, Duration: 200000}, target: mp3, want: true},
+		// 320 kbps for 200 s is 8 000 000 bytes.
+		{name: "higher bitrate", info: MusicInfo{FileType: "mp3", FileSize: 8000000, Duration: 200000}, target: mp3, want: true},
+		{name: "lower bitrate", info: MusicInfo{FileType: "mp3", FileSize: 3200000, Duration: 200000}, target: mp3, want: false},
+		{name: "target bitrate", info: MusicInfo{FileType: "mp3", FileSize: 4800000, Duration: 200000}, target: mp3, want: false},
+		{name: "unknown duration", info: MusicInfo{FileType: "mp3", FileSize: 3200000}, target: mp3, want: true},
+		{name: "lossless to lossless", info: MusicInfo{FileType: "flac", FileSize: 30 << 20, Duration: 200000}, target: flac, want: false},
+		{name: "unknown size lossless", info: MusicInfo{FileType: "flac"}, target: flac, want: false},
+		{name: "opus container", info: MusicInfo{FileType: "ogg", FileSize: 1, Duration: 1000}, target: transcode.Target{Codec: "opus", Bitrate: 160}, want: true},
+	}
+	for _, tt := range tests {
+		if got := NeedsTranscode(&tt.info, tt.target); got != tt.want {
+			t.Errorf("%s: NeedsTranscode() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLibraryCopyFitsBitrateTarget(t *testing.T) {
+	// 200 MPEG-1 Layer III frames at 128 kbps, 44.1 kHz: about 5.2 s.
+	frame := make([]byte, 417)
+	copy(frame, []byte{0xff, 0xfb, 0x90, 0x00})
+	path := filepath.Join(t.TempDir(), "song.mp3")
+	data := bytes.Repeat(frame, 200)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	target := transcode.Target{Codec: "mp3", Bitrate: 192}
+
+	probed := musicInfoFromEntry(&library.Entry{ID: 1, Path: path, FileType: "mp3", Size: int64(len(data))})
+	if probed.Duration <= 0 {
+		t.Fatalf("Duration = %d, want it probed from the file", probed.Duration)
+	}
+	if NeedsTranscode(probed, target) {
+		t.Error("a 128 kbps copy is re-encoded for a 192 kbps target")
+	}
+
+	analyzed := musicInfoFromEntry(&library.Entry{ID: 1, Path: filepath.Join(t.TempDir(), "missing.mp3"), FileType: "mp3", Size: 3200000,
+		ReplayGain: &library.ReplayGain{DurationMs: 200000}})
+	if analyzed.Duration != 200000 || NeedsTranscode(analyzed, target) {
+		t.Errorf("Duration = %d, want the analyzed 200000 and no transcode", analyzed.Duration)
+	}
+}
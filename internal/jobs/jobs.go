@@ -0,0 +1,264 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrQueueFull   = errors.New("job queue full")
+	ErrQueueClosed = errors.New("job queue closed")
+	ErrJobNotFound = errors.New("job not found")
+	ErrJobFinished = errors.New("job already finished")
+)
+
+// Job states.
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusDone      = "done"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+// Finished jobs kept for polling, and errors kept per job.
+const (
+	historyLimit = 100
+	errorLimit   = 50
+)
+
+// Func performs a job, reporting each item through progress.
+type Func func(ctx context.Context, progress *Progress) error
+
+// Job is a snapshot of a queued or finished job. Owner is the name of the
+// API token that submitted it, empty without token enforcement.
+type Job struct {
+	ID         string     `json:"id"`
+	Kind       string     `json:"kind"`
+	Owner      string     `json:"owner,omitempty"`
+	Status     string     `json:"status"`
+	Total      int        `json:"total"`
+	Completed  int        `json:"completed"`
+	Failed     int        `json:"failed"`
+	Bytes      int64      `json:"bytes"`
+	Errors     []string   `json:"errors,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+func (j *Job) finished() bool {
+	return j.Status == StatusDone || j.Status == StatusFailed || j.Status == StatusCancelled
+}
+
+type entry struct {
+	job    Job
+	fn     Func
+	cancel context.CancelFunc
+}
+
+// Queue runs submitted jobs on a fixed number of workers. Jobs wait in a
+// bounded queue; when it is full Submit fails rather than piling work up.
+type Queue struct {
+	workers int
+	pending chan *entry
+
+	mu     sync.Mutex
+	jobs   map[string]*entry
+	order  []string
+	closed bool
+}
+
+func NewQueue(workers int, capacity int) *Queue {
+	return &Queue{
+		workers: max(workers, 1),
+		pending: make(chan *entry, max(capacity, 1)),
+		jobs:    map[string]*entry{},
+	}
+}
+
+// Run starts the workers and blocks until ctx is done. Running jobs are
+// cancelled through ctx, and jobs still waiting are marked cancelled.
+func (q *Queue) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < q.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case e := <-q.pending:
+					q.run(ctx, e)
+				}
+			}
+		}()
+	}
+	<-ctx.Done()
+
+	q.mu.Lock()
+	q.closed = true
+	now := time.Now()
+	for _, e := range q.jobs {
+		if e.job.Status == StatusQueued {
+			e.job.Status = StatusCancelled
+			e.job.FinishedAt = &now
+		}
+	}
+	q.mu.Unlock()
+	wg.Wait()
+}
+
+// Submit queues fn as a job of total items and returns its snapshot.
+func (q *Queue) Submit(kind string, owner string, total int, fn Func) (Job, error) {
+	id, err := newID()
+	if err != nil {
+		return Job{}, err
+	}
+	e := &entry{
+		job: Job{ID: id, Kind: kind, Owner: owner, Status: StatusQueued, Total: total, CreatedAt: time.Now()},
+		fn:  fn,
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return Job{}, ErrQueueClosed
+	}
+	select {
+	case q.pending <- e:
+	default:
+		return Job{}, ErrQueueFull
+	}
+	q.jobs[id] = e
+	q.order = append(q.order, id)
+	q.pruneLocked()
+	return e.job.snapshot(), nil
+}
+
+// Get returns the job with id.
+func (q *Queue) Get(id string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	e, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return e.job.snapshot(), true
+}
+
+// Cancel stops a queued or running job. A running job ends once its
+// current item returns.
+func (q *Queue) Cancel(id string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	e, ok := q.jobs[id]
+	if !ok {
+		return Job{}, ErrJobNotFound
+	}
+	if e.job.finished() {
+		return e.job.snapshot(), ErrJobFinished
+	}
+	if e.cancel != nil {
+		e.cancel()
+	} else {
+		now := time.Now()
+		e.job.Status = StatusCancelled
+		e.job.FinishedAt = &now
+	}
+	return e.job.snapshot(), nil
+}
+
+func (q *Queue) run(ctx context.Context, e *entry) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	q.mu.Lock()
+	if e.job.Status != StatusQueued {
+		// Cancelled while waiting.
+		q.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	e.job.Status = StatusRunning
+	e.job.StartedAt = &now
+	e.cancel = cancel
+	q.mu.Unlock()
+
+	err := e.fn(ctx, &Progress{queue: q, entry: e})
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	finished := time.Now()
+	e.job.FinishedAt = &finished
+	switch {
+	case ctx.Err() != nil:
+		e.job.Status = StatusCancelled
+	case err != nil:
+		e.job.Status = StatusFailed
+		e.job.Error = err.Error()
+	default:
+		e.job.Status = StatusDone
+	}
+}
+
+// pruneLocked drops the oldest finished jobs beyond historyLimit.
+func (q *Queue) pruneLocked() {
+	excess := len(q.order) - historyLimit
+	if excess <= 0 {
+		return
+	}
+	kept := q.order[:0]
+	for _, id := range q.order {
+		if excess > 0 && q.jobs[id].job.finished() {
+			delete(q.jobs, id)
+			excess--
+			continue
+		}
+		kept = append(kept, id)
+	}
+	q.order = kept
+}
+
+func (j Job) snapshot() Job {
+	j.Errors = append([]string(nil), j.Errors...)
+	return j
+}
+
+// Progress records the outcome of a job's items.
+type Progress struct {
+	queue *Queue
+	entry *entry
+}
+
+// Done counts an item that succeeded with bytes written.
+func (p *Progress) Done(bytes int64) {
+	p.queue.mu.Lock()
+	defer p.queue.mu.Unlock()
+	p.entry.job.Completed++
+	p.entry.job.Bytes += bytes
+}
+
+// Fail counts an item that failed with err.
+func (p *Progress) Fail(err error) {
+	p.queue.mu.Lock()
+	defer p.queue.mu.Unlock()
+	p.entry.job.Failed++
+	if len(p.entry.job.Errors) < errorLimit {
+		p.entry.job.Errors = append(p.entry.job.Errors, err.Error())
+	}
+}
+
+func newID() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
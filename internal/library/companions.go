@@ -0,0 +1,47 @@
+package library
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CompanionExtensions are the per-track sidecar files (lyrics, cover,
+// metadata) that may sit next to an audio file under the same name. They
+// follow the audio file when it is moved or deleted.
+var CompanionExtensions = []string{".lrc", ".jpg", ".nfo", ".json"}
+
+func companionPaths(audioPath string) []string {
+	stem := strings.TrimSuffix(audioPath, filepath.Ext(audioPath))
+	paths := make([]string, 0, len(CompanionExtensions))
+	for _, ext := range CompanionExtensions {
+		if _, err := os.Stat(stem + ext); err == nil {
+			paths = append(paths, stem+ext)
+		}
+	}
+	return paths
+}
+
+// removeCompanionsLocked deletes the sidecars of a removed audio file,
+// unless another indexed file (another format of the same song) still
+// shares them.
+func (l *Library) removeCompanionsLocked(audioPath string) {
+	stem := strings.TrimSuffix(audioPath, filepath.Ext(audioPath))
+	for path := range l.entries {
+		if path != audioPath && strings.TrimSuffix(path, filepath.Ext(path)) == stem {
+			return
+		}
+	}
+	for _, path := range companionPaths(audioPath) {
+		_ = os.Remove(path)
+	}
+}
+
+// moveCompanions renames the sidecars of from to match to. Failures are
+// ignored: a missing sidecar never blocks moving the audio itself.
+func moveCompanions(from string, to string) {
+	toStem := strings.TrimSuffix(to, filepath.Ext(to))
+	for _, path := range companionPaths(from) {
+		_ = os.Rename(path, toStem+filepath.Ext(path))
+	}
+}
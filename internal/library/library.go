@@ -0,0 +1,444 @@
+package library
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"wyapi-golang/internal/atomicfile"
+)
+
+var audioExtensions = map[string]bool{
+	".mp3":  true,
+	".flac": true,
+	".m4a":  true,
+	".mp4":  true,
+	".aac":  true,
+	".ogg":  true,
+	".opus": true,
+	".wav":  true,
+}
+
+var ErrNotFound = errors.New("library entry not found")
+
+type Tags struct {
+	Title   string `json:"title"`
+	Artists string `json:"artists"`
+	Album   string `json:"album"`
+}
+
+// Entry is one audio file known to the library. ID is 0 for files found by a
+// scan that were not written by the downloader.
+type Entry struct {
+	ID           int64     `json:"id"`
+	Quality      string    `json:"quality"`
+	Path         string    `json:"path"`
+	FileType     string    `json:"file_type"`
+	Size         int64     `json:"size"`
+	Checksum     string    `json:"checksum"`
+	Tags         Tags      `json:"tags"`
+	DownloadedAt time.Time `json:"downloaded_at"`
+	// LastAccessedAt is updated whenever the copy is served; eviction
+	// removes the least recently used entries first.
+	LastAccessedAt time.Time   `json:"last_accessed_at"`
+	Pinned         bool        `json:"pinned,omitempty"`
+	AlbumID        int64       `json:"album_id,omitempty"`
+	ReplayGain     *ReplayGain `json:"replaygain,omitempty"`
+}
+
+// Library indexes the audio files under a download directory and persists
+// the index as JSON.
+type Library struct {
+	dir       string
+	indexPath string
+
+	mu      sync.RWMutex
+	entries map[string]*Entry
+}
+
+func Open(dir string, indexPath string) (*Library, error) {
+	if dir == "" {
+		return nil, errors.New("library dir empty")
+	}
+	if indexPath == "" {
+		return nil, errors.New("library index path empty")
+	}
+
+	l := &Library{dir: dir, indexPath: indexPath, entries: map[string]*Entry{}}
+
+	data, err := os.ReadFile(indexPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return l, nil
+		}
+		return nil, err
+	}
+
+	var entries []*Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		l.entries[entry.Path] = entry
+	}
+	return l, nil
+}
+
+func (l *Library) Dir() string {
+	return l.dir
+}
+
+// Add records entry, filling size and checksum from disk when missing.
+func (l *Library) Add(entry Entry) (*Entry, error) {
+	if entry.Path == "" {
+		return nil, errors.New("library entry path empty")
+	}
+	entry.Path = filepath.Clean(entry.Path)
+
+	stat, err := os.Stat(entry.Path)
+	if err != nil {
+		return nil, err
+	}
+	entry.Size = stat.Size()
+	if entry.Checksum == "" {
+		entry.Checksum, err = fileChecksum(entry.Path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if entry.FileType == "" {
+		entry.FileType = strings.TrimPrefix(strings.ToLower(filepath.Ext(entry.Path)), ".")
+	}
+	if entry.DownloadedAt.IsZero() {
+		entry.DownloadedAt = stat.ModTime()
+	}
+	if entry.LastAccessedAt.IsZero() {
+		entry.LastAccessedAt = entry.DownloadedAt
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if existing, ok := l.entries[entry.Path]; ok && existing.Pinned {
+		entry.Pinned = true
+	}
+
+	l.entries[entry.Path] = &entry
+	if err := l.saveLocked(); err != nil {
+		return nil, err
+	}
+	stored := entry
+	return &stored, nil
+}
+
+// Best returns the highest-quality copy of songID that satisfies quality
+// and whose file is still on disk. An empty quality accepts any copy.
+func (l *Library) Best(songID int64, quality string) (*Entry, bool) {
+	if l == nil || songID == 0 {
+		return nil, false
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	var best *Entry
+	for _, entry := range l.entries {
+		if entry.ID != songID || (quality != "" && !Satisfies(entry.Quality, quality)) {
+			continue
+		}
+		if best != nil && QualityRank(entry.Quality) <= QualityRank(best.Quality) {
+			continue
+		}
+		if _, err := os.Stat(entry.Path); err != nil {
+			continue
+		}
+		best = entry
+	}
+	if best == nil {
+		return nil, false
+	}
+	found := *best
+	return &found, true
+}
+
+// Lookup returns the entry recorded for path, if any.
+func (l *Library) Lookup(path string) (*Entry, bool) {
+	if l == nil {
+		return nil, false
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	entry, ok := l.entries[filepath.Clean(path)]
+	if !ok {
+		return nil, false
+	}
+	found := *entry
+	return &found, true
+}
+
+// List returns entries matching query (case-insensitive against tags and
+// path), newest first, plus the total number of matches.
+func (l *Library) List(query string, offset int, limit int) ([]Entry, int) {
+	l.mu.RLock()
+	matched := make([]Entry, 0, len(l.entries))
+	query = strings.ToLower(strings.TrimSpace(query))
+	for _, entry := range l.entries {
+		if query == "" || entry.matches(query) {
+			matched = append(matched, *entry)
+		}
+	}
+	l.mu.RUnlock()
+
+	sort.Slice(matched, func(i, j int) bool {
+		if matched[i].DownloadedAt.Equal(matched[j].DownloadedAt) {
+			return matched[i].Path < matched[j].Path
+		}
+		return matched[i].DownloadedAt.After(matched[j].DownloadedAt)
+	})
+
+	total := len(matched)
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > total {
+		offset = total
+	}
+	end := total
+	if limit > 0 && offset+limit < total {
+		end = offset + limit
+	}
+	return matched[offset:end], total
+}
+
+// Delete removes every entry of songID (or the single entry at path when
+// songID is 0) together with its file and sidecars.
+func (l *Library) Delete(songID int64, path string) ([]Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	path = filepath.Clean(path)
+	removed := []Entry{}
+	for key, entry := range l.entries {
+		if (songID != 0 && entry.ID == songID) || (songID == 0 && entry.Path == path) {
+			if err := os.Remove(entry.Path); err != nil && !os.IsNotExist(err) {
+				return removed, err
+			}
+			removed = append(removed, *entry)
+			delete(l.entries, key)
+			l.removeCompanionsLocked(entry.Path)
+		}
+	}
+	if len(removed) == 0 {
+		return nil, ErrNotFound
+	}
+	return removed, l.saveLocked()
+}
+
+// Move renames the file at from to to and carries its entry and sidecars
+// along.
+func (l *Library) Move(from string, to string) error {
+	from = filepath.Clean(from)
+	to = filepath.Clean(to)
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	moveCompanions(from, to)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, ok := l.entries[from]
+	if !ok {
+		return nil
+	}
+	delete(l.entries, from)
+	entry.Path = to
+	if ext := strings.ToLower(filepath.Ext(to)); audioExtensions[ext] {
+		entry.FileType = strings.TrimPrefix(ext, ".")
+	}
+	l.entries[to] = entry
+	return l.saveLocked()
+}
+
+// Scan reconciles the index with the download directory: entries whose file
+// disappeared are dropped, changed files are re-hashed and unknown audio
+// files are added with tags guessed from an "Artist - Title" file name.
+func (l *Library) Scan() (added int, removed int, err error) {
+	found, err := l.walk()
+	if err != nil {
+		return 0, 0, err
+	}
+	return l.reconcile(found)
+}
+
+// walk lists the audio files under the library directory. It runs without
+// the lock, so downloads can be recorded meanwhile.
+func (l *Library) walk() (map[string]fs.FileInfo, error) {
+	found := map[string]fs.FileInfo{}
+	err := filepath.WalkDir(l.dir, func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			if os.IsNotExist(walkErr) && path == l.dir {
+				return filepath.SkipDir
+			}
+			return walkErr
+		}
+		if d.IsDir() || !audioExtensions[strings.ToLower(filepath.Ext(path))] {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		found[filepath.Clean(path)] = info
+		return nil
+	})
+	return found, err
+}
+
+// reconcile updates the index to the files walk found. Entries added or
+// moved after the walk are not in found, so an entry is only dropped once
+// its file is confirmed gone.
+func (l *Library) reconcile(found map[string]fs.FileInfo) (added int, removed int, err error) {
+	l.mu.RLock()
+	pending := map[string]fs.FileInfo{}
+	for path, info := range found {
+		entry, ok := l.entries[path]
+		if !ok || entry.Size != info.Size() {
+			pending[path] = info
+		}
+	}
+	l.mu.RUnlock()
+
+	hashed := map[string]string{}
+	for path := range pending {
+		sum, err := fileChecksum(path)
+		if err != nil {
+			return 0, 0, err
+		}
+		hashed[path] = sum
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for path := range l.entries {
+		if _, ok := found[path]; ok {
+			continue
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			continue
+		}
+		delete(l.entries, path)
+		removed++
+	}
+	for path, info := range pending {
+		if entry, ok := l.entries[path]; ok {
+			entry.Size = info.Size()
+			entry.Checksum = hashed[path]
+			continue
+		}
+		l.entries[path] = &Entry{
+			Path:           path,
+			FileType:       strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), "."),
+			Size:           info.Size(),
+			Checksum:       hashed[path],
+			Tags:           tagsFromFilename(path),
+			DownloadedAt:   info.ModTime(),
+			LastAccessedAt: info.ModTime(),
+		}
+		added++
+	}
+
+	if added > 0 || removed > 0 || len(pending) > 0 {
+		err = l.saveLocked()
+	}
+	return added, removed, err
+}
+
+func (l *Library) saveLocked() error {
+	entries := make([]*Entry, 0, len(l.entries))
+	for _, entry := range l.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteBytes(l.indexPath, data, 0644)
+}
+
+func (e *Entry) matches(query string) bool {
+	for _, field := range []string{e.Tags.Title, e.Tags.Artists, e.Tags.Album, e.Path} {
+		if strings.Contains(strings.ToLower(field), query) {
+			return true
+		}
+	}
+	return false
+}
+
+// fidelityRank orders the plain quality levels by fidelity. The effect
+// levels (sky, jyeffect, jymaster, dolby) are differently processed
+// masters rather than better copies, so they are left out.
+var fidelityRank = map[string]int{
+	"standard": 1,
+	"exhigh":   2,
+	"lossless": 3,
+	"hires":    4,
+}
+
+// QualityRank returns the fidelity rank of level; effect and unknown levels
+// rank 0, below every plain level.
+func QualityRank(level string) int {
+	return fidelityRank[level]
+}
+
+// Satisfies reports whether a copy at quality have can serve a request for
+// want: the same level, or a plain level at least as good as a plain want.
+// Effect levels only satisfy, and are only satisfied by, themselves.
+func Satisfies(have, want string) bool {
+	if have == want {
+		return true
+	}
+	haveRank, wantRank := QualityRank(have), QualityRank(want)
+	return haveRank > 0 && wantRank > 0 && haveRank >= wantRank
+}
+
+func tagsFromFilename(path string) Tags {
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	parts := strings.SplitN(base, " - ", 2)
+	if len(parts) == 2 {
+		return Tags{Artists: strings.TrimSpace(parts[0]), Title: strings.TrimSpace(parts[1])}
+	}
+	return Tags{Title: base}
+}
+
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
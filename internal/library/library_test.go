@@ -0,0 +1,67 @@
+package library
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScanKeepsEntriesAddedDuringWalk(t *testing.T) {
+	lib := openLibrary(t)
+	existing := filepath.Join(lib.Dir(), "Artist - Existing.mp3")
+	writeFile(t, existing, 100)
+	if _, err := lib.Add(Entry{ID: 1, Path: existing}); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := lib.walk()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A download finishes after the walk but before the index is updated.
+	downloaded := filepath.Join(lib.Dir(), "Artist - Downloaded.mp3")
+	writeFile(t, downloaded, 100)
+	if _, err := lib.Add(Entry{ID: 2, Path: downloaded}); err != nil {
+		t.Fatal(err)
+	}
+	if _, removed, err := lib.reconcile(found); err != nil || removed != 0 {
+		t.Fatalf("reconcile() removed %d, %v; want nothing removed", removed, err)
+	}
+	for _, path := range []string{existing, downloaded} {
+		if _, ok := lib.Lookup(path); !ok {
+			t.Errorf("%s dropped from the index", filepath.Base(path))
+		}
+	}
+}
+
+func TestScanDropsDeletedFiles(t *testing.T) {
+	lib := openLibrary(t)
+	kept := filepath.Join(lib.Dir(), "Artist - Kept.mp3")
+	deleted := filepath.Join(lib.Dir(), "Artist - Deleted.mp3")
+	for i, path := range []string{kept, deleted} {
+		writeFile(t, path, 100)
+		if _, err := lib.Add(Entry{ID: int64(i + 1), Path: path}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Remove(deleted); err != nil {
+		t.Fatal(err)
+	}
+	other := filepath.Join(lib.Dir(), "sub", "Someone - Other.flac")
+	writeFile(t, other, 50)
+
+	added, removed, err := lib.Scan()
+	if err != nil || added != 1 || removed != 1 {
+		t.Fatalf("Scan() = %d, %d, %v; want 1 added and 1 removed", added, removed, err)
+	}
+	if _, ok := lib.Lookup(deleted); ok {
+		t.Error("deleted file still indexed")
+	}
+	entry, ok := lib.Lookup(other)
+	if !ok {
+		t.Fatal("new file not indexed")
+	}
+	if entry.ID != 0 || entry.Tags.Artists != "Someone" || entry.Tags.Title != "Other" {
+		t.Errorf("scanned entry = %+v", entry)
+	}
+}
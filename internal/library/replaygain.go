@@ -0,0 +1,50 @@
+package library
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// ReplayGain is the loudness analysis of an entry. Gains are in dB towards
+// the ReplayGain 2.0 reference of -18 LUFS, peaks are linear sample values.
+// The album values cover AlbumTracks analyzed tracks of the same album and
+// are unset while AlbumTracks is 0.
+type ReplayGain struct {
+	Loudness    float64 `json:"loudness"` // integrated, LUFS
+	DurationMs  int64   `json:"duration_ms"`
+	TrackGain   float64 `json:"track_gain"`
+	TrackPeak   float64 `json:"track_peak"`
+	AlbumGain   float64 `json:"album_gain"`
+	AlbumPeak   float64 `json:"album_peak"`
+	AlbumTracks int     `json:"album_tracks"`
+}
+
+// SetReplayGain stores the analysis of the file at path. The file was
+// rewritten with the new tags, so its size and checksum are refreshed too.
+func (l *Library) SetReplayGain(path string, gain *ReplayGain) error {
+	if l == nil {
+		return nil
+	}
+	path = filepath.Clean(path)
+	stat, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	sum, err := fileChecksum(path)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, ok := l.entries[path]
+	if !ok {
+		return ErrNotFound
+	}
+	entry.Size = stat.Size()
+	entry.Checksum = sum
+	stored := *gain
+	entry.ReplayGain = &stored
+	return l.saveLocked()
+}
@@ -0,0 +1,137 @@
+package library
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Policy bounds how much the library may keep of what the downloader
+// wrote. Zero values disable the corresponding limit. Pinned entries,
+// flagged on the entry or listed in PinnedIDs, are never evicted, nor are
+// paths Keep reports, such as tracks a playlist subscription mirrors and
+// would otherwise download again. Files a scan found (ID 0) belong to the
+// operator: they are never evicted and do not count against MaxBytes.
+type Policy struct {
+	MaxBytes  int64
+	MaxAge    time.Duration
+	PinnedIDs map[int64]bool
+	Keep      func(path string) bool
+}
+
+// Touch records that the copy at path was just served. The change is kept
+// in memory and written with the next index update.
+func (l *Library) Touch(path string) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if entry, ok := l.entries[filepath.Clean(path)]; ok {
+		entry.LastAccessedAt = time.Now()
+	}
+}
+
+// SetPinned flags every copy of songID and reports how many were changed.
+func (l *Library) SetPinned(songID int64, pinned bool) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	found := 0
+	for _, entry := range l.entries {
+		if entry.ID == songID {
+			entry.Pinned = pinned
+			found++
+		}
+	}
+	if found == 0 {
+		return 0, ErrNotFound
+	}
+	return found, l.saveLocked()
+}
+
+// Usage returns the number of indexed files and their total size.
+func (l *Library) Usage() (int, int64) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	var total int64
+	for _, entry := range l.entries {
+		total += entry.Size
+	}
+	return len(l.entries), total
+}
+
+// ErrNoRoom is returned by Evict when reserve bytes cannot fit within
+// policy.MaxBytes even after evicting every unpinned entry.
+var ErrNoRoom = errors.New("library cannot make room within its size limit")
+
+// Evict deletes downloaded entries not used within policy.MaxAge, then the
+// least recently used ones until they plus reserve bytes fit in
+// policy.MaxBytes. It returns the evicted entries. When reserve cannot fit
+// however much is evicted, nothing is deleted and ErrNoRoom is returned.
+func (l *Library) Evict(policy Policy, reserve int64) ([]Entry, error) {
+	if l == nil || (policy.MaxBytes <= 0 && policy.MaxAge <= 0) {
+		return nil, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	candidates := make([]*Entry, 0, len(l.entries))
+	var total, pinned int64
+	for _, entry := range l.entries {
+		if entry.ID == 0 {
+			continue
+		}
+		total += entry.Size
+		if entry.Pinned || policy.PinnedIDs[entry.ID] || (policy.Keep != nil && policy.Keep(entry.Path)) {
+			pinned += entry.Size
+			continue
+		}
+		candidates = append(candidates, entry)
+	}
+	if reserve > 0 && policy.MaxBytes > 0 && pinned+reserve > policy.MaxBytes {
+		return nil, ErrNoRoom
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].lastUse().Before(candidates[j].lastUse())
+	})
+
+	cutoff := time.Now().Add(-policy.MaxAge)
+	evicted := []Entry{}
+	var removeErr error
+	for _, entry := range candidates {
+		expired := policy.MaxAge > 0 && entry.lastUse().Before(cutoff)
+		oversize := policy.MaxBytes > 0 && total+reserve > policy.MaxBytes
+		if !expired && !oversize {
+			continue
+		}
+		if err := os.Remove(entry.Path); err != nil && !os.IsNotExist(err) {
+			removeErr = err
+			break
+		}
+		total -= entry.Size
+		evicted = append(evicted, *entry)
+		delete(l.entries, entry.Path)
+		l.removeCompanionsLocked(entry.Path)
+	}
+
+	if len(evicted) > 0 {
+		if err := l.saveLocked(); err != nil {
+			return evicted, err
+		}
+	}
+	return evicted, removeErr
+}
+
+func (e *Entry) lastUse() time.Time {
+	if e.LastAccessedAt.After(e.DownloadedAt) {
+		return e.LastAccessedAt
+	}
+	return e.DownloadedAt
+}
@@ -0,0 +1,111 @@
+package library
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, path string, size int) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func openLibrary(t *testing.T) *Library {
+	t.Helper()
+	dir := t.TempDir()
+	lib, err := Open(filepath.Join(dir, "music"), filepath.Join(dir, "library.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lib
+}
+
+func TestEvictKeepsScannedFiles(t *testing.T) {
+	lib := openLibrary(t)
+	old := time.Now().Add(-48 * time.Hour)
+
+	own := filepath.Join(lib.Dir(), "Artist - Own Song.flac")
+	writeFile(t, own, 1000)
+	if err := os.Chtimes(own, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if added, _, err := lib.Scan(); err != nil || added != 1 {
+		t.Fatalf("Scan() = %d, %v; want 1 file added", added, err)
+	}
+
+	downloaded := filepath.Join(lib.Dir(), "Artist - Downloaded.mp3")
+	writeFile(t, downloaded, 100)
+	if _, err := lib.Add(Entry{ID: 1, Quality: "exhigh", Path: downloaded, DownloadedAt: old}); err != nil {
+		t.Fatal(err)
+	}
+
+	evicted, err := lib.Evict(Policy{MaxBytes: 50, MaxAge: time.Hour}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evicted) != 1 || evicted[0].Path != downloaded {
+		t.Errorf("evicted %+v, want only the downloaded file", evicted)
+	}
+	if _, err := os.Stat(own); err != nil {
+		t.Errorf("scanned file was removed: %v", err)
+	}
+	if _, ok := lib.Lookup(own); !ok {
+		t.Error("scanned file dropped from the index")
+	}
+}
+
+func TestEvictIgnoresScannedFilesForSize(t *testing.T) {
+	lib := openLibrary(t)
+	writeFile(t, filepath.Join(lib.Dir(), "Artist - Own Song.flac"), 1000)
+	if _, _, err := lib.Scan(); err != nil {
+		t.Fatal(err)
+	}
+
+	downloaded := filepath.Join(lib.Dir(), "Artist - Downloaded.mp3")
+	writeFile(t, downloaded, 100)
+	if _, err := lib.Add(Entry{ID: 1, Quality: "exhigh", Path: downloaded}); err != nil {
+		t.Fatal(err)
+	}
+
+	evicted, err := lib.Evict(Policy{MaxBytes: 200}, 50)
+	if err != nil {
+		t.Fatalf("Evict() = %v, want room for the reserve", err)
+	}
+	if len(evicted) != 0 {
+		t.Errorf("evicted %+v although the downloads fit", evicted)
+	}
+}
+
+func TestEvictLeastRecentlyUsed(t *testing.T) {
+	lib := openLibrary(t)
+	now := time.Now()
+	for i, age := range []time.Duration{3 * time.Hour, time.Hour, 2 * time.Hour} {
+		path := filepath.Join(lib.Dir(), string(rune('a'+i))+".mp3")
+		writeFile(t, path, 100)
+		if _, err := lib.Add(Entry{ID: int64(i + 1), Path: path, DownloadedAt: now.Add(-age)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := lib.SetPinned(1, true); err != nil {
+		t.Fatal(err)
+	}
+
+	evicted, err := lib.Evict(Policy{MaxBytes: 250}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evicted) != 1 || evicted[0].ID != 3 {
+		t.Errorf("evicted %+v, want the oldest unpinned entry (ID 3)", evicted)
+	}
+
+	if _, err := lib.Evict(Policy{MaxBytes: 250}, 200); err != ErrNoRoom {
+		t.Errorf("Evict() with a reserve larger than the unpinned room = %v, want ErrNoRoom", err)
+	}
+}
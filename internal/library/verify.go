@@ -0,0 +1,103 @@
+package library
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"strings"
+
+	"wyapi-golang/internal/probe"
+)
+
+const (
+	StatusOK          = "ok"
+	StatusMissing     = "missing"     // the file is gone
+	StatusUnreadable  = "unreadable"  // not a recognisable audio file
+	StatusTruncated   = "truncated"   // the stream ends early
+	StatusModified    = "modified"    // the content no longer matches the recorded checksum
+	StatusMislabelled = "mislabelled" // the extension does not match the content
+	StatusUnsupported = "unsupported" // a format the probe cannot check, e.g. WAV
+)
+
+// Verification is the result of checking one indexed file.
+type Verification struct {
+	Entry  Entry       `json:"entry"`
+	Status string      `json:"status"`
+	Probe  *probe.Info `json:"probe,omitempty"`
+	Error  string      `json:"error,omitempty"`
+	// Fixed is the new path of a mislabelled file renamed by Verify.
+	Fixed string `json:"fixed,omitempty"`
+}
+
+// Verify probes the files of songID (every entry when 0) and compares them
+// with the index. With fix, mislabelled files are renamed to the extension
+// matching their content, together with their sidecars.
+func (l *Library) Verify(songID int64, fix bool) ([]Verification, error) {
+	l.mu.RLock()
+	var entries []Entry
+	for _, entry := range l.entries {
+		if songID == 0 || entry.ID == songID {
+			entries = append(entries, *entry)
+		}
+	}
+	l.mu.RUnlock()
+	if songID != 0 && len(entries) == 0 {
+		return nil, ErrNotFound
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+
+	results := make([]Verification, 0, len(entries))
+	for _, entry := range entries {
+		result := verifyEntry(entry)
+		if fix && result.Status == StatusMislabelled {
+			to := strings.TrimSuffix(entry.Path, "."+entry.FileType) + "." + result.Probe.Extension()
+			if _, err := os.Stat(to); err == nil {
+				result.Error = "cannot rename: " + to + " already exists"
+			} else if err := l.Move(entry.Path, to); err != nil {
+				result.Error = err.Error()
+			} else {
+				result.Fixed = to
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// probedTypes are the file types probe understands; other files it fails
+// to recognise are not reported as broken.
+var probedTypes = map[string]bool{"flac": true, "mp3": true, "m4a": true, "mp4": true, "ogg": true, "opus": true}
+
+func verifyEntry(entry Entry) Verification {
+	result := Verification{Entry: entry, Status: StatusOK}
+	if _, err := os.Stat(entry.Path); err != nil {
+		result.Status, result.Error = StatusMissing, err.Error()
+		return result
+	}
+
+	info, err := probe.File(entry.Path)
+	if errors.Is(err, probe.ErrUnknownFormat) && !probedTypes[strings.ToLower(entry.FileType)] {
+		result.Status = StatusUnsupported
+		return result
+	}
+	if err != nil {
+		result.Status, result.Error = StatusUnreadable, err.Error()
+		return result
+	}
+	result.Probe = info
+
+	switch {
+	case info.Truncated:
+		result.Status = StatusTruncated
+	case entry.Checksum != "" && !checksumMatches(entry.Path, entry.Checksum):
+		result.Status = StatusModified
+	case !strings.EqualFold(entry.FileType, info.Extension()):
+		result.Status = StatusMislabelled
+	}
+	return result
+}
+
+func checksumMatches(path string, checksum string) bool {
+	sum, err := fileChecksum(path)
+	return err == nil && sum == checksum
+}
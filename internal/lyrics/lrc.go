@@ -0,0 +1,138 @@
+package lyrics
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lastLineDuration is how long the final line stays on screen, since no
+// following timestamp ends it. Every other line lasts until the next
+// timestamp, however long: slow songs hold a line well past any fixed cap.
+const lastLineDuration = 3 * time.Second
+
+// Line is a single timed lyric line. Text holds several lines separated by
+// "\n" when it comes from Merged.Lyrics.
+type Line struct {
+	Start time.Duration
+	End   time.Duration
+	Text  string
+}
+
+// Lyrics is a parsed LRC document with end times resolved.
+type Lyrics struct {
+	Title  string
+	Artist string
+	Album  string
+	Lines  []Line
+}
+
+var (
+	timeTag = regexp.MustCompile(`^\[(\d{1,3}):(\d{1,2})(?:[.:](\d{1,3}))?\]`)
+	metaTag = regexp.MustCompile(`^\[([A-Za-z#]+):(.*)\]$`)
+)
+
+type stamp struct {
+	at   time.Duration
+	text string
+}
+
+// ParseLRC parses LRC text. Lines carrying several timestamps
+// ("[00:12.00][01:30.00]chorus") are repeated at each of them, [offset:] is
+// applied, and a line with a timestamp but no text only ends the previous
+// line. Anything that is not a timestamped line or a known tag is ignored.
+func ParseLRC(text string) *Lyrics {
+	lyrics := &Lyrics{}
+	var offset time.Duration
+	var stamps []stamp
+
+	for _, raw := range strings.Split(text, "\n") {
+		line := strings.TrimSpace(raw)
+		if line == "" {
+			continue
+		}
+
+		var times []time.Duration
+		rest := line
+		for {
+			match := timeTag.FindStringSubmatch(rest)
+			if match == nil {
+				break
+			}
+			times = append(times, parseTimestamp(match[1], match[2], match[3]))
+			rest = rest[len(match[0]):]
+		}
+
+		if len(times) == 0 {
+			if match := metaTag.FindStringSubmatch(line); match != nil {
+				value := strings.TrimSpace(match[2])
+				switch strings.ToLower(match[1]) {
+				case "ti":
+					lyrics.Title = value
+				case "ar":
+					lyrics.Artist = value
+				case "al":
+					lyrics.Album = value
+				case "offset":
+					if ms, err := strconv.Atoi(value); err == nil {
+						offset = time.Duration(ms) * time.Millisecond
+					}
+				}
+			}
+			continue
+		}
+
+		rest = strings.TrimSpace(rest)
+		for _, at := range times {
+			stamps = append(stamps, stamp{at: at, text: rest})
+		}
+	}
+
+	// A positive offset makes the lyrics appear sooner.
+	for i := range stamps {
+		stamps[i].at -= offset
+		if stamps[i].at < 0 {
+			stamps[i].at = 0
+		}
+	}
+	sort.SliceStable(stamps, func(i, j int) bool { return stamps[i].at < stamps[j].at })
+
+	// Of several lines at the same time, the last one with text wins.
+	compact := make([]stamp, 0, len(stamps))
+	for _, s := range stamps {
+		if n := len(compact); n > 0 && compact[n-1].at == s.at {
+			if s.text != "" || compact[n-1].text == "" {
+				compact[n-1] = s
+			}
+			continue
+		}
+		compact = append(compact, s)
+	}
+
+	for i, s := range compact {
+		if s.text == "" {
+			continue
+		}
+		end := s.at + lastLineDuration
+		if i+1 < len(compact) {
+			end = compact[i+1].at
+		}
+		lyrics.Lines = append(lyrics.Lines, Line{Start: s.at, End: end, Text: s.text})
+	}
+	return lyrics
+}
+
+// parseTimestamp converts the captured minute, second and fraction fields;
+// the fraction is read as decimal digits, so "5", "50" and "500" are all
+// half a second.
+func parseTimestamp(minutes, seconds, fraction string) time.Duration {
+	m, _ := strconv.Atoi(minutes)
+	s, _ := strconv.Atoi(seconds)
+	ms := 0
+	if fraction != "" {
+		ms, _ = strconv.Atoi((fraction + "00")[:3])
+	}
+	return time.Duration(m)*time.Minute + time.Duration(s)*time.Second + time.Duration(ms)*time.Millisecond
+}
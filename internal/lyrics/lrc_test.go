@@ -0,0 +1,89 @@
+package lyrics
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func ms(n int) time.Duration {
+	return time.Duration(n) * time.Millisecond
+}
+
+func TestParseLRC(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []Line
+	}{
+		{
+			name: "consecutive lines",
+			text: "[00:01.00]one\n[00:04.50]two\n",
+			want: []Line{{Start: ms(1000), End: ms(4500), Text: "one"}, {Start: ms(4500), End: ms(7500), Text: "two"}},
+		},
+		{
+			// A line lasts until the next timestamp, however far away.
+			name: "long gap",
+			text: "[00:01.00]slow\n[00:30.00]next",
+			want: []Line{{Start: ms(1000), End: ms(30000), Text: "slow"}, {Start: ms(30000), End: ms(33000), Text: "next"}},
+		},
+		{
+			name: "multiple timestamps",
+			text: "[00:10.00][00:30.00]chorus\n[00:20.00]verse",
+			want: []Line{
+				{Start: ms(10000), End: ms(20000), Text: "chorus"},
+				{Start: ms(20000), End: ms(30000), Text: "verse"},
+				{Start: ms(30000), End: ms(33000), Text: "chorus"},
+			},
+		},
+		{
+			name: "empty end marker",
+			text: "[00:01.00]one\n[00:02.00]\n[00:05.00]two",
+			want: []Line{{Start: ms(1000), End: ms(2000), Text: "one"}, {Start: ms(5000), End: ms(8000), Text: "two"}},
+		},
+		{
+			name: "positive offset",
+			text: "[offset:500]\n[00:01.00]one\n[00:00.20]zero",
+			want: []Line{{Start: 0, End: ms(500), Text: "zero"}, {Start: ms(500), End: ms(3500), Text: "one"}},
+		},
+		{
+			name: "negative offset",
+			text: "[offset:-250]\n[00:01.00]one",
+			want: []Line{{Start: ms(1250), End: ms(4250), Text: "one"}},
+		},
+		{
+			name: "fraction digits",
+			text: "[00:01.5]a\n[00:02.50]b\n[00:03.500]c\n[00:04:25]d\n[00:05]e",
+			want: []Line{
+				{Start: ms(1500), End: ms(2500), Text: "a"},
+				{Start: ms(2500), End: ms(3500), Text: "b"},
+				{Start: ms(3500), End: ms(4250), Text: "c"},
+				{Start: ms(4250), End: ms(5000), Text: "d"},
+				{Start: ms(5000), End: ms(8000), Text: "e"},
+			},
+		},
+		{
+			name: "same time keeps text",
+			text: "[00:01.00]first\n[00:01.00]\n[00:01.00]second",
+			want: []Line{{Start: ms(1000), End: ms(4000), Text: "second"}},
+		},
+		{
+			name: "ignores untimed lines",
+			text: "credits\r\n[by:someone]\r\n[00:01.00] one \r\n",
+			want: []Line{{Start: ms(1000), End: ms(4000), Text: "one"}},
+		},
+	}
+	for _, tt := range tests {
+		got := ParseLRC(tt.text)
+		if !reflect.DeepEqual(got.Lines, tt.want) {
+			t.Errorf("%s: lines = %+v, want %+v", tt.name, got.Lines, tt.want)
+		}
+	}
+}
+
+func TestParseLRCTags(t *testing.T) {
+	got := ParseLRC("[ti: Title ]\n[ar:Artist]\n[al:Album]\n[00:01.00]one")
+	if got.Title != "Title" || got.Artist != "Artist" || got.Album != "Album" {
+		t.Errorf("tags = %q, %q, %q", got.Title, got.Artist, got.Album)
+	}
+}
@@ -0,0 +1,112 @@
+package lyrics
+
+import (
+	"strings"
+	"time"
+)
+
+// DefaultTolerance is how far apart a translated or romanized line may be
+// from the original and still be paired with it.
+const DefaultTolerance = 500 * time.Millisecond
+
+// MergedLine is an original line with its translation and romanization.
+type MergedLine struct {
+	Line
+	Translation  string
+	Romanization string
+}
+
+// Merged is a single timeline built from the original lyrics.
+type Merged struct {
+	Title  string
+	Artist string
+	Album  string
+	Lines  []MergedLine
+}
+
+// Merge pairs every original line with the translation and romanization
+// line closest in time, within tolerance. Each companion line is used at
+// most once; unpaired ones are dropped since the original timeline is the
+// one displayed. translation and romanization may be nil.
+func Merge(original, translation, romanization *Lyrics, tolerance time.Duration) *Merged {
+	merged := &Merged{}
+	if original == nil {
+		return merged
+	}
+	merged.Title, merged.Artist, merged.Album = original.Title, original.Artist, original.Album
+
+	merged.Lines = make([]MergedLine, len(original.Lines))
+	for i, line := range original.Lines {
+		merged.Lines[i].Line = line
+	}
+	for i, text := range align(original.Lines, translation, tolerance) {
+		merged.Lines[i].Translation = text
+	}
+	for i, text := range align(original.Lines, romanization, tolerance) {
+		merged.Lines[i].Romanization = text
+	}
+	return merged
+}
+
+// align returns, for each line of lines, the text of the matching line in
+// other. Both are sorted by start time, so a single forward pass suffices.
+func align(lines []Line, other *Lyrics, tolerance time.Duration) []string {
+	texts := make([]string, len(lines))
+	if other == nil {
+		return texts
+	}
+
+	j := 0
+	for i, line := range lines {
+		for j < len(other.Lines) && other.Lines[j].Start < line.Start-tolerance {
+			j++
+		}
+		if j == len(other.Lines) {
+			break
+		}
+		diff := absDuration(other.Lines[j].Start - line.Start)
+		if diff > tolerance {
+			continue
+		}
+		// Leave the companion line to the next original line if it is closer.
+		if i+1 < len(lines) && absDuration(other.Lines[j].Start-lines[i+1].Start) < diff {
+			continue
+		}
+		texts[i] = companionText(other.Lines[j].Text)
+		j++
+	}
+	return texts
+}
+
+// companionText drops the "//" NetEase puts in translations of lines that
+// need none.
+func companionText(text string) string {
+	if strings.Trim(text, "/ ") == "" {
+		return ""
+	}
+	return text
+}
+
+// Lyrics flattens the timeline for Render: each line's translation and
+// romanization follow the original on separate lines of the same cue.
+func (m *Merged) Lyrics() *Lyrics {
+	out := &Lyrics{Title: m.Title, Artist: m.Artist, Album: m.Album, Lines: make([]Line, 0, len(m.Lines))}
+	for _, line := range m.Lines {
+		parts := []string{line.Text}
+		if line.Translation != "" {
+			parts = append(parts, line.Translation)
+		}
+		if line.Romanization != "" {
+			parts = append(parts, line.Romanization)
+		}
+		out.Lines = append(out.Lines, Line{Start: line.Start, End: line.End, Text: strings.Join(parts, "\n")})
+	}
+	return out
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
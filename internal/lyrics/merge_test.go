@@ -0,0 +1,88 @@
+package lyrics
+
+import (
+	"reflect"
+	"testing"
+)
+
+func timed(starts []int, texts ...string) *Lyrics {
+	l := &Lyrics{}
+	for i, start := range starts {
+		l.Lines = append(l.Lines, Line{Start: ms(start), End: ms(start + 1000), Text: texts[i]})
+	}
+	return l
+}
+
+func TestAlign(t *testing.T) {
+	original := timed([]int{1000, 3000, 5000, 7000}, "a", "b", "c", "d").Lines
+
+	tests := []struct {
+		name  string
+		other *Lyrics
+		want  []string
+	}{
+		{name: "nil", other: nil, want: []string{"", "", "", ""}},
+		{name: "exact", other: timed([]int{1000, 3000, 5000, 7000}, "A", "B", "C", "D"), want: []string{"A", "B", "C", "D"}},
+		{name: "within tolerance", other: timed([]int{1400, 2600, 5500, 7501}, "A", "B", "C", "D"), want: []string{"A", "B", "C", ""}},
+		// One translation line is missing; the others stay on their lines.
+		{name: "missing line", other: timed([]int{1000, 5000, 7000}, "A", "C", "D"), want: []string{"A", "", "C", "D"}},
+		{name: "drifted beyond tolerance", other: timed([]int{1800, 3800, 5800, 7800}, "A", "B", "C", "D"), want: []string{"", "", "", ""}},
+		// Each companion line pairs with at most one original line.
+		{name: "used once", other: timed([]int{3000}, "B"), want: []string{"", "B", "", ""}},
+		{name: "extra lines dropped", other: timed([]int{0, 1000, 2000, 3000, 9000}, "x", "A", "y", "B", "z"), want: []string{"A", "B", "", ""}},
+		{name: "placeholder translation", other: timed([]int{1000, 3000}, "//", "B"), want: []string{"", "B", "", ""}},
+	}
+	for _, tt := range tests {
+		if got := align(original, tt.other, DefaultTolerance); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: align() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAlignPrefersCloserLine(t *testing.T) {
+	// 600 ms apart: the companion at 1400 is within tolerance of both, and
+	// belongs to the original line at 1600.
+	original := timed([]int{1000, 1600}, "a", "b").Lines
+	got := align(original, timed([]int{1400}, "B"), DefaultTolerance)
+	if want := []string{"", "B"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("align() = %q, want %q", got, want)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	original := timed([]int{1000, 3000}, "こんにちは", "さようなら")
+	original.Title = "Title"
+	translation := timed([]int{1100, 3000}, "你好", "再见")
+	romanization := timed([]int{900}, "konnichiwa")
+
+	merged := Merge(original, translation, romanization, DefaultTolerance)
+	if merged.Title != "Title" || len(merged.Lines) != 2 {
+		t.Fatalf("merged = %+v", merged)
+	}
+	first, second := merged.Lines[0], merged.Lines[1]
+	if first.Translation != "你好" || first.Romanization != "konnichiwa" || second.Translation != "再见" || second.Romanization != "" {
+		t.Errorf("merged lines = %+v", merged.Lines)
+	}
+	if first.Start != ms(1000) || first.End != ms(2000) {
+		t.Errorf("first line timed %v-%v, want the original timing", first.Start, first.End)
+	}
+
+	flat := merged.Lyrics()
+	if flat.Lines[0].Text != "こんにちは\n你好\nkonnichiwa" || flat.Lines[1].Text != "さようなら\n再见" {
+		t.Errorf("flattened = %q, %q", flat.Lines[0].Text, flat.Lines[1].Text)
+	}
+}
+
+func TestMergeNilInputs(t *testing.T) {
+	if merged := Merge(nil, timed([]int{1000}, "A"), nil, DefaultTolerance); len(merged.Lines) != 0 {
+		t.Errorf("Merge(nil original) = %+v", merged)
+	}
+
+	merged := Merge(timed([]int{1000}, "a"), nil, nil, DefaultTolerance)
+	if len(merged.Lines) != 1 || merged.Lines[0].Translation != "" || merged.Lines[0].Romanization != "" {
+		t.Errorf("Merge(without companions) = %+v", merged)
+	}
+	if got := merged.Lyrics().Lines[0].Text; got != "a" {
+		t.Errorf("flattened text = %q, want the original only", got)
+	}
+}
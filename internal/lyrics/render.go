@@ -0,0 +1,192 @@
+package lyrics
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"time"
+)
+
+// Format is an output format for Render.
+type Format string
+
+const (
+	FormatLRC  Format = "lrc"
+	FormatSRT  Format = "srt"
+	FormatVTT  Format = "vtt"
+	FormatASS  Format = "ass"
+	FormatTTML Format = "ttml"
+	FormatText Format = "txt"
+)
+
+// ParseFormat accepts a format name or its common aliases.
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "lrc":
+		return FormatLRC, nil
+	case "srt":
+		return FormatSRT, nil
+	case "vtt", "webvtt":
+		return FormatVTT, nil
+	case "ass", "ssa":
+		return FormatASS, nil
+	case "ttml", "dfxp":
+		return FormatTTML, nil
+	case "txt", "text", "plain":
+		return FormatText, nil
+	}
+	return "", fmt.Errorf("unsupported lyric format %q", name)
+}
+
+// Extension is the file extension for the format, without the dot.
+func (f Format) Extension() string {
+	return string(f)
+}
+
+func (f Format) ContentType() string {
+	switch f {
+	case FormatVTT:
+		return "text/vtt; charset=utf-8"
+	case FormatTTML:
+		return "application/ttml+xml; charset=utf-8"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// Render writes the lyrics in format f. Empty lyrics render as an empty
+// string for every format.
+func Render(l *Lyrics, f Format) string {
+	if l == nil || len(l.Lines) == 0 {
+		return ""
+	}
+	switch f {
+	case FormatSRT:
+		return renderSRT(l)
+	case FormatVTT:
+		return renderVTT(l)
+	case FormatASS:
+		return renderASS(l)
+	case FormatTTML:
+		return renderTTML(l)
+	case FormatText:
+		return renderText(l)
+	}
+	return renderLRC(l)
+}
+
+func renderLRC(l *Lyrics) string {
+	var b strings.Builder
+	for _, tag := range [][2]string{{"ti", l.Title}, {"ar", l.Artist}, {"al", l.Album}} {
+		if tag[1] != "" {
+			fmt.Fprintf(&b, "[%s:%s]\n", tag[0], tag[1])
+		}
+	}
+	for i, line := range l.Lines {
+		// Stacked lines share the timestamp, which players show together.
+		for _, text := range strings.Split(line.Text, "\n") {
+			fmt.Fprintf(&b, "[%s]%s\n", lrcTime(line.Start), text)
+		}
+		// Keep the computed end when nothing else starts there.
+		if i+1 == len(l.Lines) || l.Lines[i+1].Start > line.End {
+			fmt.Fprintf(&b, "[%s]\n", lrcTime(line.End))
+		}
+	}
+	return b.String()
+}
+
+// renderSRT uses CRLF line endings, which some Windows players require.
+func renderSRT(l *Lyrics) string {
+	var b strings.Builder
+	for i, line := range l.Lines {
+		fmt.Fprintf(&b, "%d\r\n%s --> %s\r\n%s\r\n\r\n", i+1, clockTime(line.Start, ","), clockTime(line.End, ","), strings.ReplaceAll(line.Text, "\n", "\r\n"))
+	}
+	return b.String()
+}
+
+func renderVTT(l *Lyrics) string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n\n")
+	for _, line := range l.Lines {
+		fmt.Fprintf(&b, "%s --> %s\n%s\n\n", clockTime(line.Start, "."), clockTime(line.End, "."), vttEscaper.Replace(line.Text))
+	}
+	return b.String()
+}
+
+var vttEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "-->", "--&gt;")
+
+func renderASS(l *Lyrics) string {
+	var b strings.Builder
+	writeASSHeader(&b, l.Title)
+	for _, line := range l.Lines {
+		fmt.Fprintf(&b, "Dialogue: 0,%s,%s,Default,,0,0,0,,%s\n", assTime(line.Start), assTime(line.End), assEscaper.Replace(line.Text))
+	}
+	return b.String()
+}
+
+// writeASSHeader writes the script info, the single Default style and the
+// events format line.
+func writeASSHeader(b *strings.Builder, title string) {
+	b.WriteString("[Script Info]\n")
+	if title != "" {
+		fmt.Fprintf(b, "Title: %s\n", title)
+	}
+	b.WriteString("ScriptType: v4.00+\nPlayResX: 1920\nPlayResY: 1080\nWrapStyle: 0\n\n")
+	b.WriteString("[V4+ Styles]\n")
+	b.WriteString("Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding\n")
+	b.WriteString("Style: Default,Arial,64,&H00FFFFFF,&H000000FF,&H00000000,&H80000000,0,0,0,0,100,100,0,0,1,3,1,2,60,60,80,1\n\n")
+	b.WriteString("[Events]\n")
+	b.WriteString("Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\n")
+}
+
+// assEscaper keeps lyric text from being read as override blocks and turns
+// stacked lines into ASS line breaks.
+var assEscaper = strings.NewReplacer("{", "(", "}", ")", `\`, `\\`, "\n", `\N`)
+
+func renderTTML(l *Lyrics) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	b.WriteString(`<tt xmlns="http://www.w3.org/ns/ttml" xmlns:ttm="http://www.w3.org/ns/ttml#metadata" xml:lang="">` + "\n")
+	if l.Title != "" || l.Artist != "" {
+		b.WriteString("  <head>\n    <metadata>\n")
+		if l.Title != "" {
+			fmt.Fprintf(&b, "      <ttm:title>%s</ttm:title>\n", html.EscapeString(l.Title))
+		}
+		if l.Artist != "" {
+			fmt.Fprintf(&b, "      <ttm:agent type=\"person\"><ttm:name type=\"full\">%s</ttm:name></ttm:agent>\n", html.EscapeString(l.Artist))
+		}
+		b.WriteString("    </metadata>\n  </head>\n")
+	}
+	b.WriteString("  <body>\n    <div>\n")
+	for _, line := range l.Lines {
+		fmt.Fprintf(&b, "      <p begin=\"%s\" end=\"%s\">%s</p>\n", clockTime(line.Start, "."), clockTime(line.End, "."), strings.ReplaceAll(html.EscapeString(line.Text), "\n", "<br/>"))
+	}
+	b.WriteString("    </div>\n  </body>\n</tt>\n")
+	return b.String()
+}
+
+func renderText(l *Lyrics) string {
+	var b strings.Builder
+	for _, line := range l.Lines {
+		b.WriteString(line.Text)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// clockTime formats d as HH:MM:SS followed by sep and milliseconds.
+func clockTime(d time.Duration, sep string) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}
+
+// lrcTime formats d as mm:ss.xx; minutes may exceed 59.
+func lrcTime(d time.Duration) string {
+	cs := d.Milliseconds() / 10
+	return fmt.Sprintf("%02d:%02d.%02d", cs/6000, cs/100%60, cs%100)
+}
+
+// assTime formats d as H:MM:SS.cc.
+func assTime(d time.Duration) string {
+	cs := d.Milliseconds() / 10
+	return fmt.Sprintf("%d:%02d:%02d.%02d", cs/360000, cs/6000%60, cs/100%60, cs%100)
+}
@@ -0,0 +1,103 @@
+package lyrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func sample() *Lyrics {
+	return &Lyrics{
+		Title:  "A & B",
+		Artist: "Artist",
+		Lines: []Line{
+			{Start: ms(1500), End: ms(4250), Text: "one"},
+			{Start: 61*time.Minute + ms(2010), End: 61*time.Minute + ms(5000), Text: "<two> & {three}\nsecond row"},
+		},
+	}
+}
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		format Format
+		want   string
+	}{
+		{
+			format: FormatLRC,
+			want: "[ti:A & B]\n[ar:Artist]\n" +
+				"[00:01.50]one\n[00:04.25]\n" +
+				"[61:02.01]<two> & {three}\n[61:02.01]second row\n[61:05.00]\n",
+		},
+		{
+			format: FormatSRT,
+			want: "1\r\n00:00:01,500 --> 00:00:04,250\r\none\r\n\r\n" +
+				"2\r\n01:01:02,010 --> 01:01:05,000\r\n<two> & {three}\r\nsecond row\r\n\r\n",
+		},
+		{
+			format: FormatVTT,
+			want: "WEBVTT\n\n" +
+				"00:00:01.500 --> 00:00:04.250\none\n\n" +
+				"01:01:02.010 --> 01:01:05.000\n&lt;two&gt; &amp; {three}\nsecond row\n\n",
+		},
+		{
+			format: FormatText,
+			want:   "one\n<two> & {three}\nsecond row\n",
+		},
+	}
+	for _, tt := range tests {
+		if got := Render(sample(), tt.format); got != tt.want {
+			t.Errorf("Render(%s) = %q, want %q", tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestRenderASS(t *testing.T) {
+	got := Render(sample(), FormatASS)
+	for _, want := range []string{
+		"[Script Info]\nTitle: A & B\nScriptType: v4.00+\n",
+		"Dialogue: 0,0:00:01.50,0:00:04.25,Default,,0,0,0,,one\n",
+		`Dialogue: 0,1:01:02.01,1:01:05.00,Default,,0,0,0,,<two> & (three)\Nsecond row` + "\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("ASS output lacks %q:\n%s", want, got)
+		}
+	}
+
+	escaped := Render(&Lyrics{Lines: []Line{{End: time.Second, Text: `a\b`}}}, FormatASS)
+	if !strings.Contains(escaped, `,,a\\b`) {
+		t.Errorf("backslash not escaped:\n%s", escaped)
+	}
+}
+
+func TestRenderTTML(t *testing.T) {
+	got := Render(sample(), FormatTTML)
+	for _, want := range []string{
+		`<?xml version="1.0" encoding="UTF-8"?>`,
+		"<ttm:title>A &amp; B</ttm:title>",
+		`<p begin="00:00:01.500" end="00:00:04.250">one</p>`,
+		`<p begin="01:01:02.010" end="01:01:05.000">&lt;two&gt; &amp; {three}<br/>second row</p>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TTML output lacks %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestRenderEmpty(t *testing.T) {
+	for _, format := range []Format{FormatLRC, FormatSRT, FormatVTT, FormatASS, FormatTTML, FormatText} {
+		if got := Render(&Lyrics{}, format); got != "" {
+			t.Errorf("Render(empty, %s) = %q", format, got)
+		}
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for name, want := range map[string]Format{"WebVTT": FormatVTT, " ssa ": FormatASS, "dfxp": FormatTTML, "plain": FormatText, "lrc": FormatLRC} {
+		if got, err := ParseFormat(name); err != nil || got != want {
+			t.Errorf("ParseFormat(%q) = %q, %v; want %q", name, got, err, want)
+		}
+	}
+	if _, err := ParseFormat("docx"); err == nil {
+		t.Error("ParseFormat(docx) succeeded")
+	}
+}
@@ -0,0 +1,214 @@
+package lyrics
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Word is a single timed word (or syllable) of a karaoke line.
+type Word struct {
+	Start    time.Duration
+	Duration time.Duration
+	Text     string
+}
+
+// WordLine is a lyric line with per-word timing.
+type WordLine struct {
+	Start    time.Duration
+	Duration time.Duration
+	Words    []Word
+}
+
+// Text joins the words of the line.
+func (l WordLine) Text() string {
+	var b strings.Builder
+	for _, word := range l.Words {
+		b.WriteString(word.Text)
+	}
+	return b.String()
+}
+
+// Karaoke is a parsed word-timed lyric.
+type Karaoke struct {
+	Title  string
+	Artist string
+	Album  string
+	Lines  []WordLine
+}
+
+var (
+	wordLineTag = regexp.MustCompile(`^\[(\d+),(\d+)\]`)
+	// yrc word tags carry an absolute start: (start,duration,0).
+	yrcWordTag = regexp.MustCompile(`\((\d+),(\d+),-?\d+\)`)
+	// klyric word tags only carry a duration: (0,duration) or <0,duration,0>.
+	klyricWordTag = regexp.MustCompile(`\(\d+,(\d+)\)|<\d+,(\d+)(?:,-?\d+)?>`)
+)
+
+// ParseYRC parses NetEase's yrc format, where each line is
+// "[start,duration](start,duration,0)word(start,duration,0)word..." in
+// milliseconds. The JSON credit lines NetEase mixes in are skipped.
+func ParseYRC(text string) *Karaoke {
+	karaoke := &Karaoke{}
+	for _, raw := range strings.Split(text, "\n") {
+		line, body, ok := parseWordLine(raw)
+		if !ok {
+			continue
+		}
+		tags := yrcWordTag.FindAllStringSubmatchIndex(body, -1)
+		for i, tag := range tags {
+			end := len(body)
+			if i+1 < len(tags) {
+				end = tags[i+1][0]
+			}
+			line.Words = append(line.Words, Word{
+				Start:    millis(body[tag[2]:tag[3]]),
+				Duration: millis(body[tag[4]:tag[5]]),
+				Text:     body[tag[1]:end],
+			})
+		}
+		if len(line.Words) > 0 {
+			karaoke.Lines = append(karaoke.Lines, line)
+		}
+	}
+	return karaoke
+}
+
+// ParseKLyric parses the older klyric format, "[start,duration](0,d)word...",
+// whose words follow each other from the line start.
+func ParseKLyric(text string) *Karaoke {
+	karaoke := &Karaoke{}
+	for _, raw := range strings.Split(text, "\n") {
+		line, body, ok := parseWordLine(raw)
+		if !ok {
+			continue
+		}
+		tags := klyricWordTag.FindAllStringSubmatchIndex(body, -1)
+		var total time.Duration
+		for i, tag := range tags {
+			end := len(body)
+			if i+1 < len(tags) {
+				end = tags[i+1][0]
+			}
+			group := 2
+			if tag[2] < 0 {
+				group = 4
+			}
+			duration := millis(body[tag[group]:tag[group+1]])
+			line.Words = append(line.Words, Word{Duration: duration, Text: body[tag[1]:end]})
+			total += duration
+		}
+		if len(line.Words) == 0 {
+			continue
+		}
+		// Some klyric files count in centiseconds; their words then add up
+		// to about a tenth of the line.
+		scale := time.Duration(1)
+		if total > 0 && total*4 < line.Duration {
+			scale = 10
+		}
+		at := line.Start
+		for i := range line.Words {
+			line.Words[i].Duration *= scale
+			line.Words[i].Start = at
+			at += line.Words[i].Duration
+		}
+		karaoke.Lines = append(karaoke.Lines, line)
+	}
+	return karaoke
+}
+
+// parseWordLine reads the "[start,duration]" prefix shared by yrc and
+// klyric and returns the rest of the line.
+func parseWordLine(raw string) (WordLine, string, bool) {
+	raw = strings.TrimSpace(raw)
+	match := wordLineTag.FindStringSubmatch(raw)
+	if match == nil {
+		return WordLine{}, "", false
+	}
+	return WordLine{Start: millis(match[1]), Duration: millis(match[2])}, raw[len(match[0]):], true
+}
+
+func millis(s string) time.Duration {
+	ms, _ := strconv.ParseInt(s, 10, 64)
+	return time.Duration(ms) * time.Millisecond
+}
+
+// Lyrics drops the word timing, for formats that only have lines.
+func (k *Karaoke) Lyrics() *Lyrics {
+	out := &Lyrics{Title: k.Title, Artist: k.Artist, Album: k.Album, Lines: make([]Line, 0, len(k.Lines))}
+	for _, line := range k.Lines {
+		out.Lines = append(out.Lines, Line{Start: line.Start, End: line.Start + line.Duration, Text: strings.TrimSpace(line.Text())})
+	}
+	return out
+}
+
+// RenderKaraoke writes LRC as enhanced LRC with <mm:ss.xx> word tags and
+// ASS with \k karaoke tags; other formats are rendered line by line.
+func RenderKaraoke(k *Karaoke, f Format) string {
+	if k == nil || len(k.Lines) == 0 {
+		return ""
+	}
+	switch f {
+	case FormatLRC:
+		return renderEnhancedLRC(k)
+	case FormatASS:
+		return renderKaraokeASS(k)
+	}
+	return Render(k.Lyrics(), f)
+}
+
+func renderEnhancedLRC(k *Karaoke) string {
+	var b strings.Builder
+	for _, tag := range [][2]string{{"ti", k.Title}, {"ar", k.Artist}, {"al", k.Album}} {
+		if tag[1] != "" {
+			fmt.Fprintf(&b, "[%s:%s]\n", tag[0], tag[1])
+		}
+	}
+	for _, line := range k.Lines {
+		fmt.Fprintf(&b, "[%s]", lrcTime(line.Start))
+		var end time.Duration
+		for _, word := range line.Words {
+			fmt.Fprintf(&b, "<%s>%s", lrcTime(word.Start), word.Text)
+			end = word.Start + word.Duration
+		}
+		fmt.Fprintf(&b, "<%s>\n", lrcTime(end))
+	}
+	return b.String()
+}
+
+func renderKaraokeASS(k *Karaoke) string {
+	var b strings.Builder
+	writeASSHeader(&b, k.Title)
+	for _, line := range k.Lines {
+		end := line.Start + line.Duration
+		var text strings.Builder
+		// \k counts centiseconds from the start of the dialogue; rounding
+		// the running position instead of each duration avoids drift.
+		at := line.Start
+		for _, word := range line.Words {
+			if gap := centis(word.Start) - centis(at); gap > 0 {
+				fmt.Fprintf(&text, `{\k%d}`, gap)
+				at = word.Start
+			}
+			wordEnd := word.Start + word.Duration
+			if wordEnd < at {
+				wordEnd = at
+			}
+			fmt.Fprintf(&text, `{\k%d}%s`, centis(wordEnd)-centis(at), assEscaper.Replace(word.Text))
+			at = wordEnd
+		}
+		if at > end {
+			end = at
+		}
+		fmt.Fprintf(&b, "Dialogue: 0,%s,%s,Default,,0,0,0,,%s\n", assTime(line.Start), assTime(end), text.String())
+	}
+	return b.String()
+}
+
+func centis(d time.Duration) int64 {
+	return int64(math.Round(float64(d.Milliseconds()) / 10))
+}
@@ -0,0 +1,120 @@
+package lyrics
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const yrcFixture = `{"t":0,"c":[{"tx":"作词: "},{"tx":"someone"}]}
+[1000,2000](1000,500,0)Hel(1500,700,0)lo (2300,700,0)world
+not a lyric line
+[4000,1000](4000,1000,0){x}`
+
+func TestParseYRC(t *testing.T) {
+	k := ParseYRC(yrcFixture)
+	if len(k.Lines) != 2 {
+		t.Fatalf("lines = %+v, want 2 (credit lines skipped)", k.Lines)
+	}
+	line := k.Lines[0]
+	if line.Start != ms(1000) || line.Duration != ms(2000) {
+		t.Errorf("line timed %v+%v", line.Start, line.Duration)
+	}
+	want := []Word{
+		{Start: ms(1000), Duration: ms(500), Text: "Hel"},
+		{Start: ms(1500), Duration: ms(700), Text: "lo "},
+		{Start: ms(2300), Duration: ms(700), Text: "world"},
+	}
+	if !reflect.DeepEqual(line.Words, want) {
+		t.Errorf("words = %+v, want %+v", line.Words, want)
+	}
+	if got := line.Text(); got != "Hello world" {
+		t.Errorf("Text() = %q", got)
+	}
+}
+
+func TestParseKLyric(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []Word
+	}{
+		{
+			name: "milliseconds",
+			text: "[1000,1500](0,500)a(0,1000)b",
+			want: []Word{{Start: ms(1000), Duration: ms(500), Text: "a"}, {Start: ms(1500), Duration: ms(1000), Text: "b"}},
+		},
+		{
+			// The words add up to far less than the line: centiseconds.
+			name: "centiseconds",
+			text: "[2000,3000](0,100)c(0,150)d",
+			want: []Word{{Start: ms(2000), Duration: ms(1000), Text: "c"}, {Start: ms(3000), Duration: ms(1500), Text: "d"}},
+		},
+		{
+			// A quarter of the line is still read as milliseconds.
+			name: "short words",
+			text: "[0,4000](0,500)e(0,500)f",
+			want: []Word{{Start: 0, Duration: ms(500), Text: "e"}, {Start: ms(500), Duration: ms(500), Text: "f"}},
+		},
+		{
+			name: "angle brackets",
+			text: "[0,1000]<0,400,0>x<0,600>y",
+			want: []Word{{Start: 0, Duration: ms(400), Text: "x"}, {Start: ms(400), Duration: ms(600), Text: "y"}},
+		},
+	}
+	for _, tt := range tests {
+		k := ParseKLyric(tt.text)
+		if len(k.Lines) != 1 {
+			t.Errorf("%s: lines = %+v", tt.name, k.Lines)
+			continue
+		}
+		if !reflect.DeepEqual(k.Lines[0].Words, tt.want) {
+			t.Errorf("%s: words = %+v, want %+v", tt.name, k.Lines[0].Words, tt.want)
+		}
+	}
+}
+
+func TestRenderKaraoke(t *testing.T) {
+	k := ParseYRC(yrcFixture)
+	k.Title = "Song"
+
+	lrc := RenderKaraoke(k, FormatLRC)
+	wantLRC := "[ti:Song]\n" +
+		"[00:01.00]<00:01.00>Hel<00:01.50>lo <00:02.30>world<00:03.00>\n" +
+		"[00:04.00]<00:04.00>{x}<00:05.00>\n"
+	if lrc != wantLRC {
+		t.Errorf("enhanced LRC = %q, want %q", lrc, wantLRC)
+	}
+
+	ass := RenderKaraoke(k, FormatASS)
+	for _, want := range []string{
+		"Title: Song\n",
+		// The 100 ms gap before "world" is its own \k so the words stay in step.
+		`Dialogue: 0,0:00:01.00,0:00:03.00,Default,,0,0,0,,{\k50}Hel{\k70}lo {\k10}{\k70}world` + "\n",
+		`Dialogue: 0,0:00:04.00,0:00:05.00,Default,,0,0,0,,{\k100}(x)` + "\n",
+	} {
+		if !strings.Contains(ass, want) {
+			t.Errorf("karaoke ASS lacks %q:\n%s", want, ass)
+		}
+	}
+
+	if got, want := RenderKaraoke(k, FormatText), "Hello world\n{x}\n"; got != want {
+		t.Errorf("text = %q, want %q", got, want)
+	}
+	if got := RenderKaraoke(&Karaoke{}, FormatASS); got != "" {
+		t.Errorf("empty karaoke rendered %q", got)
+	}
+}
+
+func TestRenderKaraokeASSRounding(t *testing.T) {
+	// Three 333 ms words: rounding each one to 33 cs would drift by 1 cs.
+	k := &Karaoke{Lines: []WordLine{{Duration: ms(999), Words: []Word{
+		{Start: 0, Duration: ms(333), Text: "a"},
+		{Start: ms(333), Duration: ms(333), Text: "b"},
+		{Start: ms(666), Duration: ms(333), Text: "c"},
+	}}}}
+	got := RenderKaraoke(k, FormatASS)
+	if want := `,,{\k33}a{\k34}b{\k33}c` + "\n"; !strings.Contains(got, want) {
+		t.Errorf("karaoke ASS lacks %q:\n%s", want, got)
+	}
+}
@@ -16,6 +16,12 @@ import (
 	appcrypto "wyapi-golang/internal/crypto"
 )
 
+const (
+	codeTooFrequent = 405
+	codeCheating    = -460
+	codeNeedVerify  = -462
+)
+
 const (
 	userAgent = "Mozilla/5.0 (Windows NT 10.0; WOW64) AppleWebKit/537.36 (KHTML, like Gecko) Safari/537.36 Chrome/91.0.4472.164 NeteaseMusicDesktop/2.10.2.200154"
 	referer   = "https://music.163.com/"
@@ -26,33 +32,121 @@ const (
 	searchAPI         = "https://music.163.com/api/cloudsearch/pc"
 	playlistDetailAPI = "https://music.163.com/api/v6/playlist/detail"
 	albumDetailAPI    = "https://music.163.com/api/v1/album/"
+	accountAPI        = "https://music.163.com/api/nuser/account/get"
 )
 
-var defaultCookies = map[string]string{
-	"os":       "pc",
-	"appver":   "",
-	"osver":    "",
-	"deviceId": "pyncm!",
+// Limiter paces outgoing API requests; Wait blocks until one may be sent.
+type Limiter interface {
+	Wait(ctx context.Context, key string) error
+}
+
+// RetryPolicy controls how often failed API calls are retried.
+// The delay before retry n is a random value in [d/2, d] where
+// d = min(BaseDelay*2^n, MaxDelay).
+type RetryPolicy struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay << uint(attempt)
+	if delay <= 0 || delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
 }
 
 type Client struct {
-	httpClient *http.Client
-	rng        *rand.Rand
+	httpClient     *http.Client
+	cdnClient      *http.Client
+	cdnIdle        time.Duration
+	realIP         string
+	profiles       map[string]Profile
+	defaultProfile string
+	rng            *rand.Rand
+	setCookieFn    func(context.Context, []*http.Cookie)
+	limiter        Limiter
+	retry          RetryPolicy
+	breaker        *Breaker
 }
 
 func NewClient(timeout time.Duration) *Client {
+	client, _ := NewClientWithOptions(ClientOptions{Timeout: timeout})
+	return client
+}
+
+// NewClientWithOptions builds a client with separate transports for API
+// calls and CDN downloads. It fails only on invalid proxy or TLS settings.
+func NewClientWithOptions(opts ClientOptions) (*Client, error) {
+	timeout := opts.Timeout
 	if timeout <= 0 {
 		timeout = 30 * time.Second
 	}
 
+	apiTransport, err := newTransport(opts.APIProxy, opts.Transport, 0)
+	if err != nil {
+		return nil, err
+	}
+	// Downloads can legitimately take longer than timeout, so the CDN client
+	// has no overall deadline: it bounds the wait for response headers, and
+	// doCDN bounds each wait for body data instead.
+	cdnTransport, err := newTransport(opts.DownloadProxy, opts.Transport, timeout)
+	if err != nil {
+		return nil, err
+	}
+	readIdle := opts.Transport.ReadIdleTimeout
+	if readIdle <= 0 {
+		readIdle = 30 * time.Second
+	}
+
 	return &Client{
-		httpClient: &http.Client{Timeout: timeout},
+		httpClient: &http.Client{Timeout: timeout, Transport: apiTransport},
+		cdnClient:  &http.Client{Transport: cdnTransport},
+		cdnIdle:    readIdle,
+		realIP:     strings.TrimSpace(opts.RealIP),
 		rng:        rand.New(rand.NewSource(time.Now().UnixNano())),
+		retry:      RetryPolicy{MaxRetries: 0, BaseDelay: 300 * time.Millisecond, MaxDelay: 5 * time.Second},
+	}, nil
+}
+
+// OnSetCookie registers fn to receive Set-Cookie headers returned by NetEase
+// API calls, so rotated session cookies can be persisted. ctx is the context
+// of the originating request.
+func (c *Client) OnSetCookie(fn func(context.Context, []*http.Cookie)) {
+	c.setCookieFn = fn
+}
+
+// SetLimiter installs a global budget shared by every NetEase API request
+// made through this client.
+func (c *Client) SetLimiter(limiter Limiter) {
+	c.limiter = limiter
+}
+
+func (c *Client) SetRetryPolicy(policy RetryPolicy) {
+	if policy.BaseDelay <= 0 {
+		policy.BaseDelay = 300 * time.Millisecond
+	}
+	if policy.MaxDelay < policy.BaseDelay {
+		policy.MaxDelay = policy.BaseDelay
 	}
+	c.retry = policy
+}
+
+// SetBreaker installs a circuit breaker shared by all API calls. A nil
+// breaker disables fast-failing.
+func (c *Client) SetBreaker(breaker *Breaker) {
+	c.breaker = breaker
+}
+
+// Health reports the circuit breaker state for the /health endpoint.
+func (c *Client) Health() BreakerStatus {
+	return c.breaker.Status()
 }
 
 func (c *Client) GetSongURL(ctx context.Context, songID int64, quality string, cookies map[string]string) (*SongURLResponse, error) {
-	headerJSON, err := c.buildHeaderJSON()
+	headerJSON, err := c.buildHeaderJSON(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -84,8 +178,8 @@ func (c *Client) GetSongURL(ctx context.Context, songID int64, quality string, c
 	if err := json.Unmarshal(body, &resp); err != nil {
 		return nil, err
 	}
-	if resp.Code != 200 {
-		return nil, errors.New("netease: song url request failed")
+	if resp.Code != codeOK {
+		return nil, codeError("song url", resp.Code, body)
 	}
 	return &resp, nil
 }
@@ -108,23 +202,37 @@ func (c *Client) GetSongDetail(ctx context.Context, songID int64) (*SongDetailRe
 	if err := json.Unmarshal(body, &resp); err != nil {
 		return nil, err
 	}
-	if resp.Code != 200 {
-		return nil, errors.New("netease: song detail request failed")
+	if resp.Code != codeOK {
+		return nil, codeError("song detail", resp.Code, body)
 	}
 	return &resp, nil
 }
 
 func (c *Client) GetLyrics(ctx context.Context, songID int64, cookies map[string]string) (*LyricResponse, error) {
+	return c.getLyrics(ctx, songID, false, cookies)
+}
+
+// GetWordLyrics also requests the word-by-word yrc and klyric versions,
+// which are only sent when asked for.
+func (c *Client) GetWordLyrics(ctx context.Context, songID int64, cookies map[string]string) (*LyricResponse, error) {
+	return c.getLyrics(ctx, songID, true, cookies)
+}
+
+func (c *Client) getLyrics(ctx context.Context, songID int64, words bool, cookies map[string]string) (*LyricResponse, error) {
+	wordVersion := "0"
+	if words {
+		wordVersion = "-1"
+	}
 	data := url.Values{}
 	data.Set("id", strconv.FormatInt(songID, 10))
 	data.Set("cp", "false")
 	data.Set("tv", "0")
 	data.Set("lv", "0")
 	data.Set("rv", "0")
-	data.Set("kv", "0")
-	data.Set("yv", "0")
-	data.Set("ytv", "0")
-	data.Set("yrv", "0")
+	data.Set("kv", wordVersion)
+	data.Set("yv", wordVersion)
+	data.Set("ytv", wordVersion)
+	data.Set("yrv", wordVersion)
 
 	body, err := c.postForm(ctx, lyricAPI, data, cookies)
 	if err != nil {
@@ -135,8 +243,8 @@ func (c *Client) GetLyrics(ctx context.Context, songID int64, cookies map[string
 	if err := json.Unmarshal(body, &resp); err != nil {
 		return nil, err
 	}
-	if resp.Code != 200 {
-		return nil, errors.New("netease: lyric request failed")
+	if resp.Code != codeOK {
+		return nil, codeError("lyric", resp.Code, body)
 	}
 	return &resp, nil
 }
@@ -163,8 +271,8 @@ func (c *Client) Search(ctx context.Context, keywords string, limit int, cookies
 	if err := json.Unmarshal(body, &resp); err != nil {
 		return nil, err
 	}
-	if resp.Code != 200 {
-		return nil, errors.New("netease: search request failed")
+	if resp.Code != codeOK {
+		return nil, codeError("search", resp.Code, body)
 	}
 	return &resp, nil
 }
@@ -182,8 +290,8 @@ func (c *Client) GetPlaylistDetail(ctx context.Context, playlistID int64, cookie
 	if err := json.Unmarshal(body, &resp); err != nil {
 		return nil, err
 	}
-	if resp.Code != 200 {
-		return nil, errors.New("netease: playlist request failed")
+	if resp.Code != codeOK {
+		return nil, codeError("playlist detail", resp.Code, body)
 	}
 
 	playlist := resp.Playlist
@@ -238,8 +346,8 @@ func (c *Client) GetAlbumDetail(ctx context.Context, albumID int64, cookies map[
 	if err := json.Unmarshal(body, &resp); err != nil {
 		return nil, err
 	}
-	if resp.Code != 200 {
-		return nil, errors.New("netease: album request failed")
+	if resp.Code != codeOK {
+		return nil, codeError("album detail", resp.Code, body)
 	}
 
 	album := resp.Album
@@ -262,6 +370,34 @@ func (c *Client) GetAlbumDetail(ctx context.Context, albumID int64, cookies map[
 	return info, nil
 }
 
+// GetAccount reports which NetEase account the cookies belong to;
+// LoggedIn is false when the cookies carry no valid session.
+func (c *Client) GetAccount(ctx context.Context, cookies map[string]string) (*AccountInfo, error) {
+	body, err := c.postForm(ctx, accountAPI, url.Values{}, cookies)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp AccountResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Code != codeOK {
+		return nil, codeError("account", resp.Code, body)
+	}
+
+	info := &AccountInfo{}
+	if resp.Account != nil {
+		info.VIPType = resp.Account.VipType
+	}
+	if resp.Profile != nil {
+		info.LoggedIn = true
+		info.UserID = resp.Profile.UserID
+		info.Nickname = resp.Profile.Nickname
+	}
+	return info, nil
+}
+
 func (c *Client) GetPicURL(picID int64, size int) string {
 	if picID == 0 {
 		return ""
@@ -285,12 +421,33 @@ func (c *Client) GetPicURL(picID int64, size int) string {
 	return "https://p3.music.126.net/" + encoded + "/" + strconv.FormatInt(picID, 10) + ".jpg?param=" + strconv.Itoa(size) + "y" + strconv.Itoa(size)
 }
 
-func (c *Client) buildHeaderJSON() (string, error) {
+// SizedPicURL asks the NetEase image CDN for a size x size version of a
+// cover (size 0 keeps the original) and, with format "webp", for WebP
+// instead of the stored JPEG.
+func SizedPicURL(picURL string, size int, format string) string {
+	if i := strings.IndexByte(picURL, '?'); i >= 0 {
+		picURL = picURL[:i]
+	}
+	query := url.Values{}
+	if size > 0 {
+		query.Set("param", strconv.Itoa(size)+"y"+strconv.Itoa(size))
+	}
+	if format == "webp" {
+		query.Set("type", "webp")
+	}
+	if len(query) == 0 {
+		return picURL
+	}
+	return picURL + "?" + query.Encode()
+}
+
+func (c *Client) buildHeaderJSON(ctx context.Context) (string, error) {
+	profile := c.profileFor(ctx)
 	header := DeviceHeader{
-		OS:        "pc",
-		AppVer:    "",
-		OSVer:     "",
-		DeviceID:  "pyncm!",
+		OS:        profile.OS,
+		AppVer:    profile.AppVer,
+		OSVer:     profile.OSVer,
+		DeviceID:  profile.DeviceID,
 		RequestID: c.randomRequestID(),
 	}
 
@@ -316,53 +473,121 @@ func (c *Client) buildEAPIParams(rawURL string, payload interface{}) (string, er
 	return appcrypto.EncryptEAPIParams(rawURL, payloadJSON)
 }
 
+// postForm sends a form-encoded API request. Every NetEase endpoint used by
+// this client is a read-only query, so POSTs are retried like GETs.
 func (c *Client) postForm(ctx context.Context, target string, data url.Values, cookies map[string]string) ([]byte, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, strings.NewReader(data.Encode()))
-	if err != nil {
-		return nil, err
-	}
+	encoded := data.Encode()
+	return c.doAPI(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, strings.NewReader(encoded))
+		if err != nil {
+			return nil, err
+		}
 
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Set("User-Agent", userAgent)
-	req.Header.Set("Referer", referer)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		c.setAPIHeaders(req)
 
-	applyCookies(req, cookies)
+		applyCookies(req, c.profileFor(ctx), cookies)
+		return req, nil
+	})
+}
 
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
+func (c *Client) get(ctx context.Context, target string, cookies map[string]string) ([]byte, error) {
+	return c.doAPI(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+		if err != nil {
+			return nil, err
+		}
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, errors.New("netease: http status " + resp.Status)
-	}
+		c.setAPIHeaders(req)
 
-	return io.ReadAll(resp.Body)
+		applyCookies(req, c.profileFor(ctx), cookies)
+		return req, nil
+	})
 }
 
-func (c *Client) get(ctx context.Context, target string, cookies map[string]string) ([]byte, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
-	if err != nil {
-		return nil, err
-	}
+// doAPI performs an API request through the breaker and upstream budget,
+// retrying retryable failures with jittered exponential backoff; every
+// call it makes is a read-only query, so retrying is always safe. The
+// retries belong to one logical request, so the breaker sees a single
+// outcome, that of the last attempt; cancelled requests and ones that
+// never reached NetEase report none.
+func (c *Client) doAPI(ctx context.Context, build func() (*http.Request, error)) ([]byte, error) {
+	if !c.breaker.Allow() {
+		return nil, ErrUpstreamDegraded
+	}
+	outcome := c.breaker.Release
+	defer func() { outcome() }()
+
+	for attempt := 0; ; attempt++ {
+		outcome = c.breaker.Release
+		if err := c.wait(ctx); err != nil {
+			return nil, err
+		}
 
-	req.Header.Set("User-Agent", userAgent)
-	req.Header.Set("Referer", referer)
+		req, err := build()
+		if err != nil {
+			return nil, err
+		}
 
-	applyCookies(req, cookies)
+		body, retryable, err := c.roundTrip(ctx, req)
+		switch {
+		case err == nil:
+			outcome = c.breaker.Success
+			return body, nil
+		case ctx.Err() != nil:
+			return nil, ctx.Err()
+		case retryable:
+			outcome = func() { c.breaker.Failure(err) }
+		default:
+			// A definitive answer such as a 404 still proves NetEase is reachable.
+			outcome = c.breaker.Success
+		}
+		if !retryable || attempt >= c.retry.MaxRetries {
+			return nil, err
+		}
+
+		delay := c.retry.backoff(attempt)
+		if errors.Is(err, ErrRateLimited) {
+			delay = c.retry.MaxDelay
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			outcome = c.breaker.Release
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
 
+// roundTrip executes req and reports whether a failure is worth retrying:
+// network errors, 5xx/429 responses and NetEase's throttling codes are.
+func (c *Client) roundTrip(ctx context.Context, req *http.Request) ([]byte, bool, error) {
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, true, transportError("request", err)
 	}
 	defer resp.Body.Close()
+	c.notifySetCookie(ctx, resp)
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, errors.New("netease: http status " + resp.Status)
+		retryable := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return nil, retryable, statusError("http status", resp)
 	}
 
-	return io.ReadAll(resp.Body)
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, true, err
+	}
+
+	switch code := peekCode(body); code {
+	case codeCheating, codeTooFrequent:
+		return nil, true, codeError("", code, body)
+	case codeNeedVerify:
+		return nil, false, codeError("", code, body)
+	}
+	return body, false, nil
 }
 
 func (c *Client) getSongDetailBatch(ctx context.Context, ids []int64, cookies map[string]string) ([]SongDetailSong, error) {
@@ -388,15 +613,41 @@ func (c *Client) getSongDetailBatch(ctx context.Context, ids []int64, cookies ma
 	if err := json.Unmarshal(body, &resp); err != nil {
 		return nil, err
 	}
-	if resp.Code != 200 {
-		return nil, errors.New("netease: song detail batch failed")
+	if resp.Code != codeOK {
+		return nil, codeError("song detail", resp.Code, body)
 	}
 	return resp.Songs, nil
 }
 
-func applyCookies(req *http.Request, cookies map[string]string) {
-	merged := make(map[string]string, len(defaultCookies)+len(cookies))
-	for k, v := range defaultCookies {
+func (c *Client) setAPIHeaders(req *http.Request) {
+	req.Header.Set("User-Agent", c.profileFor(req.Context()).UserAgent)
+	req.Header.Set("Referer", referer)
+	if c.realIP != "" {
+		req.Header.Set("X-Real-IP", c.realIP)
+		req.Header.Set("X-Forwarded-For", c.realIP)
+	}
+}
+
+func (c *Client) wait(ctx context.Context) error {
+	if c.limiter == nil {
+		return nil
+	}
+	return c.limiter.Wait(ctx, "")
+}
+
+func (c *Client) notifySetCookie(ctx context.Context, resp *http.Response) {
+	if c.setCookieFn == nil || resp == nil {
+		return
+	}
+	if cookies := resp.Cookies(); len(cookies) > 0 {
+		c.setCookieFn(ctx, cookies)
+	}
+}
+
+func applyCookies(req *http.Request, profile Profile, cookies map[string]string) {
+	defaults := profile.cookies()
+	merged := make(map[string]string, len(defaults)+len(cookies))
+	for k, v := range defaults {
 		merged[k] = v
 	}
 	for k, v := range cookies {
@@ -418,6 +669,35 @@ func applyCookies(req *http.Request, cookies map[string]string) {
 	req.Header.Set("Cookie", strings.Join(pairs, "; "))
 }
 
+func statusError(op string, resp *http.Response) error {
+	kind := KindUpstream
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		kind = KindNotFound
+	case http.StatusTooManyRequests:
+		kind = KindRateLimited
+	case http.StatusGatewayTimeout:
+		kind = KindTimeout
+	}
+	return &Error{Kind: kind, Op: op, Code: resp.StatusCode, Message: resp.Status}
+}
+
+// peekCode extracts the top-level "code" of a JSON API response, or 0 when the
+// body is not a JSON object or has no code.
+func peekCode(body []byte) int {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return 0
+	}
+	var envelope struct {
+		Code int `json:"code"`
+	}
+	if err := json.Unmarshal(trimmed, &envelope); err != nil {
+		return 0
+	}
+	return envelope.Code
+}
+
 func marshalNoEscape(value interface{}) ([]byte, error) {
 	var buf bytes.Buffer
 	encoder := json.NewEncoder(&buf)
@@ -450,49 +730,81 @@ func trackInfoFromSong(song SongDetailSong) TrackInfo {
 
 // Helper methods used by downloader
 func (c *Client) FetchSongURLDirect(ctx context.Context, url string) ([]byte, http.Header, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	resp, err := c.doCDN(ctx, url, "")
 	if err != nil {
 		return nil, nil, err
 	}
-	req.Header.Set("User-Agent", userAgent)
-	req.Header.Set("Referer", referer)
+	defer resp.Body.Close()
 
-	resp, err := c.httpClient.Do(req)
+	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, nil, err
 	}
-	defer resp.Body.Close()
+	return body, resp.Header, nil
+}
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, nil, errors.New("netease: download status " + resp.Status)
+func (c *Client) FetchSongStream(ctx context.Context, url string) (io.ReadCloser, http.Header, error) {
+	resp, err := c.doCDN(ctx, url, "")
+	if err != nil {
+		return nil, nil, err
 	}
+	return resp.Body, resp.Header, nil
+}
 
-	body, err := io.ReadAll(resp.Body)
+// FetchImage requests an image from the NetEase image CDN and returns its
+// body and Content-Type. A response that is not an image, such as an error
+// page served with status 200, is an upstream error. The caller must close
+// the body.
+func (c *Client) FetchImage(ctx context.Context, url string) (io.ReadCloser, string, error) {
+	resp, err := c.doCDN(ctx, url, "")
 	if err != nil {
-		return nil, nil, err
+		return nil, "", err
 	}
-	return body, resp.Header, nil
+	contentType := resp.Header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, "image/") {
+		resp.Body.Close()
+		return nil, "", &Error{Kind: KindUpstream, Op: "image", Message: "unexpected content type " + strconv.Quote(contentType)}
+	}
+	return resp.Body, contentType, nil
 }
 
-func (c *Client) FetchSongStream(ctx context.Context, url string) (io.ReadCloser, http.Header, error) {
+// OpenSongStream requests a CDN audio URL, forwarding rangeHeader when set,
+// and returns the response for the caller to relay. Both 200 and 206 count
+// as success; the caller must close the body.
+func (c *Client) OpenSongStream(ctx context.Context, url string, rangeHeader string) (*http.Response, error) {
+	return c.doCDN(ctx, url, rangeHeader)
+}
+
+// doCDN fetches a CDN URL. A successful response's body fails with a
+// timeout error when a read waits longer than the read idle timeout for
+// data; the caller must close it.
+func (c *Client) doCDN(ctx context.Context, url string, rangeHeader string) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(ctx)
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return nil, nil, err
+		cancel()
+		return nil, err
 	}
-	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("User-Agent", c.profileFor(ctx).UserAgent)
 	req.Header.Set("Referer", referer)
+	if rangeHeader != "" {
+		req.Header.Set("Range", rangeHeader)
+	}
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.cdnClient.Do(req)
 	if err != nil {
-		return nil, nil, err
+		cancel()
+		return nil, transportError("download", err)
 	}
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		resp.Body.Close()
-		return nil, nil, errors.New("netease: download status " + resp.Status)
+		cancel()
+		return nil, statusError("download status", resp)
 	}
 
-	return resp.Body, resp.Header, nil
+	resp.Body = newIdleTimeoutBody(resp.Body, c.cdnIdle, cancel)
+	return resp, nil
 }
 
 func (c *Client) ResolveShortURL(ctx context.Context, shortURL string) (string, error) {
@@ -505,7 +817,7 @@ func (c *Client) ResolveShortURL(ctx context.Context, shortURL string) (string,
 		return "", err
 	}
 
-	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("User-Agent", c.profileFor(ctx).UserAgent)
 	req.Header.Set("Referer", referer)
 
 	resp, err := c.httpClient.Do(req)
@@ -529,7 +841,7 @@ func (c *Client) ResolveShortURLHead(ctx context.Context, shortURL string) (stri
 	if err != nil {
 		return "", err
 	}
-	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("User-Agent", c.profileFor(ctx).UserAgent)
 	req.Header.Set("Referer", referer)
 
 	resp, err := c.httpClient.Do(req)
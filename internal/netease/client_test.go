@@ -0,0 +1,107 @@
+package netease
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBreakerCountsRetriedRequestOnce(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	c := NewClient(time.Second)
+	c.SetRetryPolicy(RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+	breaker := NewBreaker(3, time.Minute)
+	c.SetBreaker(breaker)
+
+	if _, err := c.get(context.Background(), server.URL, nil); err == nil {
+		t.Fatal("get() succeeded against a failing upstream")
+	}
+	if got := hits.Load(); got != 3 {
+		t.Errorf("upstream hit %d times, want 3", got)
+	}
+	if status := breaker.Status(); status.ConsecutiveFailures != 1 || status.State != BreakerClosed {
+		t.Errorf("breaker = %+v after one request, want 1 failure and closed", status)
+	}
+
+	for i := 0; i < 2; i++ {
+		_, _ = c.get(context.Background(), server.URL, nil)
+	}
+	if status := breaker.Status(); status.State != BreakerOpen {
+		t.Errorf("breaker = %+v after three requests, want open", status)
+	}
+	if _, err := c.get(context.Background(), server.URL, nil); err != ErrUpstreamDegraded {
+		t.Errorf("get() with open breaker = %v, want ErrUpstreamDegraded", err)
+	}
+}
+
+func TestCDNReadIdleTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	c, err := NewClientWithOptions(ClientOptions{Timeout: time.Second, Transport: TransportOptions{ReadIdleTimeout: 50 * time.Millisecond}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, _, err := c.FetchSongStream(context.Background(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	// Waiting between reads is up to the caller and does not count.
+	time.Sleep(100 * time.Millisecond)
+	data, err := io.ReadAll(stream)
+	if string(data) != "partial" {
+		t.Errorf("read %q before the stall", data)
+	}
+	if KindOf(err) != KindTimeout {
+		t.Errorf("stalled read error = %v, want a timeout", err)
+	}
+}
+
+func TestFetchImage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/error-page" {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte("<html>not found</html>"))
+			return
+		}
+		w.Header().Set("Content-Type", "image/webp")
+		w.Write([]byte("RIFF"))
+	}))
+	defer server.Close()
+
+	c := NewClient(time.Second)
+	body, contentType, err := c.FetchImage(context.Background(), server.URL+"/cover.jpg?type=webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(body)
+	body.Close()
+	if contentType != "image/webp" || string(data) != "RIFF" {
+		t.Errorf("FetchImage() = %q, %q", data, contentType)
+	}
+
+	if _, _, err := c.FetchImage(context.Background(), server.URL+"/error-page"); KindOf(err) != KindUpstream {
+		t.Errorf("FetchImage(html page) = %v, want an upstream error", err)
+	}
+}
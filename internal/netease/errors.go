@@ -0,0 +1,157 @@
+package netease
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"strconv"
+)
+
+type ErrorKind string
+
+const (
+	KindNotFound      ErrorKind = "not_found"
+	KindVIPRequired   ErrorKind = "vip_required"
+	KindRegionBlocked ErrorKind = "region_blocked"
+	KindCookieExpired ErrorKind = "cookie_expired"
+	KindTimeout       ErrorKind = "network_timeout"
+	KindRateLimited   ErrorKind = "rate_limited"
+	KindDegraded      ErrorKind = "upstream_degraded"
+	KindUpstream      ErrorKind = "upstream_error"
+)
+
+const (
+	codeOK            = 200
+	codeNeedLogin     = 301
+	codeNotFound      = 404
+	codeRegionBlocked = -110
+)
+
+// Error is a classified failure from NetEase. Code and Message carry the
+// upstream code and message when NetEase supplied them.
+type Error struct {
+	Kind    ErrorKind
+	Op      string
+	Code    int
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	text := "netease"
+	if e.Op != "" {
+		text += ": " + e.Op
+	}
+	if e.Message != "" {
+		text += ": " + e.Message
+	} else if e.Err != nil {
+		text += ": " + e.Err.Error()
+	} else {
+		text += ": " + string(e.Kind)
+	}
+	if e.Code != 0 {
+		text += " (code " + strconv.Itoa(e.Code) + ")"
+	}
+	return text
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is matches another *Error of the same kind, so the Err* sentinels below
+// work with errors.Is regardless of op, code or message.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Kind == e.Kind && (t.Code == 0 || t.Code == e.Code)
+}
+
+var (
+	ErrNotFound         = &Error{Kind: KindNotFound}
+	ErrVIPRequired      = &Error{Kind: KindVIPRequired}
+	ErrRegionBlocked    = &Error{Kind: KindRegionBlocked}
+	ErrCookieExpired    = &Error{Kind: KindCookieExpired}
+	ErrTimeout          = &Error{Kind: KindTimeout}
+	ErrRateLimited      = &Error{Kind: KindRateLimited, Message: "rate limited by upstream"}
+	ErrUpstreamDegraded = &Error{Kind: KindDegraded, Message: "upstream degraded, circuit breaker open"}
+)
+
+// KindOf classifies err. Network timeouts are recognised even when they were
+// not wrapped in an *Error; other unclassified errors yield "".
+func KindOf(err error) ErrorKind {
+	if err == nil {
+		return ""
+	}
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Kind
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return KindTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return KindTimeout
+	}
+	return ""
+}
+
+// transportError wraps a failure of http.Client.Do so it classifies as an
+// upstream problem rather than an internal one.
+func transportError(op string, err error) error {
+	kind := KindOf(err)
+	if kind == "" {
+		kind = KindUpstream
+	}
+	return &Error{Kind: kind, Op: op, Err: err}
+}
+
+// codeError maps a non-200 NetEase API code to an *Error, taking the message
+// from the response body when present.
+func codeError(op string, code int, body []byte) error {
+	kind := KindUpstream
+	switch code {
+	case codeNeedLogin:
+		kind = KindCookieExpired
+	case codeNotFound:
+		kind = KindNotFound
+	case codeRegionBlocked:
+		kind = KindRegionBlocked
+	case codeTooFrequent, codeCheating, codeNeedVerify:
+		kind = KindRateLimited
+	}
+	return &Error{Kind: kind, Op: op, Code: code, Message: bodyMessage(body)}
+}
+
+func bodyMessage(body []byte) string {
+	var envelope struct {
+		Message string `json:"message"`
+		Msg     string `json:"msg"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return ""
+	}
+	if envelope.Message != "" {
+		return envelope.Message
+	}
+	return envelope.Msg
+}
+
+// Err explains why an entry from GetSongURL carries no playable URL, or
+// returns nil when it does.
+func (d SongURLData) Err() error {
+	if d.URL != "" {
+		return nil
+	}
+	switch {
+	case d.Code == codeNotFound:
+		return &Error{Kind: KindNotFound, Op: "song url", Code: d.Code, Message: "song not found or unavailable"}
+	case d.Code == codeRegionBlocked:
+		return &Error{Kind: KindRegionBlocked, Op: "song url", Code: d.Code, Message: "song is not available in this region"}
+	case d.Fee == 1 || d.Fee == 4:
+		return &Error{Kind: KindVIPRequired, Op: "song url", Code: d.Code, Message: "VIP or purchase required"}
+	default:
+		return &Error{Kind: KindNotFound, Op: "song url", Code: d.Code, Message: "music url not available"}
+	}
+}
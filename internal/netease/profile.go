@@ -0,0 +1,190 @@
+package netease
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const DefaultProfile = "pc"
+
+// Profile is a client identity NetEase sees: user agent, the os/appver/osver
+// cookies and eapi header fields, and a device ID.
+type Profile struct {
+	Name      string `json:"name"`
+	UserAgent string `json:"user_agent"`
+	OS        string `json:"os"`
+	AppVer    string `json:"appver"`
+	OSVer     string `json:"osver"`
+	DeviceID  string `json:"device_id"`
+}
+
+var builtinProfiles = map[string]Profile{
+	"pc": {
+		UserAgent: userAgent,
+		OS:        "pc",
+	},
+	"android": {
+		UserAgent: "NeteaseMusic/9.1.65.240927161425(9001065);Dalvik/2.1.0 (Linux; U; Android 14; 23013RK75C Build/UKQ1.230804.001)",
+		OS:        "android",
+		AppVer:    "9.1.65",
+		OSVer:     "14",
+	},
+	"iphone": {
+		UserAgent: "NeteaseMusic 9.0.90/5038 (iPhone; iOS 16.2; zh_CN)",
+		OS:        "iPhone OS",
+		AppVer:    "9.0.90",
+		OSVer:     "16.2",
+	},
+	"linux": {
+		UserAgent: "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/60.0.3112.90 Safari/537.36",
+		OS:        "linux",
+		AppVer:    "1.2.1.0428",
+		OSVer:     "Deepin 20.9",
+	},
+}
+
+// legacyProfile is the identity of a Client that has no profiles
+// installed through SetProfiles. Its device ID is the fixed one every
+// request carried before profiles existed, which LoadProfiles also keeps
+// for the pc profile.
+var legacyProfile = Profile{
+	Name:      DefaultProfile,
+	UserAgent: userAgent,
+	OS:        "pc",
+	DeviceID:  "pyncm!",
+}
+
+// ProfileNames lists the built-in emulation profiles.
+func ProfileNames() []string {
+	names := make([]string, 0, len(builtinProfiles))
+	for name := range builtinProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadProfiles returns every built-in profile with a device ID that is
+// persisted to path, so a deployment keeps a stable identity across
+// restarts. The pc profile starts from the legacy device ID, so upgrading
+// does not change the identity existing sessions were made with; the
+// others get a random one.
+func LoadProfiles(path string) (map[string]Profile, error) {
+	if path == "" {
+		return nil, errors.New("identity file path empty")
+	}
+
+	deviceIDs := map[string]string{}
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if err := json.Unmarshal(data, &deviceIDs); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	changed := false
+	profiles := make(map[string]Profile, len(builtinProfiles))
+	for name, profile := range builtinProfiles {
+		deviceID := deviceIDs[name]
+		if deviceID == "" {
+			if name == DefaultProfile {
+				deviceID = legacyProfile.DeviceID
+			} else if deviceID, err = generateDeviceID(); err != nil {
+				return nil, err
+			}
+			deviceIDs[name] = deviceID
+			changed = true
+		}
+		profile.Name = name
+		profile.DeviceID = deviceID
+		profiles[name] = profile
+	}
+
+	if changed {
+		data, err := json.MarshalIndent(deviceIDs, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		if dir := filepath.Dir(path); dir != "." {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return nil, err
+			}
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			return nil, err
+		}
+	}
+	return profiles, nil
+}
+
+func generateDeviceID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return strings.ToUpper(hex.EncodeToString(buf)), nil
+}
+
+// SetProfiles installs the available profiles and the one used when a request
+// does not select another.
+func (c *Client) SetProfiles(profiles map[string]Profile, defaultName string) error {
+	if _, ok := profiles[defaultName]; !ok {
+		return errors.New("unknown profile: " + defaultName)
+	}
+	c.profiles = profiles
+	c.defaultProfile = defaultName
+	return nil
+}
+
+func (c *Client) HasProfile(name string) bool {
+	if c.profiles == nil {
+		return name == DefaultProfile
+	}
+	_, ok := c.profiles[name]
+	return ok
+}
+
+func (c *Client) profileFor(ctx context.Context) Profile {
+	if c.profiles == nil {
+		return legacyProfile
+	}
+	if name := ProfileFromContext(ctx); name != "" {
+		if profile, ok := c.profiles[name]; ok {
+			return profile
+		}
+	}
+	return c.profiles[c.defaultProfile]
+}
+
+func (p Profile) cookies() map[string]string {
+	return map[string]string{
+		"os":       p.OS,
+		"appver":   p.AppVer,
+		"osver":    p.OSVer,
+		"deviceId": p.DeviceID,
+	}
+}
+
+type profileKey struct{}
+
+// WithProfile selects the emulation profile for requests made with ctx.
+func WithProfile(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, profileKey{}, name)
+}
+
+func ProfileFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	name, _ := ctx.Value(profileKey{}).(string)
+	return name
+}
@@ -0,0 +1,115 @@
+package netease
+
+import (
+	"context"
+	"sync"
+)
+
+// Levels lists the quality levels accepted by GetSongURL, lowest first.
+var Levels = []string{"standard", "exhigh", "lossless", "hires", "sky", "jyeffect", "jymaster", "dolby"}
+
+func IsValidLevel(level string) bool {
+	for _, item := range Levels {
+		if item == level {
+			return true
+		}
+	}
+	return false
+}
+
+// QualityAvailability is the result of probing one level for a song.
+type QualityAvailability struct {
+	Level       string `json:"level"`
+	Available   bool   `json:"available"`
+	ActualLevel string `json:"actual_level,omitempty"`
+	Br          int64  `json:"br,omitempty"`
+	Size        int64  `json:"size,omitempty"`
+	Type        string `json:"type,omitempty"`
+	ErrorCode   string `json:"error_code,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// GetSongURLWithFallback tries levels in order and returns the first entry
+// NetEase serves at exactly the requested level. NetEase silently downgrades
+// unavailable levels, so a downgraded URL is only returned when no level in
+// the chain is served as asked.
+func (c *Client) GetSongURLWithFallback(ctx context.Context, songID int64, levels []string, cookies map[string]string) (*SongURLData, error) {
+	var downgraded *SongURLData
+	var lastErr error
+
+	for _, level := range levels {
+		resp, err := c.GetSongURL(ctx, songID, level, cookies)
+		if err != nil {
+			if KindOf(err) == KindDegraded || ctx.Err() != nil {
+				return nil, err
+			}
+			lastErr = err
+			continue
+		}
+		if len(resp.Data) == 0 {
+			lastErr = &Error{Kind: KindNotFound, Op: "song url", Message: "music url not available"}
+			continue
+		}
+
+		data := resp.Data[0]
+		if err := data.Err(); err != nil {
+			lastErr = err
+			continue
+		}
+		if data.Level == "" || data.Level == level {
+			return &data, nil
+		}
+		if downgraded == nil {
+			downgraded = &data
+		}
+	}
+
+	if downgraded != nil {
+		return downgraded, nil
+	}
+	if lastErr == nil {
+		lastErr = &Error{Kind: KindNotFound, Op: "song url", Message: "music url not available"}
+	}
+	return nil, lastErr
+}
+
+// ProbeQualities requests every level in Levels and reports which ones the
+// current cookie can actually obtain.
+func (c *Client) ProbeQualities(ctx context.Context, songID int64, cookies map[string]string) []QualityAvailability {
+	result := make([]QualityAvailability, len(Levels))
+	var wg sync.WaitGroup
+	for i, level := range Levels {
+		wg.Add(1)
+		go func(i int, level string) {
+			defer wg.Done()
+			result[i] = c.probeLevel(ctx, songID, level, cookies)
+		}(i, level)
+	}
+	wg.Wait()
+	return result
+}
+
+func (c *Client) probeLevel(ctx context.Context, songID int64, level string, cookies map[string]string) QualityAvailability {
+	item := QualityAvailability{Level: level}
+
+	resp, err := c.GetSongURL(ctx, songID, level, cookies)
+	if err == nil && len(resp.Data) == 0 {
+		err = &Error{Kind: KindNotFound, Op: "song url", Message: "music url not available"}
+	}
+	if err == nil {
+		err = resp.Data[0].Err()
+	}
+	if err != nil {
+		item.ErrorCode = string(KindOf(err))
+		item.Error = err.Error()
+		return item
+	}
+
+	data := resp.Data[0]
+	item.ActualLevel = data.Level
+	item.Br = data.Br
+	item.Size = data.Size
+	item.Type = data.Type
+	item.Available = data.Level == "" || data.Level == level
+	return item
+}
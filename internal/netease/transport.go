@@ -0,0 +1,164 @@
+package netease
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// TransportOptions tunes connection pooling and TLS for upstream requests.
+// Zero values keep the net/http defaults.
+type TransportOptions struct {
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int
+	IdleConnTimeout     time.Duration
+	KeepAlive           time.Duration
+	TLSHandshakeTimeout time.Duration
+	// ReadIdleTimeout fails a CDN download once a read of its body waits
+	// this long for data; 0 means 30 seconds.
+	ReadIdleTimeout    time.Duration
+	InsecureSkipVerify bool
+	MinTLSVersion      string
+}
+
+// ClientOptions configures NewClientWithOptions. APIProxy is used for API
+// calls and DownloadProxy for CDN audio downloads; both accept http, https,
+// socks5 and socks5h URLs with optional user:password. RealIP, when set, is
+// sent as X-Real-IP and X-Forwarded-For on API calls.
+type ClientOptions struct {
+	Timeout       time.Duration
+	APIProxy      string
+	DownloadProxy string
+	RealIP        string
+	Transport     TransportOptions
+}
+
+func newTransport(proxy string, opts TransportOptions, responseHeaderTimeout time.Duration) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = responseHeaderTimeout
+
+	if proxy != "" {
+		proxyURL, err := parseProxyURL(proxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if opts.KeepAlive != 0 {
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: opts.KeepAlive}
+		transport.DialContext = dialer.DialContext
+	}
+	if opts.MaxIdleConns > 0 {
+		transport.MaxIdleConns = opts.MaxIdleConns
+	}
+	if opts.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = opts.MaxIdleConnsPerHost
+	}
+	if opts.MaxConnsPerHost > 0 {
+		transport.MaxConnsPerHost = opts.MaxConnsPerHost
+	}
+	transport.IdleConnTimeout = 90 * time.Second
+	if opts.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = opts.IdleConnTimeout
+	}
+	if opts.TLSHandshakeTimeout > 0 {
+		transport.TLSHandshakeTimeout = opts.TLSHandshakeTimeout
+	}
+
+	if opts.InsecureSkipVerify || opts.MinTLSVersion != "" {
+		tlsConfig := &tls.Config{InsecureSkipVerify: opts.InsecureSkipVerify}
+		if opts.MinTLSVersion != "" {
+			version, err := parseTLSVersion(opts.MinTLSVersion)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.MinVersion = version
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return transport, nil
+}
+
+func parseProxyURL(raw string) (*url.URL, error) {
+	proxyURL, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(proxyURL.Scheme) {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, errors.New("unsupported proxy scheme: " + proxyURL.Scheme)
+	}
+	if proxyURL.Host == "" {
+		return nil, errors.New("proxy host empty")
+	}
+	return proxyURL, nil
+}
+
+func parseTLSVersion(version string) (uint16, error) {
+	switch strings.TrimSpace(version) {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, errors.New("unsupported tls version: " + version)
+}
+
+var errReadIdle = errors.New("no data received within the read idle timeout")
+
+// idleTimeoutBody bounds how long each read of a response body may wait
+// for data. The transport timeouts only cover the connection and the
+// response headers; without this a CDN that stops sending mid-body would
+// hold the download open forever. The deadline only runs during Read, so
+// a slow consumer does not trip it.
+type idleTimeoutBody struct {
+	io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	expired atomic.Bool
+	cancel  context.CancelFunc
+}
+
+// newIdleTimeoutBody wraps body, cancelling its request through cancel when
+// a read stalls for timeout. Closing the result releases the request.
+func newIdleTimeoutBody(body io.ReadCloser, timeout time.Duration, cancel context.CancelFunc) *idleTimeoutBody {
+	b := &idleTimeoutBody{ReadCloser: body, timeout: timeout, cancel: cancel}
+	b.timer = time.AfterFunc(timeout, func() {
+		b.expired.Store(true)
+		cancel()
+	})
+	b.timer.Stop()
+	return b
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	b.timer.Reset(b.timeout)
+	n, err := b.ReadCloser.Read(p)
+	b.timer.Stop()
+	if err != nil && err != io.EOF && b.expired.Load() {
+		err = &Error{Kind: KindTimeout, Op: "download", Err: errReadIdle}
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.timer.Stop()
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -33,6 +33,8 @@ type SongURLData struct {
 	Size  int64  `json:"size"`
 	Type  string `json:"type"`
 	Br    int64  `json:"br"`
+	Code  int    `json:"code"`
+	Fee   int    `json:"fee"`
 }
 
 type SongDetailResponse struct {
@@ -47,6 +49,9 @@ type SongDetailSong struct {
 	Al   Album    `json:"al"`
 	Dt   int64    `json:"dt"`
 	No   int      `json:"no"`
+	Cd   string   `json:"cd"`
+
+	PublishTime int64 `json:"publishTime"`
 }
 
 type Artist struct {
@@ -54,6 +59,7 @@ type Artist struct {
 }
 
 type Album struct {
+	ID     int64  `json:"id"`
 	Name   string `json:"name"`
 	PicURL string `json:"picUrl"`
 	Pic    int64  `json:"pic"`
@@ -65,6 +71,9 @@ type LyricResponse struct {
 	Tlyric  LyricLine `json:"tlyric"`
 	Romalrc LyricLine `json:"romalrc"`
 	Klyric  LyricLine `json:"klyric"`
+	// Yrc is word-timed and only present when requested through
+	// GetWordLyrics.
+	Yrc LyricLine `json:"yrc"`
 }
 
 type LyricLine struct {
@@ -157,3 +166,26 @@ type AlbumInfo struct {
 	TrackCount  int         `json:"trackCount"`
 	Tracks      []TrackInfo `json:"tracks"`
 }
+
+type AccountResponse struct {
+	Code    int             `json:"code"`
+	Account *AccountDetail  `json:"account"`
+	Profile *AccountProfile `json:"profile"`
+}
+
+type AccountDetail struct {
+	ID      int64 `json:"id"`
+	VipType int   `json:"vipType"`
+}
+
+type AccountProfile struct {
+	UserID   int64  `json:"userId"`
+	Nickname string `json:"nickname"`
+}
+
+type AccountInfo struct {
+	LoggedIn bool   `json:"logged_in"`
+	UserID   int64  `json:"user_id"`
+	Nickname string `json:"nickname"`
+	VIPType  int    `json:"vip_type"`
+}
@@ -0,0 +1,203 @@
+package playlist
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Format is an output format for Write.
+type Format string
+
+const (
+	FormatM3U8 Format = "m3u8"
+	FormatXSPF Format = "xspf"
+	FormatCSV  Format = "csv"
+	FormatJSON Format = "json"
+)
+
+// ParseFormat accepts a format name or its common aliases.
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "m3u8", "m3u":
+		return FormatM3U8, nil
+	case "xspf":
+		return FormatXSPF, nil
+	case "csv":
+		return FormatCSV, nil
+	case "json":
+		return FormatJSON, nil
+	}
+	return "", fmt.Errorf("unsupported playlist format %q", name)
+}
+
+// Extension is the file extension for the format, without the dot.
+func (f Format) Extension() string {
+	return string(f)
+}
+
+func (f Format) ContentType() string {
+	switch f {
+	case FormatM3U8:
+		return "audio/x-mpegurl; charset=utf-8"
+	case FormatXSPF:
+		return "application/xspf+xml; charset=utf-8"
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	}
+	return "application/json; charset=utf-8"
+}
+
+// Track is one entry of an exported list. Location is a URL or a file
+// path, empty when the track has none (e.g. it is not in the library).
+type Track struct {
+	ID         int64  `json:"id"`
+	Title      string `json:"title"`
+	Artists    string `json:"artists"`
+	Album      string `json:"album"`
+	DurationMs int64  `json:"duration_ms"`
+	Location   string `json:"location,omitempty"`
+	Image      string `json:"image,omitempty"`
+}
+
+// Playlist is a playlist or album prepared for export.
+type Playlist struct {
+	Title   string  `json:"title"`
+	Creator string  `json:"creator,omitempty"`
+	Image   string  `json:"image,omitempty"`
+	Tracks  []Track `json:"tracks"`
+}
+
+// Write encodes p in format f. M3U8 has no way to list a track without a
+// location, so such tracks are left out of it.
+func Write(w io.Writer, p *Playlist, f Format) error {
+	switch f {
+	case FormatM3U8:
+		return writeM3U8(w, p)
+	case FormatXSPF:
+		return writeXSPF(w, p)
+	case FormatCSV:
+		return writeCSV(w, p)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(p)
+}
+
+func writeM3U8(w io.Writer, p *Playlist) error {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	if p.Title != "" {
+		fmt.Fprintf(&b, "#PLAYLIST:%s\n", oneLine(p.Title))
+	}
+	for _, track := range p.Tracks {
+		if track.Location == "" {
+			continue
+		}
+		seconds := int64(-1)
+		if track.DurationMs > 0 {
+			seconds = (track.DurationMs + 500) / 1000
+		}
+		fmt.Fprintf(&b, "#EXTINF:%d,%s\n", seconds, oneLine(displayName(track)))
+		if track.Album != "" {
+			fmt.Fprintf(&b, "#EXTALB:%s\n", oneLine(track.Album))
+		}
+		b.WriteString(track.Location + "\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+type xspfPlaylist struct {
+	XMLName xml.Name    `xml:"playlist"`
+	Version string      `xml:"version,attr"`
+	XMLNS   string      `xml:"xmlns,attr"`
+	Title   string      `xml:"title,omitempty"`
+	Creator string      `xml:"creator,omitempty"`
+	Image   string      `xml:"image,omitempty"`
+	Tracks  []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Location   string `xml:"location,omitempty"`
+	Identifier string `xml:"identifier,omitempty"`
+	Title      string `xml:"title,omitempty"`
+	Creator    string `xml:"creator,omitempty"`
+	Album      string `xml:"album,omitempty"`
+	Duration   int64  `xml:"duration,omitempty"`
+	Image      string `xml:"image,omitempty"`
+}
+
+func writeXSPF(w io.Writer, p *Playlist) error {
+	doc := xspfPlaylist{
+		Version: "1",
+		XMLNS:   "http://xspf.org/ns/0/",
+		Title:   p.Title,
+		Creator: p.Creator,
+		Image:   p.Image,
+		Tracks:  make([]xspfTrack, 0, len(p.Tracks)),
+	}
+	for _, track := range p.Tracks {
+		item := xspfTrack{
+			Location: track.Location,
+			Title:    track.Title,
+			Creator:  track.Artists,
+			Album:    track.Album,
+			Duration: track.DurationMs,
+			Image:    track.Image,
+		}
+		if track.ID != 0 {
+			item.Identifier = "https://music.163.com/song?id=" + strconv.FormatInt(track.ID, 10)
+		}
+		doc.Tracks = append(doc.Tracks, item)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func writeCSV(w io.Writer, p *Playlist) error {
+	// The byte order mark makes spreadsheet programs read the file as
+	// UTF-8 instead of the local code page.
+	if _, err := io.WriteString(w, "\ufeff"); err != nil {
+		return err
+	}
+	writer := csv.NewWriter(w)
+	_ = writer.Write([]string{"id", "title", "artists", "album", "duration_ms", "location"})
+	for _, track := range p.Tracks {
+		_ = writer.Write([]string{
+			strconv.FormatInt(track.ID, 10),
+			track.Title,
+			track.Artists,
+			track.Album,
+			strconv.FormatInt(track.DurationMs, 10),
+			track.Location,
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func displayName(track Track) string {
+	if track.Artists == "" {
+		return track.Title
+	}
+	return track.Artists + " - " + track.Title
+}
+
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
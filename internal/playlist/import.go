@@ -0,0 +1,235 @@
+package playlist
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Input formats accepted by Parse.
+const (
+	InputAuto = "auto"
+	InputText = "text"
+	InputCSV  = "csv"
+)
+
+// ErrNoTitleColumn is returned for CSV input without a recognizable title
+// column.
+var ErrNoTitleColumn = errors.New("csv has no title column")
+
+// Entry is one track of an imported list. Line is its 1-based line in the
+// input, so clients can point at the entries that did not match.
+type Entry struct {
+	Line       int    `json:"line"`
+	Title      string `json:"title"`
+	Artists    string `json:"artists,omitempty"`
+	Album      string `json:"album,omitempty"`
+	DurationMs int64  `json:"duration_ms,omitempty"`
+}
+
+// Header names of the CSV columns Parse understands, lower case. They
+// cover exports by Spotify tools (Exportify, TuneMyMusic), Apple Music and
+// lists made by hand.
+var csvColumns = map[string][]string{
+	"title":    {"title", "track name", "track", "name", "song", "song name", "歌名", "歌曲", "歌曲名", "标题"},
+	"artists":  {"artist", "artists", "artist name", "artist name(s)", "artist(s)", "artist names", "歌手", "艺术家"},
+	"album":    {"album", "album name", "album title", "专辑"},
+	"duration": {"duration_ms", "duration (ms)", "track duration (ms)", "duration", "length", "time", "时长"},
+}
+
+// Parse reads a track list in format: CSV with a header row, or text with
+// one "Artist - Title" per line (extended M3U is understood as well).
+// InputAuto picks CSV when the first line is a header Parse recognizes.
+func Parse(content string, format string) ([]Entry, error) {
+	content = strings.TrimPrefix(content, "\ufeff")
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", InputAuto:
+		if header, _, _ := strings.Cut(strings.TrimSpace(content), "\n"); csvHeader(header) {
+			return parseCSV(content)
+		}
+		return parseText(content), nil
+	case InputText, "txt", "m3u", "m3u8":
+		return parseText(content), nil
+	case InputCSV:
+		return parseCSV(content)
+	}
+	return nil, fmt.Errorf("unsupported input format %q", format)
+}
+
+func csvHeader(line string) bool {
+	line = strings.TrimSpace(line)
+	reader := csv.NewReader(strings.NewReader(line))
+	reader.Comma = csvDelimiter(line)
+	fields, err := reader.Read()
+	if err != nil || len(fields) < 2 {
+		return false
+	}
+	_, ok := csvIndex(fields)["title"]
+	return ok
+}
+
+func parseCSV(content string) ([]Entry, error) {
+	header, _, _ := strings.Cut(strings.TrimSpace(content), "\n")
+	reader := csv.NewReader(strings.NewReader(strings.TrimSpace(content)))
+	reader.Comma = csvDelimiter(header)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	fields, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := csvIndex(fields)
+	if _, ok := columns["title"]; !ok {
+		return nil, ErrNoTitleColumn
+	}
+	durationMs := false
+	if i, ok := columns["duration"]; ok {
+		durationMs = strings.Contains(strings.ToLower(fields[i]), "ms")
+	}
+
+	var entries []Entry
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		line, _ := reader.FieldPos(0)
+		entry := Entry{
+			Line:       line,
+			Title:      field("title"),
+			Artists:    field("artists"),
+			Album:      field("album"),
+			DurationMs: parseDuration(field("duration"), durationMs),
+		}
+		if entry.Title != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// csvDelimiter guesses the delimiter from the header line; spreadsheet
+// programs in many locales save with semicolons.
+func csvDelimiter(header string) rune {
+	best, count := ',', strings.Count(header, ",")
+	for _, r := range []rune{';', '\t'} {
+		if n := strings.Count(header, string(r)); n > count {
+			best, count = r, n
+		}
+	}
+	return best
+}
+
+func csvIndex(fields []string) map[string]int {
+	index := map[string]int{}
+	for i, field := range fields {
+		name := strings.ToLower(strings.TrimSpace(strings.TrimPrefix(field, "\ufeff")))
+		for column, names := range csvColumns {
+			if _, seen := index[column]; seen {
+				continue
+			}
+			for _, candidate := range names {
+				if name == candidate {
+					index[column] = i
+				}
+			}
+		}
+	}
+	return index
+}
+
+// parseDuration reads milliseconds, seconds or m:ss. Bare numbers are
+// taken as seconds unless the column says ms or they are too large to be.
+func parseDuration(s string, ms bool) int64 {
+	if s == "" {
+		return 0
+	}
+	if strings.Contains(s, ":") {
+		var total int64
+		for _, part := range strings.Split(s, ":") {
+			n, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+			if err != nil {
+				return 0
+			}
+			total = total*60 + n
+		}
+		return total * 1000
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value <= 0 {
+		return 0
+	}
+	if ms || value > 10000 {
+		return int64(value)
+	}
+	return int64(value * 1000)
+}
+
+var (
+	extinfPattern    = regexp.MustCompile(`^#EXTINF:\s*(-?\d+)[^,]*,(.*)$`)
+	numberingPattern = regexp.MustCompile(`^\d{1,4}\s*[.)、]\s*`)
+	dashSeparators   = []string{" - ", " – ", " — ", " | "}
+)
+
+func parseText(content string) []Entry {
+	var entries []Entry
+	var pending *Entry
+	for i, raw := range strings.Split(content, "\n") {
+		line := strings.TrimSpace(raw)
+		if line == "" {
+			continue
+		}
+		if match := extinfPattern.FindStringSubmatch(line); match != nil {
+			// The title comes from #EXTINF; the location line after it is
+			// a file name at best.
+			entry := splitArtistTitle(match[2])
+			entry.Line = i + 1
+			if seconds, _ := strconv.ParseInt(match[1], 10, 64); seconds > 0 {
+				entry.DurationMs = seconds * 1000
+			}
+			pending = &entry
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if pending != nil {
+			entries = append(entries, *pending)
+			pending = nil
+			continue
+		}
+		entry := splitArtistTitle(numberingPattern.ReplaceAllString(line, ""))
+		entry.Line = i + 1
+		if entry.Title != "" {
+			entries = append(entries, entry)
+		}
+	}
+	if pending != nil {
+		entries = append(entries, *pending)
+	}
+	return entries
+}
+
+func splitArtistTitle(s string) Entry {
+	s = strings.TrimSpace(s)
+	for _, sep := range dashSeparators {
+		if artists, title, ok := strings.Cut(s, sep); ok && strings.TrimSpace(artists) != "" && strings.TrimSpace(title) != "" {
+			return Entry{Title: strings.TrimSpace(title), Artists: strings.TrimSpace(artists)}
+		}
+	}
+	return Entry{Title: s}
+}
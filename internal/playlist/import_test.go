@@ -0,0 +1,118 @@
+package playlist
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		format  string
+		want    []Entry
+	}{
+		{
+			name: "exportify",
+			content: "\ufeff\"Track URI\",\"Track Name\",\"Artist URI(s)\",\"Artist Name(s)\",\"Album Name\",\"Track Duration (ms)\"\n" +
+				"\"spotify:track:1\",\"Yesterday - Remastered 2009\",\"spotify:artist:1\",\"The Beatles\",\"Help!\",\"125666\"\n" +
+				"\"spotify:track:2\",\"Under Pressure\",\"spotify:artist:2,spotify:artist:3\",\"Queen, David Bowie\",\"Hot Space\",\"248440\"\n",
+			want: []Entry{
+				{Line: 2, Title: "Yesterday - Remastered 2009", Artists: "The Beatles", Album: "Help!", DurationMs: 125666},
+				{Line: 3, Title: "Under Pressure", Artists: "Queen, David Bowie", Album: "Hot Space", DurationMs: 248440},
+			},
+		},
+		{
+			name: "tunemymusic",
+			content: "Track name,Artist name,Album,Playlist name,Type,ISRC\n" +
+				"晴天,周杰伦,叶惠美,Mix,Playlist,TWK970300302\n",
+			want: []Entry{{Line: 2, Title: "晴天", Artists: "周杰伦", Album: "叶惠美"}},
+		},
+		{
+			name:    "semicolon csv",
+			content: "title;artist;duration\nHello;Adele;4:55\nRolling in the Deep;Adele;228\n",
+			want: []Entry{
+				{Line: 2, Title: "Hello", Artists: "Adele", DurationMs: 295000},
+				{Line: 3, Title: "Rolling in the Deep", Artists: "Adele", DurationMs: 228000},
+			},
+		},
+		{
+			name:    "rows without a title are dropped",
+			content: "歌名,歌手\n,周杰伦\n稻香,周杰伦\n",
+			want:    []Entry{{Line: 3, Title: "稻香", Artists: "周杰伦"}},
+		},
+		{
+			name:    "numbered text lines",
+			content: "1. Adele - Hello\n2) Queen – Bohemian Rhapsody\n\n03、周杰伦 - 晴天\nYesterday\n",
+			want: []Entry{
+				{Line: 1, Title: "Hello", Artists: "Adele"},
+				{Line: 2, Title: "Bohemian Rhapsody", Artists: "Queen"},
+				{Line: 4, Title: "晴天", Artists: "周杰伦"},
+				{Line: 5, Title: "Yesterday"},
+			},
+		},
+		{
+			// The title comes from #EXTINF, not from the file name after it.
+			name: "extended m3u",
+			content: "#EXTM3U\n#PLAYLIST:mix\n#EXTINF:215,Adele - Hello\nmusic/01 track.mp3\n" +
+				"#EXTINF:-1,Untitled\nmusic/02.mp3\n#EXTINF:100 tvg-id=\"x\",Queen - Innuendo\n",
+			format: "m3u8",
+			want: []Entry{
+				{Line: 3, Title: "Hello", Artists: "Adele", DurationMs: 215000},
+				{Line: 5, Title: "Untitled"},
+				{Line: 7, Title: "Innuendo", Artists: "Queen", DurationMs: 100000},
+			},
+		},
+		{
+			// A line that is not a known header stays text, commas and all.
+			name:    "auto keeps text with commas",
+			content: "Simon & Garfunkel - Scarborough Fair, Canticle\n",
+			want:    []Entry{{Line: 1, Title: "Scarborough Fair, Canticle", Artists: "Simon & Garfunkel"}},
+		},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.content, tt.format)
+		if err != nil {
+			t.Errorf("%s: Parse() error = %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Parse() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	if _, err := Parse("artist,album\nAdele,25\n", InputCSV); err != ErrNoTitleColumn {
+		t.Errorf("Parse(csv without title) error = %v, want ErrNoTitleColumn", err)
+	}
+	if _, err := Parse("Adele - Hello", "xlsx"); err == nil {
+		t.Error("Parse(xlsx) succeeded, want an unsupported format error")
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		value string
+		ms    bool
+		want  int64
+	}{
+		{"", false, 0},
+		{"215", false, 215000},
+		{"215.5", false, 215500},
+		{"10000", false, 10000000},
+		{"10001", false, 10001},
+		{"215000", false, 215000},
+		{"9000", true, 9000},
+		{"3:35", false, 215000},
+		{"1:02:03", false, 3723000},
+		{"3:xx", false, 0},
+		{"-5", false, 0},
+		{"n/a", false, 0},
+	}
+	for _, tt := range tests {
+		if got := parseDuration(tt.value, tt.ms); got != tt.want {
+			t.Errorf("parseDuration(%q, %v) = %d, want %d", tt.value, tt.ms, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package playlist
+
+import (
+	"context"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// DefaultMinScore is the score a candidate needs to count as a match.
+const DefaultMinScore = 0.75
+
+// Candidate is a search result scored against an entry.
+type Candidate struct {
+	ID         int64   `json:"id"`
+	Title      string  `json:"title"`
+	Artists    string  `json:"artists"`
+	Album      string  `json:"album"`
+	DurationMs int64   `json:"duration_ms"`
+	Score      float64 `json:"score"`
+}
+
+// SearchFunc returns the songs a catalogue search finds for query.
+type SearchFunc func(ctx context.Context, query string) ([]Candidate, error)
+
+// MatchOptions tune Match. Zero values select the defaults.
+type MatchOptions struct {
+	MinScore   float64
+	Alternates int // alternates kept per entry, default 3
+	Workers    int // concurrent searches, default 4
+}
+
+// Result is the outcome for one entry. Best is the highest scoring
+// candidate even when it falls short of the minimum score; ID is only set
+// when it does not.
+type Result struct {
+	Entry      Entry       `json:"entry"`
+	Matched    bool        `json:"matched"`
+	ID         int64       `json:"id,omitempty"`
+	Score      float64     `json:"score"`
+	Best       *Candidate  `json:"best,omitempty"`
+	Alternates []Candidate `json:"alternates,omitempty"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// Match searches for every entry and scores the results. Results are in
+// the order of entries.
+func Match(ctx context.Context, entries []Entry, search SearchFunc, opts MatchOptions) []Result {
+	if opts.MinScore <= 0 {
+		opts.MinScore = DefaultMinScore
+	}
+	if opts.Alternates <= 0 {
+		opts.Alternates = 3
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = 4
+	}
+
+	results := make([]Result, len(entries))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < min(opts.Workers, len(entries)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				results[j] = matchEntry(ctx, entries[j], search, opts)
+			}
+		}()
+	}
+	for i := range entries {
+		if ctx.Err() != nil {
+			results[i] = Result{Entry: entries[i], Error: ctx.Err().Error()}
+			continue
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+func matchEntry(ctx context.Context, entry Entry, search SearchFunc, opts MatchOptions) Result {
+	result := Result{Entry: entry}
+	queries := []string{strings.TrimSpace(entry.Artists + " " + cleanTitle(entry.Title))}
+	if entry.Artists != "" {
+		// Artist names are often spelled differently across services, and
+		// NetEase ranks a bare title well when the artist part misses.
+		queries = append(queries, cleanTitle(entry.Title))
+	}
+
+	seen := map[int64]bool{}
+	var candidates []Candidate
+	for _, query := range queries {
+		found, err := search(ctx, query)
+		if err != nil {
+			if len(candidates) == 0 {
+				result.Error = err.Error()
+				return result
+			}
+			break
+		}
+		for _, candidate := range found {
+			if seen[candidate.ID] {
+				continue
+			}
+			seen[candidate.ID] = true
+			candidate.Score = Score(entry, candidate)
+			candidates = append(candidates, candidate)
+		}
+		sort.SliceStable(candidates, func(a, b int) bool { return candidates[a].Score > candidates[b].Score })
+		if len(candidates) > 0 && candidates[0].Score >= opts.MinScore {
+			break
+		}
+	}
+	if len(candidates) == 0 {
+		return result
+	}
+
+	best := candidates[0]
+	result.Best = &best
+	result.Score = best.Score
+	if best.Score >= opts.MinScore {
+		result.Matched = true
+		result.ID = best.ID
+	}
+	if rest := candidates[1:]; len(rest) > 0 {
+		result.Alternates = rest[:min(len(rest), opts.Alternates)]
+	}
+	return result
+}
+
+// Score rates how well candidate fits entry from 0 to 1, weighing title,
+// artists, album and duration. Parts the entry does not know are left out
+// of the weighting, and a live, remix or instrumental version the entry
+// did not ask for (or the other way round) costs a fifth of the score.
+func Score(entry Entry, candidate Candidate) float64 {
+	total := 0.55 * similarity(normalize(cleanTitle(entry.Title)), normalize(cleanTitle(candidate.Title)))
+	weight := 0.55
+	if entry.Artists != "" {
+		total += 0.3 * artistSimilarity(entry.Artists, candidate.Artists)
+		weight += 0.3
+	}
+	if entry.Album != "" {
+		total += 0.05 * similarity(normalize(cleanTitle(entry.Album)), normalize(cleanTitle(candidate.Album)))
+		weight += 0.05
+	}
+	if entry.DurationMs > 0 && candidate.DurationMs > 0 {
+		total += 0.1 * durationSimilarity(entry.DurationMs, candidate.DurationMs)
+		weight += 0.1
+	}
+
+	score := total / weight
+	if versionTags(entry.Title) != versionTags(candidate.Title) {
+		score *= 0.8
+	}
+	return math.Round(score*1000) / 1000
+}
+
+var (
+	bracketPattern = regexp.MustCompile(`\s*[(\[（【「][^)\]）】」]*[)\]）】」]`)
+	featPattern    = regexp.MustCompile(`(?i)\s+(feat\.?|ft\.|featuring)\s+.*$`)
+	suffixPattern  = regexp.MustCompile(`(?i)\s+[-–—]\s+.*(remaster|version|edit|mono|stereo|mix|live|acoustic|demo|deluxe|bonus|版).*$`)
+	artistSplit    = regexp.MustCompile(`(?i)\s*(/|,|，|&|;|、|\+|\s+x\s+|\s+and\s+|\s+feat\.?\s+|\s+ft\.\s+|\s+featuring\s+)\s*`)
+)
+
+// cleanTitle drops what services add to titles beyond the song's name:
+// bracketed notes, featured artists and " - Remastered 2011" style
+// suffixes.
+func cleanTitle(s string) string {
+	cleaned := suffixPattern.ReplaceAllString(s, "")
+	cleaned = bracketPattern.ReplaceAllString(cleaned, "")
+	cleaned = featPattern.ReplaceAllString(cleaned, "")
+	if strings.TrimSpace(cleaned) == "" {
+		// A title that is nothing but brackets, e.g. "(untitled)".
+		return strings.TrimSpace(s)
+	}
+	return strings.TrimSpace(cleaned)
+}
+
+// normalize folds case and full-width forms and keeps only letters and
+// digits, so spacing and punctuation differences do not count.
+func normalize(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if r >= 0xFF01 && r <= 0xFF5E {
+			r -= 0xFEE0
+		}
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			b.WriteRune(unicode.ToLower(r))
+		}
+	}
+	return b.String()
+}
+
+// similarity compares normalized strings: 1 when equal, high when one
+// contains the other, otherwise by edit distance.
+func similarity(a, b string) float64 {
+	if a == "" || b == "" {
+		return 0
+	}
+	if a == b {
+		return 1
+	}
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) > len(rb) {
+		ra, rb = rb, ra
+	}
+	distance := 1 - float64(levenshtein(ra, rb))/float64(len(rb))
+	if len(ra) >= 2 && strings.Contains(string(rb), string(ra)) {
+		return math.Max(distance, 0.7+0.2*float64(len(ra))/float64(len(rb)))
+	}
+	return distance
+}
+
+// artistSimilarity averages, over the entry's artists, the best match
+// among the candidate's artists.
+func artistSimilarity(entry, candidate string) float64 {
+	want := splitArtists(entry)
+	have := splitArtists(candidate)
+	if len(want) == 0 || len(have) == 0 {
+		return 0
+	}
+	var total float64
+	for _, a := range want {
+		best := 0.0
+		for _, b := range have {
+			best = math.Max(best, similarity(a, b))
+		}
+		total += best
+	}
+	// The joined names catch "A & B" listed as a single artist.
+	joined := similarity(normalize(entry), normalize(candidate))
+	return math.Max(total/float64(len(want)), joined)
+}
+
+func splitArtists(s string) []string {
+	var artists []string
+	for _, part := range artistSplit.Split(s, -1) {
+		if name := normalize(part); name != "" {
+			artists = append(artists, name)
+		}
+	}
+	return artists
+}
+
+// durationSimilarity is 1 within 2 seconds and falls to 0 at 30 seconds.
+func durationSimilarity(a, b int64) float64 {
+	diff := math.Abs(float64(a - b))
+	if diff <= 2000 {
+		return 1
+	}
+	return math.Max(0, 1-(diff-2000)/28000)
+}
+
+var versionPattern = regexp.MustCompile(`(?i)\b(live|remix|instrumental|karaoke|acoustic|cover)\b|伴奏|现场|纯音乐|翻唱`)
+
+// versionTags lists the version keywords in a title as a sorted string.
+func versionTags(title string) string {
+	seen := map[string]bool{}
+	var tags []string
+	for _, match := range versionPattern.FindAllString(strings.ToLower(title), -1) {
+		if !seen[match] {
+			seen[match] = true
+			tags = append(tags, match)
+		}
+	}
+	sort.Strings(tags)
+	return strings.Join(tags, ",")
+}
+
+func levenshtein(a, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
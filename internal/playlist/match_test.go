@@ -0,0 +1,135 @@
+package playlist
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCleanTitle(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{"Yesterday - Remastered 2009", "Yesterday"},
+		{"Hello (Live at the Royal Albert Hall)", "Hello"},
+		{"Levels - Radio Edit", "Levels"},
+		{"Under Pressure - Live", "Under Pressure"},
+		{"Stay feat. Justin Bieber", "Stay"},
+		{"Stay (feat. Justin Bieber)", "Stay"},
+		{"晴天（Live）", "晴天"},
+		{"【伴奏】稻香", "稻香"},
+		{"Hey - Jude", "Hey - Jude"},
+		{"(untitled)", "(untitled)"},
+	}
+	for _, tt := range tests {
+		if got := cleanTitle(tt.title); got != tt.want {
+			t.Errorf("cleanTitle(%q) = %q, want %q", tt.title, got, tt.want)
+		}
+	}
+}
+
+func TestArtistSimilarity(t *testing.T) {
+	tests := []struct {
+		entry, candidate string
+		min, max         float64
+	}{
+		{"Queen", "Queen", 1, 1},
+		{"Queen, David Bowie", "David Bowie/Queen", 1, 1},
+		{"Calvin Harris feat. Rihanna", "Calvin Harris/Rihanna", 1, 1},
+		{"Calvin Harris", "Calvin Harris/Rihanna", 1, 1},
+		{"Simon & Garfunkel", "Simon & Garfunkel", 1, 1},
+		{"ＱＵＥＥＮ", "queen", 1, 1},
+		{"Jay Chou", "周杰伦", 0, 0},
+		{"Adele", "Taylor Swift", 0, 0.3},
+		{"", "Adele", 0, 0},
+	}
+	for _, tt := range tests {
+		got := artistSimilarity(tt.entry, tt.candidate)
+		if got < tt.min || got > tt.max {
+			t.Errorf("artistSimilarity(%q, %q) = %.3f, want %.2f-%.2f", tt.entry, tt.candidate, got, tt.min, tt.max)
+		}
+	}
+}
+
+func TestScore(t *testing.T) {
+	hello := Candidate{Title: "Hello", Artists: "Adele", Album: "25", DurationMs: 295000}
+	tests := []struct {
+		name      string
+		entry     Entry
+		candidate Candidate
+		min, max  float64
+	}{
+		{"exact", Entry{Title: "Hello", Artists: "Adele", Album: "25", DurationMs: 295000}, hello, 1, 1},
+		{"title only", Entry{Title: "Hello"}, hello, 1, 1},
+		{"remaster suffix", Entry{Title: "Hello - Remastered", Artists: "Adele"}, hello, 1, 1},
+		{"duration within 2s", Entry{Title: "Hello", Artists: "Adele", DurationMs: 296500}, hello, 1, 1},
+		{"duration far off", Entry{Title: "Hello", Artists: "Adele", DurationMs: 400000}, hello, 0.85, 0.95},
+		{"other artist", Entry{Title: "Hello", Artists: "Lionel Richie"}, hello, 0.5, DefaultMinScore},
+		{"other song", Entry{Title: "Someone Like You", Artists: "Adele"}, hello, 0, 0.5},
+		// Version tags cost a fifth whichever side has them.
+		{"live candidate", Entry{Title: "Hello", Artists: "Adele"}, Candidate{Title: "Hello (Live)", Artists: "Adele"}, 0.8, 0.8},
+		{"live entry", Entry{Title: "Hello - Live", Artists: "Adele"}, hello, 0.8, 0.8},
+		{"both live", Entry{Title: "Hello (Live)", Artists: "Adele"}, Candidate{Title: "Hello - Live", Artists: "Adele"}, 1, 1},
+		{"remix for live", Entry{Title: "Hello (Remix)", Artists: "Adele"}, Candidate{Title: "Hello (Live)", Artists: "Adele"}, 0.8, 0.8},
+		{"chinese live tag", Entry{Title: "晴天", Artists: "周杰伦"}, Candidate{Title: "晴天 (现场)", Artists: "周杰伦"}, 0.8, 0.8},
+	}
+	for _, tt := range tests {
+		got := Score(tt.entry, tt.candidate)
+		if got < tt.min || got > tt.max {
+			t.Errorf("%s: Score() = %.3f, want %.2f-%.2f", tt.name, got, tt.min, tt.max)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	catalogue := map[string][]Candidate{
+		"Adele Hello": {
+			{ID: 1, Title: "Hello (Live)", Artists: "Adele"},
+			{ID: 2, Title: "Hello", Artists: "Adele"},
+		},
+		// Only the bare title finds the song: the artist is spelled
+		// differently on NetEase.
+		"Jay Chou 晴天": nil,
+		"晴天":          {{ID: 3, Title: "晴天", Artists: "周杰伦"}},
+		"Nobody Unknown Song": {
+			{ID: 4, Title: "Another Song", Artists: "Somebody"},
+		},
+		"Unknown Song": nil,
+	}
+	search := func(ctx context.Context, query string) ([]Candidate, error) {
+		if query == "broken" {
+			return nil, errors.New("search failed")
+		}
+		return catalogue[query], nil
+	}
+	entries := []Entry{
+		{Line: 1, Title: "Hello", Artists: "Adele"},
+		{Line: 2, Title: "晴天", Artists: "Jay Chou"},
+		{Line: 3, Title: "Unknown Song", Artists: "Nobody"},
+		{Line: 4, Title: "broken"},
+	}
+
+	results := Match(context.Background(), entries, search, MatchOptions{})
+	if len(results) != len(entries) {
+		t.Fatalf("Match() returned %d results for %d entries", len(results), len(entries))
+	}
+	if r := results[0]; !r.Matched || r.ID != 2 || len(r.Alternates) != 1 || r.Alternates[0].ID != 1 {
+		t.Errorf("studio version: %+v, want ID 2 with the live version as alternate", r)
+	}
+	if r := results[1]; r.Best == nil || r.Best.ID != 3 || r.Entry.Line != 2 {
+		t.Errorf("title-only fallback: %+v, want ID 3 as best", r)
+	}
+	if r := results[2]; r.Matched || r.ID != 0 || r.Best == nil || r.Best.ID != 4 || r.Score >= DefaultMinScore {
+		t.Errorf("below the minimum score: %+v, want unmatched with ID 4 as best", r)
+	}
+	if r := results[3]; r.Matched || r.Error == "" {
+		t.Errorf("failed search: %+v, want the error reported", r)
+	}
+
+	// A lower bar accepts the weak candidate.
+	results = Match(context.Background(), entries[2:3], search, MatchOptions{MinScore: 0.1})
+	if r := results[0]; !r.Matched || r.ID != 4 {
+		t.Errorf("MinScore 0.1: %+v, want ID 4 matched", r)
+	}
+}
@@ -0,0 +1,197 @@
+package probe
+
+import (
+	"errors"
+	"io"
+)
+
+func probeFLAC(r io.ReaderAt, start, size int64) (*Info, error) {
+	info := &Info{Format: FormatFLAC, Codec: "flac"}
+	var totalSamples int64
+	var maxBlock, maxFrame int
+
+	pos := start + 4
+	for last := false; !last; {
+		header, err := readAt(r, pos, 4, size)
+		if err != nil {
+			info.Truncated = true
+			return info, nil
+		}
+		last = header[0]&0x80 != 0
+		length := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+		if header[0]&0x7f == 0 {
+			block, err := readAt(r, pos+4, 34, size)
+			if err != nil {
+				info.Truncated = true
+				return info, nil
+			}
+			maxBlock = int(be.Uint16(block[2:4]))
+			maxFrame = int(block[7])<<16 | int(block[8])<<8 | int(block[9])
+			info.SampleRate = int(block[10])<<12 | int(block[11])<<4 | int(block[12])>>4
+			info.Channels = int(block[12]>>1&0x07) + 1
+			info.BitDepth = int(block[12]&0x01)<<4 | int(block[13]>>4) + 1
+			totalSamples = int64(block[13]&0x0f)<<32 | int64(be.Uint32(block[14:18]))
+		}
+		pos += 4 + length
+	}
+	if info.SampleRate == 0 {
+		return nil, errors.New("missing STREAMINFO block")
+	}
+	if totalSamples > 0 {
+		info.DurationMs = totalSamples * 1000 / int64(info.SampleRate)
+	}
+
+	first, err := readAt(r, pos, 2, size)
+	if err != nil {
+		info.Truncated = true
+		return info, nil
+	}
+	if first[0] != 0xff || first[1]&0xfe != 0xf8 {
+		return nil, errors.New("no frame after metadata")
+	}
+
+	end := size
+	if trailer, err := readAt(r, size-128, 3, size); err == nil && string(trailer) == "TAG" && size-128 >= pos {
+		end -= 128
+	}
+	window := int64(max(2*maxFrame, 64<<10)) + 32
+	buf, _, err := tail(r, pos, end, window)
+	if err != nil {
+		return nil, err
+	}
+	info.Truncated = !completeFLAC(buf, maxBlock, totalSamples)
+	return info, nil
+}
+
+// completeFLAC reports whether buf, the end of the audio data, finishes
+// with a whole frame (its CRC-16 matches) that holds the last samples of
+// the stream.
+func completeFLAC(buf []byte, maxBlock int, totalSamples int64) bool {
+	for i := len(buf) - 6; i >= 0; i-- {
+		if buf[i] != 0xff || buf[i+1]&0xfe != 0xf8 {
+			continue
+		}
+		number, blockSize, variable, ok := parseFLACFrameHeader(buf[i:])
+		if !ok || crc16(buf[i:len(buf)-2]) != be.Uint16(buf[len(buf)-2:]) {
+			continue
+		}
+		if totalSamples == 0 {
+			return true
+		}
+		first := number
+		if !variable {
+			first = number * int64(maxBlock)
+		}
+		return first+int64(blockSize) >= totalSamples
+	}
+	return false
+}
+
+// parseFLACFrameHeader decodes the frame header at b, checking its CRC-8.
+// number is the frame number, or the first sample for variable block sizes.
+func parseFLACFrameHeader(b []byte) (number int64, blockSize int, variable bool, ok bool) {
+	if len(b) < 6 {
+		return 0, 0, false, false
+	}
+	variable = b[1]&0x01 != 0
+	blockCode, rateCode := b[2]>>4, b[2]&0x0f
+	channels, sampleSize := b[3]>>4, b[3]>>1&0x07
+	if blockCode == 0 || rateCode == 15 || channels > 10 || sampleSize == 3 || b[3]&0x01 != 0 {
+		return 0, 0, false, false
+	}
+
+	// The frame or sample number is UTF-8 style coded in up to 7 bytes.
+	pos := 4
+	lead := b[pos]
+	extra := 0
+	switch {
+	case lead < 0x80:
+	case lead&0xe0 == 0xc0:
+		extra = 1
+	case lead&0xf0 == 0xe0:
+		extra = 2
+	case lead&0xf8 == 0xf0:
+		extra = 3
+	case lead&0xfc == 0xf8:
+		extra = 4
+	case lead&0xfe == 0xfc:
+		extra = 5
+	case lead == 0xfe:
+		extra = 6
+	default:
+		return 0, 0, false, false
+	}
+	number = int64(lead & (0x7f >> extra))
+	if extra > 0 {
+		number = int64(lead & (0x3f >> extra))
+	}
+	pos++
+	for ; extra > 0; extra-- {
+		if pos >= len(b) || b[pos]&0xc0 != 0x80 {
+			return 0, 0, false, false
+		}
+		number = number<<6 | int64(b[pos]&0x3f)
+		pos++
+	}
+
+	switch {
+	case blockCode == 1:
+		blockSize = 192
+	case blockCode <= 5:
+		blockSize = 576 << (blockCode - 2)
+	case blockCode == 6:
+		if pos >= len(b) {
+			return 0, 0, false, false
+		}
+		blockSize = int(b[pos]) + 1
+		pos++
+	case blockCode == 7:
+		if pos+1 >= len(b) {
+			return 0, 0, false, false
+		}
+		blockSize = int(be.Uint16(b[pos:])) + 1
+		pos += 2
+	default:
+		blockSize = 256 << (blockCode - 8)
+	}
+	switch rateCode {
+	case 12:
+		pos++
+	case 13, 14:
+		pos += 2
+	}
+	if pos >= len(b) || crc8(b[:pos]) != b[pos] {
+		return 0, 0, false, false
+	}
+	return number, blockSize, variable, true
+}
+
+func crc8(data []byte) byte {
+	var crc byte
+	for _, c := range data {
+		crc ^= c
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func crc16(data []byte) uint16 {
+	var crc uint16
+	for _, c := range data {
+		crc ^= uint16(c) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,160 @@
+package probe
+
+import (
+	"errors"
+	"io"
+)
+
+// maxMoovSize bounds the metadata box read into memory; audio-only files
+// keep it well under a megabyte.
+const maxMoovSize = 16 << 20
+
+func probeMP4(r io.ReaderAt, start, size int64) (*Info, error) {
+	info := &Info{Format: FormatM4A}
+	var moov []byte
+
+	for pos := start; pos < size; {
+		header, err := readAt(r, pos, 8, size)
+		if err != nil {
+			info.Truncated = true
+			break
+		}
+		boxSize, headerSize := int64(be.Uint32(header)), int64(8)
+		switch boxSize {
+		case 0:
+			boxSize = size - pos
+		case 1:
+			large, err := readAt(r, pos+8, 8, size)
+			if err != nil {
+				info.Truncated = true
+				return info, nil
+			}
+			boxSize, headerSize = int64(be.Uint64(large)), 16
+		}
+		if boxSize < headerSize {
+			return nil, errors.New("invalid box size")
+		}
+		if pos+boxSize > size {
+			info.Truncated = true
+			break
+		}
+		if string(header[4:8]) == "moov" {
+			if boxSize > maxMoovSize {
+				return nil, errors.New("moov box too large")
+			}
+			if moov, err = readAt(r, pos+headerSize, int(boxSize-headerSize), size); err != nil {
+				return nil, err
+			}
+		}
+		pos += boxSize
+	}
+	if moov == nil {
+		// The index is usually written last, so a cut off download
+		// loses it first.
+		info.Truncated = true
+		return info, nil
+	}
+
+	track := soundTrack(moov)
+	if track == nil {
+		return nil, errors.New("no audio track")
+	}
+	mdia := findBox(track, "mdia")
+	if mdhd := findBox(mdia, "mdhd"); len(mdhd) >= 24 {
+		var timescale, duration int64
+		if mdhd[0] == 1 && len(mdhd) >= 32 {
+			timescale, duration = int64(be.Uint32(mdhd[20:])), int64(be.Uint64(mdhd[24:]))
+		} else {
+			timescale, duration = int64(be.Uint32(mdhd[12:])), int64(be.Uint32(mdhd[16:]))
+		}
+		if timescale > 0 {
+			info.DurationMs = duration * 1000 / timescale
+		}
+	}
+
+	stbl := findBox(findBox(mdia, "minf"), "stbl")
+	if stsd := findBox(stbl, "stsd"); len(stsd) >= 8+36 {
+		entry := stsd[8:]
+		info.Channels = int(be.Uint16(entry[24:]))
+		info.SampleRate = int(be.Uint16(entry[32:]))
+		switch string(entry[4:8]) {
+		case "mp4a":
+			info.Codec = "aac"
+		case "alac":
+			info.Codec = "alac"
+			info.BitDepth = int(be.Uint16(entry[26:]))
+			// The decoder config holds the real rate, which may not fit
+			// the 16-bit sample entry field.
+			if cookie := entry[36:]; len(cookie) >= 36 && string(cookie[4:8]) == "alac" {
+				info.BitDepth = int(cookie[17])
+				info.Channels = int(cookie[21])
+				info.SampleRate = int(be.Uint32(cookie[32:]))
+			}
+		case "fLaC":
+			info.Codec = "flac"
+			info.BitDepth = int(be.Uint16(entry[26:]))
+		case "Opus":
+			info.Codec = "opus"
+		default:
+			info.Codec = string(entry[4:8])
+		}
+	}
+
+	// Every chunk the index points at has to lie inside the file.
+	if offset := lastChunkOffset(stbl); offset >= size {
+		info.Truncated = true
+	}
+	return info, nil
+}
+
+// soundTrack returns the body of the first trak in moov whose handler is
+// "soun".
+func soundTrack(moov []byte) []byte {
+	for _, trak := range findBoxes(moov, "trak") {
+		hdlr := findBox(findBox(trak, "mdia"), "hdlr")
+		if len(hdlr) >= 12 && string(hdlr[8:12]) == "soun" {
+			return trak
+		}
+	}
+	return nil
+}
+
+func lastChunkOffset(stbl []byte) int64 {
+	var last int64
+	if stco := findBox(stbl, "stco"); len(stco) >= 8 {
+		count := int(be.Uint32(stco[4:]))
+		for i := 0; i < count && 8+4*i+4 <= len(stco); i++ {
+			last = max(last, int64(be.Uint32(stco[8+4*i:])))
+		}
+	}
+	if co64 := findBox(stbl, "co64"); len(co64) >= 8 {
+		count := int(be.Uint32(co64[4:]))
+		for i := 0; i < count && 8+8*i+8 <= len(co64); i++ {
+			last = max(last, int64(be.Uint64(co64[8+8*i:])))
+		}
+	}
+	return last
+}
+
+func findBox(data []byte, name string) []byte {
+	if boxes := findBoxes(data, name); len(boxes) > 0 {
+		return boxes[0]
+	}
+	return nil
+}
+
+// findBoxes returns the bodies of the child boxes of data called name.
+func findBoxes(data []byte, name string) [][]byte {
+	var found [][]byte
+	for len(data) >= 8 {
+		size := int(be.Uint32(data))
+		if size < 8 || size > len(data) {
+			break
+		}
+		if string(data[4:8]) == name {
+			found = append(found, data[8:size])
+		}
+		data = data[size:]
+	}
+	return found
+}
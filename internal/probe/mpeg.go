@@ -0,0 +1,210 @@
+package probe
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+type mpegHeader struct {
+	version    int // 1, 2, or 25 for MPEG 2.5
+	layer      int
+	bitrate    int // kbps
+	sampleRate int
+	channels   int
+	length     int // bytes, including the header
+	samples    int // per frame
+}
+
+var mpegBitrates = [...][16]int{
+	{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448, -1}, // V1 L1
+	{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, -1},    // V1 L2
+	{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, -1},     // V1 L3
+	{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256, -1},    // V2 L1
+	{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, -1},         // V2 L2, L3
+}
+
+// parseMPEGHeader decodes the MPEG audio frame header at b. Free-format
+// and reserved values are rejected.
+func parseMPEGHeader(b []byte) (mpegHeader, bool) {
+	if len(b) < 4 || b[0] != 0xff || b[1]&0xe0 != 0xe0 {
+		return mpegHeader{}, false
+	}
+	var h mpegHeader
+	switch b[1] >> 3 & 0x03 {
+	case 0:
+		h.version = 25
+	case 2:
+		h.version = 2
+	case 3:
+		h.version = 1
+	default:
+		return mpegHeader{}, false
+	}
+	layerBits := b[1] >> 1 & 0x03
+	if layerBits == 0 {
+		return mpegHeader{}, false
+	}
+	h.layer = 4 - int(layerBits)
+
+	table := 3
+	if h.version == 1 {
+		table = h.layer - 1
+	} else if h.layer > 1 {
+		table = 4
+	}
+	h.bitrate = mpegBitrates[table][b[2]>>4]
+	rateIndex := b[2] >> 2 & 0x03
+	if h.bitrate <= 0 || rateIndex == 3 {
+		return mpegHeader{}, false
+	}
+	h.sampleRate = [...]int{44100, 48000, 32000}[rateIndex]
+	switch h.version {
+	case 2:
+		h.sampleRate /= 2
+	case 25:
+		h.sampleRate /= 4
+	}
+	h.channels = 2
+	if b[3]>>6 == 3 {
+		h.channels = 1
+	}
+
+	padding := int(b[2] >> 1 & 0x01)
+	switch {
+	case h.layer == 1:
+		h.samples = 384
+		h.length = (12*h.bitrate*1000/h.sampleRate + padding) * 4
+	case h.layer == 3 && h.version != 1:
+		h.samples = 576
+		h.length = 72*h.bitrate*1000/h.sampleRate + padding
+	default:
+		h.samples = 1152
+		h.length = 144*h.bitrate*1000/h.sampleRate + padding
+	}
+	return h, true
+}
+
+func probeMPEG(r io.ReaderAt, start, size int64) (*Info, error) {
+	// Trailers are only stripped when they fit after the audio start; a
+	// corrupt size must not push end before it.
+	end := size
+	if trailer, err := readAt(r, end-128, 3, size); err == nil && string(trailer) == "TAG" && end-128 >= start {
+		end -= 128
+	}
+	if footer, err := readAt(r, end-32, 32, size); err == nil && string(footer[:8]) == "APETAGEX" {
+		// The tag size counts the footer but not the optional header.
+		tagSize := int64(le.Uint32(footer[12:16]))
+		if le.Uint32(footer[20:24])&0x80000000 != 0 {
+			tagSize += 32
+		}
+		if tagSize >= 32 && tagSize <= end-start {
+			end -= tagSize
+		}
+	}
+
+	first, header, err := findMPEGStream(r, start, end)
+	if err != nil {
+		return nil, err
+	}
+	info := &Info{
+		Format:     FormatMP3,
+		Codec:      [...]string{"", "mp1", "mp2", "mp3"}[header.layer],
+		SampleRate: header.sampleRate,
+		Channels:   header.channels,
+	}
+
+	// A Xing/Info or VBRI frame at the start carries the frame count of
+	// the whole stream; it is silent and not counted itself.
+	expected := int64(-1)
+	if frame, err := readAt(r, first, min(header.length, 64), end); err == nil {
+		if count, ok := vbrFrameCount(frame, header); ok {
+			expected = count
+			first += int64(header.length)
+		}
+	}
+
+	frames, truncated, err := walkMPEGFrames(r, first, end)
+	if err != nil {
+		return nil, err
+	}
+	info.Truncated = truncated || (expected > 0 && frames < expected)
+	info.DurationMs = frames * int64(header.samples) * 1000 / int64(header.sampleRate)
+	return info, nil
+}
+
+// findMPEGStream finds the first frame header in the leading 64 KiB that
+// is followed by another valid header, skipping junk before the audio.
+func findMPEGStream(r io.ReaderAt, start, end int64) (int64, mpegHeader, error) {
+	buf, err := readAt(r, start, int(min(end-start, 64<<10)), end)
+	if err != nil {
+		return 0, mpegHeader{}, err
+	}
+	for i := 0; i+4 <= len(buf); i++ {
+		h, ok := parseMPEGHeader(buf[i:])
+		if !ok {
+			continue
+		}
+		next := start + int64(i+h.length)
+		if next == end {
+			return start + int64(i), h, nil
+		}
+		if b, err := readAt(r, next, 4, end); err == nil {
+			if _, ok := parseMPEGHeader(b); ok {
+				return start + int64(i), h, nil
+			}
+		}
+	}
+	return 0, mpegHeader{}, errors.New("no MPEG audio frames")
+}
+
+// walkMPEGFrames counts the frames from pos to end. The stream is
+// truncated when its last frame runs past end; trailing bytes that are
+// not a frame header (unknown tags) end the walk without error.
+func walkMPEGFrames(r io.ReaderAt, pos, end int64) (frames int64, truncated bool, err error) {
+	reader := bufio.NewReaderSize(io.NewSectionReader(r, pos, end-pos), 64<<10)
+	for pos < end {
+		b, err := reader.Peek(4)
+		if err != nil {
+			// Fewer than 4 bytes left: the tail of a cut off frame.
+			return frames, true, nil
+		}
+		h, ok := parseMPEGHeader(b)
+		if !ok {
+			return frames, false, nil
+		}
+		if pos+int64(h.length) > end {
+			return frames, true, nil
+		}
+		if _, err := reader.Discard(h.length); err != nil {
+			return frames, false, err
+		}
+		pos += int64(h.length)
+		frames++
+	}
+	return frames, false, nil
+}
+
+// vbrFrameCount reads the frame count from a Xing/Info or VBRI header in
+// frame, the first frame of the stream.
+func vbrFrameCount(frame []byte, h mpegHeader) (int64, bool) {
+	offset := 4 + 32
+	switch {
+	case h.version == 1 && h.channels == 1:
+		offset = 4 + 17
+	case h.version != 1 && h.channels == 2:
+		offset = 4 + 17
+	case h.version != 1:
+		offset = 4 + 9
+	}
+	if len(frame) >= offset+12 {
+		tag := string(frame[offset : offset+4])
+		if (tag == "Xing" || tag == "Info") && be.Uint32(frame[offset+4:])&0x01 != 0 {
+			return int64(be.Uint32(frame[offset+8:])), true
+		}
+	}
+	if len(frame) >= 36+18 && string(frame[36:40]) == "VBRI" {
+		return int64(be.Uint32(frame[36+14:])), true
+	}
+	return 0, false
+}
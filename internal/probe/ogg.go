@@ -0,0 +1,84 @@
+package probe
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+const oggEndOfStream = 0x04
+
+func probeOgg(r io.ReaderAt, start, size int64) (*Info, error) {
+	head, err := readAt(r, start, int(min(size-start, 27+255+64)), size)
+	if err != nil || len(head) < 28 {
+		return &Info{Format: FormatOgg, Truncated: true}, nil
+	}
+	serial := le.Uint32(head[14:])
+	packet := head[min(len(head), 27+int(head[26])):]
+
+	info := &Info{Format: FormatOgg}
+	var preSkip int64
+	switch {
+	case len(packet) >= 28 && bytes.HasPrefix(packet, []byte("\x01vorbis")):
+		info.Codec = "vorbis"
+		info.Channels = int(packet[11])
+		info.SampleRate = int(le.Uint32(packet[12:]))
+	case len(packet) >= 19 && bytes.HasPrefix(packet, []byte("OpusHead")):
+		info.Codec = "opus"
+		info.Channels = int(packet[9])
+		preSkip = int64(le.Uint16(packet[10:]))
+		// Opus always decodes at 48 kHz; the header records the rate of
+		// the original input.
+		info.SampleRate = int(le.Uint32(packet[12:]))
+	case bytes.HasPrefix(packet, []byte("\x7fFLAC")):
+		info.Codec = "flac"
+	default:
+		return nil, errors.New("unknown codec in first page")
+	}
+
+	// The last page of the stream carries the final granule position and
+	// the end-of-stream flag, and must end exactly at the end of the file.
+	buf, _, err := tail(r, start, size, 2*65307)
+	if err != nil {
+		return nil, err
+	}
+	page, pageEnd := lastOggPage(buf, serial)
+	if page == nil {
+		info.Truncated = true
+		return info, nil
+	}
+	info.Truncated = pageEnd != len(buf) || page[5]&oggEndOfStream == 0
+
+	granule := int64(le.Uint64(page[6:]))
+	switch info.Codec {
+	case "opus":
+		info.DurationMs = (granule - preSkip) * 1000 / 48000
+	case "vorbis":
+		if info.SampleRate > 0 {
+			info.DurationMs = granule * 1000 / int64(info.SampleRate)
+		}
+	}
+	return info, nil
+}
+
+// lastOggPage finds the last complete page of the logical stream serial in
+// buf and returns it with the offset just past its end.
+func lastOggPage(buf []byte, serial uint32) ([]byte, int) {
+	for i := bytes.LastIndex(buf, []byte("OggS")); i >= 0; i = bytes.LastIndex(buf[:i], []byte("OggS")) {
+		if len(buf)-i < 27 || le.Uint32(buf[i+14:]) != serial {
+			continue
+		}
+		segments := int(buf[i+26])
+		if len(buf)-i < 27+segments {
+			continue
+		}
+		end := i + 27 + segments
+		for _, n := range buf[i+27 : i+27+segments] {
+			end += int(n)
+		}
+		if end <= len(buf) {
+			return buf[i:end], end
+		}
+	}
+	return nil, 0
+}
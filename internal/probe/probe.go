@@ -0,0 +1,194 @@
+package probe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	FormatFLAC = "flac"
+	FormatMP3  = "mp3"
+	FormatM4A  = "m4a"
+	FormatOgg  = "ogg"
+)
+
+var ErrUnknownFormat = errors.New("unrecognised audio format")
+
+// Info describes an audio file as found in its container. Truncated is set
+// when the stream ends before the container says it should.
+type Info struct {
+	Format     string `json:"format"`
+	Codec      string `json:"codec"`
+	SampleRate int    `json:"sample_rate,omitempty"`
+	Channels   int    `json:"channels,omitempty"`
+	BitDepth   int    `json:"bit_depth,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+	Bitrate    int    `json:"bitrate,omitempty"` // average kbps
+	Truncated  bool   `json:"truncated"`
+}
+
+// Extension is the file extension the content should be stored under.
+func (i *Info) Extension() string {
+	if i.Format == FormatOgg && i.Codec == "opus" {
+		return "opus"
+	}
+	return i.Format
+}
+
+// File probes the audio file at path.
+func File(path string) (*Info, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return Probe(file, stat.Size())
+}
+
+// Probe identifies the container of the size bytes in r from its magic
+// bytes, skipping leading ID3v2 tags, and reads its stream parameters.
+func Probe(r io.ReaderAt, size int64) (*Info, error) {
+	start, err := skipID3v2(r, size)
+	if err != nil {
+		return nil, err
+	}
+	head, err := readAt(r, start, 12, size)
+	if err != nil {
+		return nil, ErrUnknownFormat
+	}
+
+	var info *Info
+	switch detect(head) {
+	case FormatFLAC:
+		info, err = probeFLAC(r, start, size)
+	case FormatM4A:
+		info, err = probeMP4(r, start, size)
+	case FormatOgg:
+		info, err = probeOgg(r, start, size)
+	case FormatMP3:
+		info, err = probeMPEG(r, start, size)
+	default:
+		return nil, ErrUnknownFormat
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", detect(head), err)
+	}
+	if info.DurationMs > 0 {
+		info.Bitrate = int((size - start) * 8 / info.DurationMs)
+	}
+	return info, nil
+}
+
+// Sniff returns the file extension for the audio format head (the first
+// bytes of a file) starts with, or "" when it is not recognised. A file
+// opening with an ID3v2 tag too long to see past is taken to be MP3.
+func Sniff(head []byte) string {
+	for len(head) >= 10 && bytes.HasPrefix(head, []byte("ID3")) {
+		tagSize := id3v2Size(head)
+		if tagSize >= int64(len(head)) {
+			return FormatMP3
+		}
+		head = head[tagSize:]
+	}
+	format := detect(head)
+	if format == FormatOgg && len(head) >= 28 {
+		// The identification packet follows the segment table of the
+		// first page.
+		packet := head[min(len(head), 27+int(head[26])):]
+		if bytes.HasPrefix(packet, []byte("OpusHead")) {
+			return "opus"
+		}
+	}
+	return format
+}
+
+// ContentType is the MIME type for a file extension returned by Sniff or
+// Info.Extension.
+func ContentType(extension string) string {
+	switch extension {
+	case FormatFLAC:
+		return "audio/flac"
+	case FormatMP3:
+		return "audio/mpeg"
+	case FormatM4A:
+		return "audio/mp4"
+	case FormatOgg, "opus":
+		return "audio/ogg"
+	}
+	return "application/octet-stream"
+}
+
+func detect(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte("fLaC")):
+		return FormatFLAC
+	case bytes.HasPrefix(head, []byte("OggS")):
+		return FormatOgg
+	case len(head) >= 8 && string(head[4:8]) == "ftyp":
+		return FormatM4A
+	case len(head) >= 4:
+		if _, ok := parseMPEGHeader(head); ok {
+			return FormatMP3
+		}
+	}
+	return ""
+}
+
+// skipID3v2 returns the offset of the first byte after any ID3v2 tags.
+func skipID3v2(r io.ReaderAt, size int64) (int64, error) {
+	var offset int64
+	for {
+		head, err := readAt(r, offset, 10, size)
+		if err != nil || !bytes.HasPrefix(head, []byte("ID3")) {
+			return offset, nil
+		}
+		offset += id3v2Size(head)
+		if offset > size {
+			return 0, errors.New("id3 tag runs past end of file")
+		}
+	}
+}
+
+// id3v2Size is the full length of the ID3v2 tag starting at head,
+// including its header and optional footer.
+func id3v2Size(head []byte) int64 {
+	size := int64(head[6]&0x7f)<<21 | int64(head[7]&0x7f)<<14 | int64(head[8]&0x7f)<<7 | int64(head[9]&0x7f)
+	size += 10
+	if head[5]&0x10 != 0 {
+		size += 10
+	}
+	return size
+}
+
+// readAt reads exactly n bytes at off, failing with io.ErrUnexpectedEOF
+// when the file ends first.
+func readAt(r io.ReaderAt, off int64, n int, size int64) ([]byte, error) {
+	if off < 0 || n < 0 || off+int64(n) > size {
+		return nil, io.ErrUnexpectedEOF
+	}
+	buf := make([]byte, n)
+	if _, err := r.ReadAt(buf, off); err != nil && !(errors.Is(err, io.EOF) && off+int64(n) == size) {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// tail reads the last n bytes before end, or everything from start when
+// that is less.
+func tail(r io.ReaderAt, start, end int64, n int64) ([]byte, int64, error) {
+	from := max(start, end-n)
+	buf, err := readAt(r, from, int(end-from), end)
+	return buf, from, err
+}
+
+var be = binary.BigEndian
+var le = binary.LittleEndian
@@ -0,0 +1,246 @@
+package probe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// mp3Frames builds n MPEG-1 Layer III frames at 128 kbps, 44.1 kHz stereo.
+func mp3Frames(n int) []byte {
+	frame := make([]byte, 417)
+	copy(frame, []byte{0xff, 0xfb, 0x90, 0x00})
+	return bytes.Repeat(frame, n)
+}
+
+// flacFile builds a FLAC stream of n fixed 4096-sample frames with valid
+// header and frame CRCs.
+func flacFile(n int) []byte {
+	var b bytes.Buffer
+	b.WriteString("fLaC")
+	streaminfo := make([]byte, 34)
+	binary.BigEndian.PutUint16(streaminfo[0:], 4096)
+	binary.BigEndian.PutUint16(streaminfo[2:], 4096)
+	// 44100 Hz, 2 channels, 16 bits, n*4096 samples.
+	rate := uint32(44100)
+	streaminfo[10] = byte(rate >> 12)
+	streaminfo[11] = byte(rate >> 4)
+	streaminfo[12] = byte(rate<<4) | 1<<1
+	streaminfo[13] = 15 << 4
+	binary.BigEndian.PutUint32(streaminfo[14:], uint32(n*4096))
+	b.Write([]byte{0x80, 0, 0, 34})
+	b.Write(streaminfo)
+	for i := 0; i < n; i++ {
+		frame := []byte{0xff, 0xf8, 0xc9, 0x18, byte(i)}
+		frame = append(frame, crc8(frame))
+		frame = append(frame, bytes.Repeat([]byte{0x11}, 200)...)
+		frame = binary.BigEndian.AppendUint16(frame, crc16(frame))
+		b.Write(frame)
+	}
+	return b.Bytes()
+}
+
+func oggPage(flags byte, granule uint64, sequence uint32, packet []byte) []byte {
+	page := []byte("OggS")
+	page = append(page, 0, flags)
+	page = binary.LittleEndian.AppendUint64(page, granule)
+	page = binary.LittleEndian.AppendUint32(page, 0x1234)
+	page = binary.LittleEndian.AppendUint32(page, sequence)
+	page = append(page, 0, 0, 0, 0)
+	var lacing []byte
+	for rest := len(packet); ; rest -= 255 {
+		if rest < 255 {
+			lacing = append(lacing, byte(rest))
+			break
+		}
+		lacing = append(lacing, 255)
+	}
+	page = append(page, byte(len(lacing)))
+	page = append(page, lacing...)
+	return append(page, packet...)
+}
+
+// opusFile builds an Ogg Opus stream lasting two seconds.
+func opusFile() []byte {
+	head := []byte("OpusHead")
+	head = append(head, 1, 2)
+	head = binary.LittleEndian.AppendUint16(head, 312)
+	head = binary.LittleEndian.AppendUint32(head, 48000)
+	head = append(head, 0, 0, 0)
+
+	var b bytes.Buffer
+	b.Write(oggPage(0x02, 0, 0, head))
+	b.Write(oggPage(0, 0, 1, append([]byte("OpusTags"), make([]byte, 8)...)))
+	b.Write(oggPage(0, 48000, 2, bytes.Repeat([]byte{0x22}, 300)))
+	b.Write(oggPage(oggEndOfStream, 2*48000+312, 3, bytes.Repeat([]byte{0x22}, 300)))
+	return b.Bytes()
+}
+
+func box(name string, body ...[]byte) []byte {
+	joined := bytes.Join(body, nil)
+	out := binary.BigEndian.AppendUint32(nil, uint32(8+len(joined)))
+	return append(append(out, name...), joined...)
+}
+
+// m4aFile builds an AAC file lasting three seconds, with the index before
+// the audio data (moovFirst) or after it.
+func m4aFile(moovFirst bool) []byte {
+	ftyp := box("ftyp", []byte("M4A \x00\x00\x00\x00M4A mp42"))
+	audio := bytes.Repeat([]byte{0x33}, 1000)
+
+	moov := func(chunkOffset uint32) []byte {
+		mdhd := make([]byte, 24)
+		binary.BigEndian.PutUint32(mdhd[12:], 44100)
+		binary.BigEndian.PutUint32(mdhd[16:], 3*44100)
+		hdlr := append(make([]byte, 8), "soun"...)
+		hdlr = append(hdlr, make([]byte, 13)...)
+		entry := make([]byte, 36)
+		copy(entry[4:], "mp4a")
+		binary.BigEndian.PutUint16(entry[24:], 2)
+		binary.BigEndian.PutUint16(entry[26:], 16)
+		binary.BigEndian.PutUint16(entry[32:], 44100)
+		binary.BigEndian.PutUint32(entry, 36)
+		stsd := append([]byte{0, 0, 0, 0, 0, 0, 0, 1}, entry...)
+		stco := binary.BigEndian.AppendUint32([]byte{0, 0, 0, 0, 0, 0, 0, 1}, chunkOffset)
+		stbl := box("stbl", box("stsd", stsd), box("stco", stco))
+		return box("moov", box("trak", box("mdia", box("mdhd", mdhd), box("hdlr", hdlr), box("minf", stbl))))
+	}
+
+	if moovFirst {
+		index := moov(0)
+		offset := uint32(len(ftyp) + len(index) + 8)
+		return bytes.Join([][]byte{ftyp, moov(offset), box("mdat", audio)}, nil)
+	}
+	offset := uint32(len(ftyp) + 8)
+	return bytes.Join([][]byte{ftyp, box("mdat", audio), moov(offset)}, nil)
+}
+
+func apeFooter(tagSize uint32) []byte {
+	footer := []byte("APETAGEX")
+	footer = binary.LittleEndian.AppendUint32(footer, 2000)
+	footer = binary.LittleEndian.AppendUint32(footer, tagSize)
+	footer = binary.LittleEndian.AppendUint32(footer, 0)
+	footer = binary.LittleEndian.AppendUint32(footer, 0x80000000)
+	return append(footer, make([]byte, 8)...)
+}
+
+func id3v2(size int) []byte {
+	return append([]byte{'I', 'D', '3', 3, 0, 0, byte(size >> 21 & 0x7f), byte(size >> 14 & 0x7f), byte(size >> 7 & 0x7f), byte(size & 0x7f)}, make([]byte, size)...)
+}
+
+func TestProbe(t *testing.T) {
+	flac := flacFile(20)
+	mp3 := mp3Frames(100)
+	opus := opusFile()
+	m4a := m4aFile(true)
+	m4aMoovLast := m4aFile(false)
+
+	tests := []struct {
+		name       string
+		data       []byte
+		format     string
+		codec      string
+		durationMs int64
+		truncated  bool
+		wantErr    bool
+	}{
+		{name: "flac", data: flac, format: FormatFLAC, codec: "flac", durationMs: 20 * 4096 * 1000 / 44100},
+		{name: "flac cut mid frame", data: flac[:len(flac)-50], format: FormatFLAC, codec: "flac", durationMs: 20 * 4096 * 1000 / 44100, truncated: true},
+		{name: "flac missing last frame", data: flac[:len(flac)-208], format: FormatFLAC, codec: "flac", durationMs: 20 * 4096 * 1000 / 44100, truncated: true},
+		{name: "flac cut in metadata", data: flac[:20], format: FormatFLAC, codec: "flac", truncated: true},
+		{name: "flac without streaminfo", data: []byte("fLaC\x84\x00\x00\x04abcd"), wantErr: true},
+		{name: "flac garbage after metadata", data: append(append([]byte{}, flac[:42]...), 0, 0, 0, 0), wantErr: true},
+
+		{name: "mp3", data: mp3, format: FormatMP3, codec: "mp3", durationMs: 100 * 1152 * 1000 / 44100},
+		{name: "mp3 with id3v2", data: append(id3v2(300), mp3...), format: FormatMP3, codec: "mp3", durationMs: 100 * 1152 * 1000 / 44100},
+		{name: "mp3 cut mid frame", data: mp3[:len(mp3)-100], format: FormatMP3, codec: "mp3", durationMs: 99 * 1152 * 1000 / 44100, truncated: true},
+		{name: "mp3 with ape tag", data: append(append(append([]byte{}, mp3...), make([]byte, 100)...), apeFooter(100)...), format: FormatMP3, codec: "mp3", durationMs: 100 * 1152 * 1000 / 44100},
+		{name: "mp3 ape size past start", data: append(mp3Frames(2), apeFooter(1<<31)...), format: FormatMP3, codec: "mp3", durationMs: 2 * 1152 * 1000 / 44100},
+		{name: "mp3 ape size past file", data: append(mp3Frames(2)[:834-32], apeFooter(5000)...), format: FormatMP3, codec: "mp3", durationMs: 2 * 1152 * 1000 / 44100},
+		{name: "mp3 id3v2 past end", data: id3v2(300)[:100], wantErr: true},
+		{name: "mp3 single header", data: []byte{0xff, 0xfb, 0x90, 0x00, 0, 0, 0, 0, 0, 0, 0, 0}, wantErr: true},
+
+		{name: "opus", data: opus, format: FormatOgg, codec: "opus", durationMs: 2000},
+		{name: "opus cut mid page", data: opus[:len(opus)-10], format: FormatOgg, codec: "opus", durationMs: (48000 - 312) / 48, truncated: true},
+		{name: "opus without last page", data: opus[:len(opus)-328], format: FormatOgg, codec: "opus", durationMs: (48000 - 312) / 48, truncated: true},
+		{name: "ogg cut in first page", data: opus[:30], wantErr: true},
+		{name: "ogg unknown codec", data: oggPage(0x02, 0, 0, []byte("Speex   0123456789")), wantErr: true},
+
+		{name: "m4a", data: m4a, format: FormatM4A, codec: "aac", durationMs: 3000},
+		{name: "m4a moov last", data: m4aMoovLast, format: FormatM4A, codec: "aac", durationMs: 3000},
+		{name: "m4a cut in mdat", data: m4a[:len(m4a)-200], format: FormatM4A, codec: "aac", durationMs: 3000, truncated: true},
+		{name: "m4a moov lost", data: m4aMoovLast[:len(m4aMoovLast)-20], format: FormatM4A, truncated: true},
+		{name: "m4a bad box size", data: append(box("ftyp", []byte("M4A ")), 0, 0, 0, 4, 'm', 'o', 'o', 'v'), wantErr: true},
+
+		{name: "unknown", data: []byte("RIFF\x00\x00\x00\x00WAVEfmt "), wantErr: true},
+		{name: "empty", data: nil, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := Probe(bytes.NewReader(tt.data), int64(len(tt.data)))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Probe() = %+v, want error", info)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Probe() error = %v", err)
+			}
+			if info.Format != tt.format || info.Codec != tt.codec || info.DurationMs != tt.durationMs || info.Truncated != tt.truncated {
+				t.Errorf("Probe() = %+v, want format %s codec %q duration %d truncated %v", info, tt.format, tt.codec, tt.durationMs, tt.truncated)
+			}
+		})
+	}
+}
+
+// TestProbeMalformed feeds every prefix of each sample, and copies with
+// single bytes overwritten, to Probe. Results do not matter; a panic takes
+// the server down since downloads are probed in background goroutines.
+func TestProbeMalformed(t *testing.T) {
+	samples := map[string][]byte{
+		"flac": flacFile(3),
+		"mp3":  append(append(id3v2(20), mp3Frames(3)...), apeFooter(64)...),
+		"opus": opusFile(),
+		"m4a":  m4aFile(true),
+	}
+	for name, sample := range samples {
+		for n := 0; n <= len(sample); n++ {
+			Probe(bytes.NewReader(sample[:n]), int64(n))
+		}
+		for i := range sample {
+			for _, value := range []byte{0x00, 0x7f, 0x80, 0xff} {
+				data := append([]byte{}, sample...)
+				data[i] = value
+				func() {
+					defer func() {
+						if r := recover(); r != nil {
+							t.Fatalf("%s: byte %d set to %#x: panic: %v", name, i, value, r)
+						}
+					}()
+					Probe(bytes.NewReader(data), int64(len(data)))
+				}()
+			}
+		}
+	}
+}
+
+func TestSniff(t *testing.T) {
+	tests := map[string]struct {
+		head []byte
+		want string
+	}{
+		"flac":         {flacFile(1)[:64], FormatFLAC},
+		"mp3":          {mp3Frames(1)[:64], FormatMP3},
+		"mp3 long id3": {id3v2(1000)[:64], FormatMP3},
+		"opus":         {opusFile()[:64], "opus"},
+		"m4a":          {m4aFile(true)[:64], FormatM4A},
+		"unknown":      {[]byte("<html><body>"), ""},
+	}
+	for name, tt := range tests {
+		if got := Sniff(tt.head); got != tt.want {
+			t.Errorf("%s: Sniff() = %q, want %q", name, got, tt.want)
+		}
+	}
+}
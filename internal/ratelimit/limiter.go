@@ -0,0 +1,159 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+const sweepInterval = time.Minute
+
+// Decision describes the outcome of Allow in terms suitable for the
+// X-RateLimit-* response headers.
+type Decision struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter is a keyed token bucket: every key refills at rate tokens per second
+// up to burst. A nil Limiter allows everything.
+type Limiter struct {
+	rate  float64
+	burst float64
+	limit int
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// New returns a limiter refilling rate tokens per second, or nil when rate is
+// not positive. limit is the value reported in X-RateLimit-Limit.
+func New(rate float64, burst int, limit int) *Limiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+	return &Limiter{
+		rate:    rate,
+		burst:   float64(burst),
+		limit:   limit,
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+	}
+}
+
+// PerMinute is a convenience for limits configured as requests per minute.
+func PerMinute(requests int, burst int) *Limiter {
+	return New(float64(requests)/60, burst, requests)
+}
+
+func (l *Limiter) Allow(key string) Decision {
+	if l == nil {
+		return Decision{Allowed: true}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b := l.refillLocked(key, now)
+
+	decision := Decision{Limit: l.limit}
+	if b.tokens >= 1 {
+		b.tokens--
+		decision.Allowed = true
+	} else {
+		decision.RetryAfter = l.durationFor(1 - b.tokens)
+	}
+	decision.Remaining = int(math.Floor(b.tokens))
+	decision.Reset = l.durationFor(l.burst - b.tokens)
+	return decision
+}
+
+// Wait blocks until key has a token available or ctx is done. The token is
+// reserved up front, so concurrent callers queue in arrival order, and
+// returned when ctx ends the wait.
+func (l *Limiter) Wait(ctx context.Context, key string) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := l.now()
+	b := l.refillLocked(key, now)
+	b.tokens--
+	delay := time.Duration(0)
+	if b.tokens < 0 {
+		delay = l.durationFor(-b.tokens)
+	}
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		// Give the reserved token back so an abandoned wait does not delay
+		// the callers queued behind it.
+		l.mu.Lock()
+		b := l.refillLocked(key, l.now())
+		b.tokens = math.Min(l.burst, b.tokens+1)
+		l.mu.Unlock()
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (l *Limiter) refillLocked(key string, now time.Time) *bucket {
+	if now.Sub(l.lastSweep) > sweepInterval {
+		l.sweepLocked(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+		return b
+	}
+
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(l.burst, b.tokens+elapsed*l.rate)
+		b.last = now
+	}
+	return b
+}
+
+// sweepLocked drops buckets that have refilled completely; they carry no
+// state a fresh bucket would not.
+func (l *Limiter) sweepLocked(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+func (l *Limiter) durationFor(tokens float64) time.Duration {
+	if tokens <= 0 {
+		return 0
+	}
+	return time.Duration(tokens / l.rate * float64(time.Second))
+}
@@ -0,0 +1,126 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestLimiter(rate float64, burst int) (*Limiter, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := New(rate, burst, burst)
+	l.now = clock.Now
+	return l, clock
+}
+
+func TestAllowBurstAndRefill(t *testing.T) {
+	l, clock := newTestLimiter(1, 3)
+
+	for i := 0; i < 3; i++ {
+		if d := l.Allow("ip"); !d.Allowed || d.Remaining != 2-i {
+			t.Fatalf("request %d = %+v, want allowed with %d remaining", i+1, d, 2-i)
+		}
+	}
+	d := l.Allow("ip")
+	if d.Allowed || d.RetryAfter != time.Second || d.Reset != 3*time.Second || d.Limit != 3 {
+		t.Errorf("over burst = %+v, want denied, retry after 1s, reset 3s", d)
+	}
+
+	clock.Advance(1500 * time.Millisecond)
+	if d := l.Allow("ip"); !d.Allowed {
+		t.Errorf("after refill = %+v, want allowed", d)
+	}
+	if d := l.Allow("ip"); d.Allowed || d.RetryAfter != 500*time.Millisecond {
+		t.Errorf("after using the refill = %+v, want retry after 500ms", d)
+	}
+
+	// Refill stops at burst however long the key was idle.
+	clock.Advance(time.Hour)
+	allowed := 0
+	for i := 0; i < 5; i++ {
+		if l.Allow("ip").Allowed {
+			allowed++
+		}
+	}
+	if allowed != 3 {
+		t.Errorf("%d requests allowed after a long idle, want the burst of 3", allowed)
+	}
+}
+
+func TestAllowPerKey(t *testing.T) {
+	l, _ := newTestLimiter(1, 1)
+	if !l.Allow("a").Allowed {
+		t.Fatal("first request of a denied")
+	}
+	if l.Allow("a").Allowed {
+		t.Error("a allowed past its burst")
+	}
+	if !l.Allow("b").Allowed {
+		t.Error("b denied by a's usage")
+	}
+}
+
+func TestWaitReservesToken(t *testing.T) {
+	l, _ := newTestLimiter(1000, 1)
+	l.Allow("upstream")
+
+	// The bucket is empty: Wait sleeps for the next token (1ms) and takes it.
+	if err := l.Wait(context.Background(), "upstream"); err != nil {
+		t.Fatal(err)
+	}
+	if d := l.Allow("upstream"); d.Allowed || d.RetryAfter != 2*time.Millisecond {
+		t.Errorf("after Wait = %+v, want the next token 2ms away", d)
+	}
+}
+
+func TestWaitRefundsOnCancel(t *testing.T) {
+	l, clock := newTestLimiter(1, 1)
+	l.Allow("upstream")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.Wait(ctx, "upstream"); err != context.Canceled {
+		t.Fatalf("Wait() = %v, want context.Canceled", err)
+	}
+	// Without the refund the abandoned wait would hold the next token and
+	// push this caller back to 2s.
+	if d := l.Allow("upstream"); d.Allowed || d.RetryAfter != time.Second {
+		t.Errorf("after a cancelled Wait = %+v, want retry after 1s", d)
+	}
+	clock.Advance(time.Second)
+	if !l.Allow("upstream").Allowed {
+		t.Error("token not available once refilled")
+	}
+}
+
+func TestWaitWithoutDelay(t *testing.T) {
+	l, _ := newTestLimiter(1, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// A token is available, so even a done ctx does not fail the call.
+	if err := l.Wait(ctx, "upstream"); err != nil {
+		t.Errorf("Wait() = %v with a token available", err)
+	}
+}
+
+func TestNilLimiter(t *testing.T) {
+	l := New(0, 10, 10)
+	if l != nil {
+		t.Fatal("New(0) returned a limiter")
+	}
+	if !l.Allow("ip").Allowed || l.Wait(context.Background(), "ip") != nil {
+		t.Error("nil limiter limited a request")
+	}
+}
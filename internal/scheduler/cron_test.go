@@ -0,0 +1,95 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func at(s string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestScheduleNext(t *testing.T) {
+	// 2026-01-01 is a Thursday.
+	tests := []struct {
+		spec string
+		from string
+		want string
+	}{
+		{spec: "*/15 * * * *", from: "2026-01-01 10:07", want: "2026-01-01 10:15"},
+		{spec: "*/15 * * * *", from: "2026-01-01 10:45", want: "2026-01-01 11:00"},
+		// Strictly after the current minute.
+		{spec: "0 12 * * *", from: "2026-01-01 12:00", want: "2026-01-02 12:00"},
+		{spec: "5,35 */6 * * *", from: "2026-01-01 00:36", want: "2026-01-01 06:05"},
+		{spec: "0-30/10 9-17 * * 1-5", from: "2026-01-01 09:25", want: "2026-01-01 09:30"},
+		{spec: "0-30/10 9-17 * * 1-5", from: "2026-01-02 17:45", want: "2026-01-05 09:00"},
+		{spec: "10/20 * * * *", from: "2026-01-01 00:31", want: "2026-01-01 00:50"},
+		{spec: "0 0 1 6 *", from: "2026-07-01 00:00", want: "2027-06-01 00:00"},
+		{spec: "0 0 31 * *", from: "2026-02-01 00:00", want: "2026-03-31 00:00"},
+		// 0 and 7 both mean Sunday.
+		{spec: "0 0 * * 7", from: "2026-01-01 00:00", want: "2026-01-04 00:00"},
+		{spec: "@weekly", from: "2026-01-01 00:00", want: "2026-01-04 00:00"},
+		{spec: "@monthly", from: "2026-01-01 00:00", want: "2026-02-01 00:00"},
+		// Only one day field restricted: it alone decides.
+		{spec: "0 0 15 * *", from: "2026-01-01 00:00", want: "2026-01-15 00:00"},
+		{spec: "0 0 * * 5", from: "2026-01-01 00:00", want: "2026-01-02 00:00"},
+		// Both restricted: a day matching either qualifies.
+		{spec: "0 0 1,15 * 5", from: "2026-01-01 00:00", want: "2026-01-02 00:00"},
+		{spec: "0 0 1,15 * 5", from: "2026-01-09 00:00", want: "2026-01-15 00:00"},
+		{spec: "0 0 1,15 * 5", from: "2026-01-23 00:00", want: "2026-01-30 00:00"},
+		{spec: "0 0 1,15 * 5", from: "2026-01-30 00:00", want: "2026-02-01 00:00"},
+		{spec: "0 0 29 2 *", from: "2026-01-01 00:00", want: "2028-02-29 00:00"},
+	}
+	for _, tt := range tests {
+		s, err := ParseCron(tt.spec)
+		if err != nil {
+			t.Errorf("ParseCron(%q) = %v", tt.spec, err)
+			continue
+		}
+		if got := s.Next(at(tt.from)); !got.Equal(at(tt.want)) {
+			t.Errorf("%q: Next(%s) = %s, want %s", tt.spec, tt.from, got.Format("2006-01-02 15:04 Mon"), tt.want)
+		}
+	}
+}
+
+func TestScheduleNextImpossible(t *testing.T) {
+	for _, spec := range []string{"0 0 30 2 *", "0 0 31 4,6,9,11 *"} {
+		s, err := ParseCron(spec)
+		if err != nil {
+			t.Fatalf("ParseCron(%q) = %v", spec, err)
+		}
+		if got := s.Next(at("2026-01-01 00:00")); !got.IsZero() {
+			t.Errorf("%q: Next() = %s, want the zero time", spec, got)
+		}
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 0 *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"1-x * * * *",
+		"1,,2 * * * *",
+		"@often",
+	} {
+		if _, err := ParseCron(spec); err == nil {
+			t.Errorf("ParseCron(%q) succeeded", spec)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	ErrTaskNotFound = errors.New("task not found")
+	ErrTaskRunning  = errors.New("task already running")
+)
+
+// TaskFunc performs one run of a task and returns a short human-readable
+// summary of what it did.
+type TaskFunc func(ctx context.Context) (string, error)
+
+type RunResult struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Manual     bool      `json:"manual"`
+	Result     string    `json:"result,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+type TaskStatus struct {
+	Name     string     `json:"name"`
+	Type     string     `json:"type"`
+	Schedule string     `json:"schedule"`
+	NextRun  *time.Time `json:"next_run,omitempty"`
+	Running  bool       `json:"running"`
+	LastRun  *RunResult `json:"last_run,omitempty"`
+}
+
+type task struct {
+	name     string
+	kind     string
+	spec     string
+	schedule *Schedule
+	fn       TaskFunc
+
+	running bool
+	nextRun time.Time
+	lastRun *RunResult
+}
+
+// Scheduler runs registered tasks on their cron schedules. Runs of the same
+// task never overlap; a due run is skipped while a previous one is active.
+type Scheduler struct {
+	mu    sync.Mutex
+	tasks []*task
+	ctx   context.Context
+}
+
+func New() *Scheduler {
+	return &Scheduler{ctx: context.Background()}
+}
+
+func (s *Scheduler) Add(name string, kind string, spec string, fn TaskFunc) error {
+	if name == "" {
+		return errors.New("task name empty")
+	}
+	schedule, err := ParseCron(spec)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.tasks {
+		if t.name == name {
+			return fmt.Errorf("duplicate task name %q", name)
+		}
+	}
+	s.tasks = append(s.tasks, &task{name: name, kind: kind, spec: spec, schedule: schedule, fn: fn})
+	return nil
+}
+
+// Start launches the scheduling loops; they stop when ctx is done.
+func (s *Scheduler) Start(ctx context.Context, onResult func(name string, result RunResult)) {
+	s.mu.Lock()
+	s.ctx = ctx
+	tasks := append([]*task(nil), s.tasks...)
+	s.mu.Unlock()
+
+	for _, t := range tasks {
+		go s.loop(ctx, t, onResult)
+	}
+}
+
+func (s *Scheduler) loop(ctx context.Context, t *task, onResult func(string, RunResult)) {
+	for {
+		next := t.schedule.Next(time.Now())
+		if next.IsZero() {
+			return
+		}
+		s.mu.Lock()
+		t.nextRun = next
+		s.mu.Unlock()
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		result, err := s.run(ctx, t, false)
+		if err == nil && onResult != nil {
+			onResult(t.name, *result)
+		}
+	}
+}
+
+// Run executes the named task now and waits for it to finish.
+func (s *Scheduler) Run(ctx context.Context, name string) (*RunResult, error) {
+	t, err := s.find(name)
+	if err != nil {
+		return nil, err
+	}
+	return s.run(ctx, t, true)
+}
+
+// Trigger starts the named task in the background.
+func (s *Scheduler) Trigger(name string, onResult func(name string, result RunResult)) error {
+	t, err := s.find(name)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if t.running {
+		s.mu.Unlock()
+		return ErrTaskRunning
+	}
+	ctx := s.ctx
+	s.mu.Unlock()
+
+	go func() {
+		result, err := s.run(ctx, t, true)
+		if err == nil && onResult != nil {
+			onResult(t.name, *result)
+		}
+	}()
+	return nil
+}
+
+func (s *Scheduler) Status() []TaskStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]TaskStatus, 0, len(s.tasks))
+	for _, t := range s.tasks {
+		status := TaskStatus{Name: t.name, Type: t.kind, Schedule: t.spec, Running: t.running}
+		if !t.nextRun.IsZero() {
+			next := t.nextRun
+			status.NextRun = &next
+		}
+		if t.lastRun != nil {
+			last := *t.lastRun
+			status.LastRun = &last
+		}
+		out = append(out, status)
+	}
+	return out
+}
+
+func (s *Scheduler) find(name string) (*task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.tasks {
+		if t.name == name {
+			return t, nil
+		}
+	}
+	return nil, ErrTaskNotFound
+}
+
+func (s *Scheduler) run(ctx context.Context, t *task, manual bool) (*RunResult, error) {
+	s.mu.Lock()
+	if t.running {
+		s.mu.Unlock()
+		return nil, ErrTaskRunning
+	}
+	t.running = true
+	s.mu.Unlock()
+
+	result := RunResult{StartedAt: time.Now(), Manual: manual}
+	summary, err := s.call(ctx, t)
+	result.FinishedAt = time.Now()
+	result.Result = summary
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	s.mu.Lock()
+	t.running = false
+	t.lastRun = &result
+	s.mu.Unlock()
+	return &result, nil
+}
+
+// call runs the task function, turning a panic into an error so one broken
+// task cannot take the scheduler down.
+func (s *Scheduler) call(ctx context.Context, t *task) (summary string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return t.fn(ctx)
+}
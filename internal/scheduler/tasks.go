@@ -0,0 +1,253 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"wyapi-golang/internal/config"
+	"wyapi-golang/internal/cookie"
+	"wyapi-golang/internal/cover"
+	"wyapi-golang/internal/downloader"
+	"wyapi-golang/internal/netease"
+)
+
+// Built-in task types that can be configured in scheduler.tasks.
+const (
+	TypePlaylistSnapshot = "playlist_snapshot"
+	TypeCacheWarm        = "cache_warm"
+	TypeCookieCheck      = "cookie_check"
+	TypeCleanup          = "cleanup"
+	TypeRetention        = "retention"
+	TypeReplayGain       = "replaygain"
+	TypeCoverCache       = "cover_cache"
+)
+
+// Deps are the services the built-in tasks operate on.
+type Deps struct {
+	Client     *netease.Client
+	Downloader *downloader.Downloader
+	Cookies    *cookie.Manager
+	Covers     *cover.Cache
+}
+
+// BuildTask turns one configured task into a TaskFunc.
+func BuildTask(cfg config.TaskConfig, deps Deps) (TaskFunc, error) {
+	switch cfg.Type {
+	case TypePlaylistSnapshot:
+		if len(cfg.PlaylistIDs) == 0 {
+			return nil, fmt.Errorf("task %q: playlist_ids required", cfg.Name)
+		}
+		dir := cfg.Dir
+		if dir == "" {
+			dir = "snapshots"
+		}
+		// Relative paths live under the download directory, like
+		// subscriptions and the cover cache.
+		if !filepath.IsAbs(dir) && deps.Downloader != nil {
+			dir = filepath.Join(deps.Downloader.Dir(), dir)
+		}
+		return func(ctx context.Context) (string, error) {
+			return snapshotPlaylists(ctx, deps, cfg.PlaylistIDs, dir)
+		}, nil
+	case TypeCacheWarm:
+		if len(cfg.PlaylistIDs) == 0 {
+			return nil, fmt.Errorf("task %q: playlist_ids required", cfg.Name)
+		}
+		quality := cfg.Quality
+		if quality == "" {
+			quality = "exhigh"
+		}
+		if !netease.IsValidLevel(quality) {
+			return nil, fmt.Errorf("task %q: invalid quality %q", cfg.Name, quality)
+		}
+		limit := cfg.Limit
+		if limit <= 0 {
+			limit = 20
+		}
+		return func(ctx context.Context) (string, error) {
+			return warmCache(ctx, deps, cfg.PlaylistIDs, quality, limit)
+		}, nil
+	case TypeCookieCheck:
+		return func(ctx context.Context) (string, error) {
+			return checkCookie(ctx, deps)
+		}, nil
+	case TypeCleanup:
+		maxAge := time.Duration(cfg.MaxAgeHours) * time.Hour
+		if maxAge <= 0 {
+			maxAge = 24 * time.Hour
+		}
+		return func(ctx context.Context) (string, error) {
+			return cleanupDownloads(ctx, deps, maxAge)
+		}, nil
+	case TypeRetention:
+		return func(ctx context.Context) (string, error) {
+			evicted, err := deps.Downloader.EnforceRetention()
+			var freed int64
+			for _, entry := range evicted {
+				freed += entry.Size
+			}
+			return fmt.Sprintf("evicted %d files, freed %d MB", len(evicted), freed/(1024*1024)), err
+		}, nil
+	case TypeReplayGain:
+		return func(ctx context.Context) (string, error) {
+			if !deps.Downloader.ReplayGainEnabled() {
+				return "replaygain disabled", nil
+			}
+			analyzed, retagged, err := deps.Downloader.UpdateReplayGain(ctx)
+			return fmt.Sprintf("analyzed %d tracks, retagged %d", analyzed, retagged), err
+		}, nil
+	case TypeCoverCache:
+		return func(ctx context.Context) (string, error) {
+			removed, freed, err := deps.Covers.Prune(ctx)
+			return fmt.Sprintf("removed %d covers, freed %d MB", removed, freed/(1024*1024)), err
+		}, nil
+	}
+	return nil, fmt.Errorf("task %q: unknown type %q", cfg.Name, cfg.Type)
+}
+
+// snapshotPlaylists stores the current track list of each playlist (charts
+// are playlists too) as dir/<id>/<timestamp>.json.
+func snapshotPlaylists(ctx context.Context, deps Deps, ids []int64, dir string) (string, error) {
+	cookies := deps.Cookies.Resolve(ctx)
+	stamp := time.Now().Format("20060102-150405")
+
+	saved, tracks := 0, 0
+	var errs []error
+	for _, id := range ids {
+		playlist, err := deps.Client.GetPlaylistDetail(ctx, id, cookies)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("playlist %d: %w", id, err))
+			continue
+		}
+		data, err := json.MarshalIndent(playlist, "", "  ")
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		target := filepath.Join(dir, strconv.FormatInt(id, 10))
+		if err := os.MkdirAll(target, 0755); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(target, stamp+".json"), data, 0644); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		saved++
+		tracks += len(playlist.Tracks)
+	}
+	return fmt.Sprintf("saved %d/%d playlists, %d tracks", saved, len(ids), tracks), errors.Join(errs...)
+}
+
+// warmCache downloads the first limit tracks of each playlist into the
+// download directory so later requests are served from the local library.
+func warmCache(ctx context.Context, deps Deps, ids []int64, quality string, limit int) (string, error) {
+	cookies := deps.Cookies.Resolve(ctx)
+
+	fetched, cached, failed := 0, 0, 0
+	var errs []error
+	for _, id := range ids {
+		playlist, err := deps.Client.GetPlaylistDetail(ctx, id, cookies)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("playlist %d: %w", id, err))
+			continue
+		}
+		tracks := playlist.Tracks
+		if len(tracks) > limit {
+			tracks = tracks[:limit]
+		}
+		for _, track := range tracks {
+			if ctx.Err() != nil {
+				return fmt.Sprintf("fetched %d, already cached %d, failed %d", fetched, cached, failed), ctx.Err()
+			}
+			info, err := deps.Downloader.GetMusicInfo(ctx, track.ID, quality)
+			if err != nil {
+				failed++
+				continue
+			}
+			if info.LocalPath != "" {
+				cached++
+				continue
+			}
+			if _, _, err := deps.Downloader.DownloadToFile(ctx, info, nil); err != nil {
+				failed++
+				continue
+			}
+			fetched++
+		}
+	}
+	return fmt.Sprintf("fetched %d, already cached %d, failed %d", fetched, cached, failed), errors.Join(errs...)
+}
+
+// checkCookie verifies that cookie.txt still carries a logged-in session.
+func checkCookie(ctx context.Context, deps Deps) (string, error) {
+	cookies, err := deps.Cookies.Parse()
+	if err != nil {
+		return "", err
+	}
+	if len(cookies) == 0 {
+		return "", errors.New("cookie file is empty")
+	}
+
+	account, err := deps.Client.GetAccount(ctx, cookies)
+	if err != nil {
+		return "", err
+	}
+	if !account.LoggedIn {
+		return "", netease.ErrCookieExpired
+	}
+	return fmt.Sprintf("logged in as %s (vip type %d)", account.Nickname, account.VIPType), nil
+}
+
+// cleanupDownloads removes interrupted downloads and stale temporary files
+// older than maxAge, then rescans the library so its index matches the disk.
+func cleanupDownloads(ctx context.Context, deps Deps, maxAge time.Duration) (string, error) {
+	root := deps.Downloader.Dir()
+	cutoff := time.Now().Add(-maxAge)
+
+	removed := 0
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			if os.IsNotExist(walkErr) && path == root {
+				return filepath.SkipDir
+			}
+			return walkErr
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if d.IsDir() || !(strings.HasSuffix(path, ".part") || strings.HasSuffix(path, ".tmp")) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			return nil
+		}
+		if err := os.Remove(path); err == nil {
+			removed++
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	summary := fmt.Sprintf("removed %d stale files", removed)
+	if lib := deps.Downloader.Library(); lib != nil {
+		added, dropped, err := lib.Scan()
+		if err != nil {
+			return summary, err
+		}
+		summary += fmt.Sprintf(", library +%d/-%d", added, dropped)
+	}
+	return summary, nil
+}
@@ -0,0 +1,552 @@
+package subscription
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"wyapi-golang/internal/atomicfile"
+	"wyapi-golang/internal/cookie"
+	"wyapi-golang/internal/downloader"
+	"wyapi-golang/internal/library"
+	"wyapi-golang/internal/netease"
+)
+
+// What happens to local files of tracks that left the playlist.
+const (
+	RemoveKeep    = "keep"
+	RemoveDelete  = "delete"
+	RemoveArchive = "archive"
+)
+
+const archiveDir = "_archive"
+
+var (
+	ErrNotFound       = errors.New("subscription not found")
+	ErrExists         = errors.New("subscription already exists")
+	ErrSyncInProgress = errors.New("sync already in progress")
+)
+
+// Subscription mirrors one NetEase playlist into Dir, a directory relative
+// to the download directory. Tracks maps song IDs to the files written for
+// them; Missing holds the tracks the last sync no longer found, which are
+// only removed when the next sync confirms they are gone.
+type Subscription struct {
+	PlaylistID int64            `json:"playlist_id"`
+	Name       string           `json:"name"`
+	Dir        string           `json:"dir"`
+	Quality    string           `json:"quality"`
+	OnRemove   string           `json:"on_remove"`
+	Template   string           `json:"template,omitempty"`
+	CreatedAt  time.Time        `json:"created_at"`
+	LastSyncAt *time.Time       `json:"last_sync_at,omitempty"`
+	Tracks     map[int64]string `json:"tracks"`
+	Missing    map[int64]bool   `json:"missing,omitempty"`
+}
+
+type TrackError struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name,omitempty"`
+	Error string `json:"error"`
+}
+
+type SyncResult struct {
+	PlaylistID     int64        `json:"playlist_id"`
+	Name           string       `json:"name"`
+	StartedAt      time.Time    `json:"started_at"`
+	FinishedAt     time.Time    `json:"finished_at"`
+	Total          int          `json:"total"`
+	Added          int          `json:"added"`
+	Removed        int          `json:"removed"`
+	Pending        int          `json:"pending_removal,omitempty"`
+	RemovalSkipped bool         `json:"removal_skipped,omitempty"` // reply had fewer tracks than the playlist reports
+	Failed         []TrackError `json:"failed,omitempty"`
+	Playlist       string       `json:"playlist_file,omitempty"`
+	Error          string       `json:"error,omitempty"`
+}
+
+type state struct {
+	Subscriptions []*Subscription `json:"subscriptions"`
+	History       []SyncResult    `json:"history"`
+}
+
+// playlistSource and trackDownloader are what a sync needs from the
+// NetEase client and the downloader.
+type playlistSource interface {
+	GetPlaylistDetail(ctx context.Context, playlistID int64, cookies map[string]string) (*netease.PlaylistInfo, error)
+}
+
+type trackDownloader interface {
+	Dir() string
+	Library() *library.Library
+	GetMusicInfo(ctx context.Context, songID int64, quality string) (*downloader.MusicInfo, error)
+	DownloadInto(ctx context.Context, info *downloader.MusicInfo, subdir string, tmpl *downloader.Template) (string, int64, error)
+}
+
+// Manager keeps the subscription list and sync history in a JSON file and
+// performs the syncs.
+type Manager struct {
+	path         string
+	client       playlistSource
+	downloader   trackDownloader
+	cookies      *cookie.Manager
+	historyLimit int
+
+	mu      sync.Mutex
+	subs    map[int64]*Subscription
+	history []SyncResult
+	running map[int64]bool
+}
+
+func NewManager(path string, client *netease.Client, dl *downloader.Downloader, cookies *cookie.Manager, historyLimit int) (*Manager, error) {
+	if path == "" {
+		return nil, errors.New("subscription file path empty")
+	}
+	if historyLimit <= 0 {
+		historyLimit = 100
+	}
+
+	m := &Manager{
+		path:         path,
+		client:       client,
+		downloader:   dl,
+		cookies:      cookies,
+		historyLimit: historyLimit,
+		subs:         map[int64]*Subscription{},
+		running:      map[int64]bool{},
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, nil
+		}
+		return nil, err
+	}
+
+	var st state
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, err
+	}
+	for _, sub := range st.Subscriptions {
+		if sub.Tracks == nil {
+			sub.Tracks = map[int64]string{}
+		}
+		m.subs[sub.PlaylistID] = sub
+	}
+	m.history = st.History
+	return m, nil
+}
+
+func (m *Manager) Add(sub Subscription) (*Subscription, error) {
+	if sub.PlaylistID <= 0 {
+		return nil, errors.New("invalid playlist id")
+	}
+	if sub.Quality == "" {
+		sub.Quality = "lossless"
+	}
+	if !netease.IsValidLevel(sub.Quality) {
+		return nil, fmt.Errorf("invalid quality %q", sub.Quality)
+	}
+	switch sub.OnRemove {
+	case "":
+		sub.OnRemove = RemoveKeep
+	case RemoveKeep, RemoveDelete, RemoveArchive:
+	default:
+		return nil, fmt.Errorf("invalid on_remove %q", sub.OnRemove)
+	}
+	if sub.Template != "" {
+		if _, err := downloader.ParseTemplate(sub.Template); err != nil {
+			return nil, err
+		}
+	}
+	if sub.Dir == "" {
+		sub.Dir = fmt.Sprintf("playlists/%d", sub.PlaylistID)
+	}
+	dir, err := downloader.CleanSubdir(sub.Dir)
+	if err != nil {
+		return nil, err
+	}
+	sub.Dir = dir
+	sub.CreatedAt = time.Now()
+	sub.LastSyncAt = nil
+	sub.Tracks = map[int64]string{}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.subs[sub.PlaylistID]; ok {
+		return nil, ErrExists
+	}
+	m.subs[sub.PlaylistID] = &sub
+	if err := m.saveLocked(); err != nil {
+		delete(m.subs, sub.PlaylistID)
+		return nil, err
+	}
+	stored := sub
+	return &stored, nil
+}
+
+// Remove drops the subscription; downloaded files are left in place.
+func (m *Manager) Remove(playlistID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.subs[playlistID]; !ok {
+		return ErrNotFound
+	}
+	delete(m.subs, playlistID)
+	return m.saveLocked()
+}
+
+func (m *Manager) List() []Subscription {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make([]Subscription, 0, len(m.subs))
+	for _, sub := range m.subs {
+		out = append(out, *sub)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out
+}
+
+func (m *Manager) Get(playlistID int64) (*Subscription, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sub, ok := m.subs[playlistID]
+	if !ok {
+		return nil, false
+	}
+	found := *sub
+	return &found, true
+}
+
+func (m *Manager) Syncing(playlistID int64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.running[playlistID]
+}
+
+// Owns reports whether path lies in the directory of a subscription, so
+// retention leaves mirrored tracks alone instead of evicting files the
+// next sync would download again.
+func (m *Manager) Owns(path string) bool {
+	root := m.downloader.Dir()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, sub := range m.subs {
+		inner, err := filepath.Rel(filepath.Join(root, filepath.FromSlash(sub.Dir)), path)
+		if err == nil && inner != ".." && !strings.HasPrefix(inner, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// History returns the most recent sync results first, optionally filtered
+// to one playlist.
+func (m *Manager) History(playlistID int64, limit int) []SyncResult {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := []SyncResult{}
+	for i := len(m.history) - 1; i >= 0; i-- {
+		if playlistID != 0 && m.history[i].PlaylistID != playlistID {
+			continue
+		}
+		out = append(out, m.history[i])
+		if limit > 0 && len(out) >= limit {
+			break
+		}
+	}
+	return out
+}
+
+// Run syncs every subscription right away, so folders catch up after a
+// restart, and then once per interval until ctx is done.
+func (m *Manager) Run(ctx context.Context, interval time.Duration, onResult func(SyncResult)) {
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	syncAll := func() {
+		for _, result := range m.SyncAll(ctx) {
+			if onResult != nil {
+				onResult(result)
+			}
+		}
+	}
+
+	syncAll()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			syncAll()
+		}
+	}
+}
+
+func (m *Manager) SyncAll(ctx context.Context) []SyncResult {
+	results := []SyncResult{}
+	for _, sub := range m.List() {
+		if ctx.Err() != nil {
+			break
+		}
+		// Sync already records failures in the result; a nil result means
+		// the subscription was removed or is being synced elsewhere.
+		result, _ := m.Sync(ctx, sub.PlaylistID)
+		if result == nil {
+			continue
+		}
+		results = append(results, *result)
+	}
+	return results
+}
+
+// Sync brings the local folder of one subscription in step with the
+// playlist: new tracks are downloaded, dropped ones handled per OnRemove,
+// and the M3U8 file rewritten. The result is also appended to the history.
+func (m *Manager) Sync(ctx context.Context, playlistID int64) (*SyncResult, error) {
+	m.mu.Lock()
+	current, ok := m.subs[playlistID]
+	if !ok {
+		m.mu.Unlock()
+		return nil, ErrNotFound
+	}
+	if m.running[playlistID] {
+		m.mu.Unlock()
+		return nil, ErrSyncInProgress
+	}
+	m.running[playlistID] = true
+	sub := *current
+	sub.Tracks = make(map[int64]string, len(current.Tracks))
+	for id, path := range current.Tracks {
+		sub.Tracks[id] = path
+	}
+	m.mu.Unlock()
+
+	defer func() {
+		m.mu.Lock()
+		delete(m.running, playlistID)
+		m.mu.Unlock()
+	}()
+
+	result := SyncResult{PlaylistID: playlistID, Name: sub.Name, StartedAt: time.Now()}
+	err := m.sync(ctx, &sub, &result)
+	result.FinishedAt = time.Now()
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if stored, ok := m.subs[playlistID]; ok {
+		stored.Name = sub.Name
+		stored.Tracks = sub.Tracks
+		stored.Missing = sub.Missing
+		finished := result.FinishedAt
+		stored.LastSyncAt = &finished
+	}
+	m.history = append(m.history, result)
+	if len(m.history) > m.historyLimit {
+		m.history = m.history[len(m.history)-m.historyLimit:]
+	}
+	if saveErr := m.saveLocked(); saveErr != nil && err == nil {
+		err = saveErr
+	}
+	return &result, err
+}
+
+func (m *Manager) sync(ctx context.Context, sub *Subscription, result *SyncResult) error {
+	var tmpl *downloader.Template
+	if sub.Template != "" {
+		parsed, err := downloader.ParseTemplate(sub.Template)
+		if err != nil {
+			return err
+		}
+		tmpl = parsed
+	}
+
+	playlist, err := m.client.GetPlaylistDetail(ctx, sub.PlaylistID, m.cookies.Resolve(ctx))
+	if err != nil {
+		return err
+	}
+	sub.Name = playlist.Name
+	result.Name = playlist.Name
+	result.Total = len(playlist.Tracks)
+
+	root := m.downloader.Dir()
+	wanted := make(map[int64]bool, len(playlist.Tracks))
+	for _, track := range playlist.Tracks {
+		wanted[track.ID] = true
+		if rel, ok := sub.Tracks[track.ID]; ok {
+			if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(rel))); err == nil {
+				continue
+			}
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		path, err := m.downloadTrack(ctx, sub, track.ID, tmpl)
+		if err != nil {
+			result.Failed = append(result.Failed, TrackError{ID: track.ID, Name: track.Name, Error: err.Error()})
+			continue
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			rel = path
+		}
+		sub.Tracks[track.ID] = filepath.ToSlash(rel)
+		result.Added++
+	}
+
+	// An empty or partial reply (expired cookie, privacy change, missing
+	// track details) must not empty the folder, and a track has to be gone
+	// in two syncs in a row before its file is touched.
+	if len(playlist.Tracks) == 0 || len(playlist.Tracks) < playlist.TrackCount {
+		result.RemovalSkipped = true
+	} else {
+		missing := map[int64]bool{}
+		for id, rel := range sub.Tracks {
+			if wanted[id] {
+				continue
+			}
+			if !sub.Missing[id] {
+				missing[id] = true
+				result.Pending++
+				continue
+			}
+			if err := m.removeTrack(sub, rel); err != nil {
+				missing[id] = true
+				result.Failed = append(result.Failed, TrackError{ID: id, Error: err.Error()})
+				continue
+			}
+			delete(sub.Tracks, id)
+			result.Removed++
+		}
+		sub.Missing = missing
+	}
+
+	playlistFile, err := m.writeM3U8(sub, playlist)
+	if err != nil {
+		return err
+	}
+	result.Playlist = playlistFile
+	return nil
+}
+
+func (m *Manager) downloadTrack(ctx context.Context, sub *Subscription, songID int64, tmpl *downloader.Template) (string, error) {
+	info, err := m.downloader.GetMusicInfo(ctx, songID, sub.Quality)
+	if err != nil {
+		return "", err
+	}
+	path, _, err := m.downloader.DownloadInto(ctx, info, sub.Dir, tmpl)
+	return path, err
+}
+
+func (m *Manager) removeTrack(sub *Subscription, rel string) error {
+	root := m.downloader.Dir()
+	path := filepath.Join(root, filepath.FromSlash(rel))
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	lib := m.downloader.Library()
+
+	switch sub.OnRemove {
+	case RemoveDelete:
+		if lib != nil {
+			if _, err := lib.Delete(0, path); err == nil {
+				return nil
+			}
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	case RemoveArchive:
+		subRoot := filepath.Join(root, filepath.FromSlash(sub.Dir))
+		inner, err := filepath.Rel(subRoot, path)
+		if err != nil || strings.HasPrefix(inner, "..") {
+			inner = filepath.Base(path)
+		}
+		target := filepath.Join(subRoot, archiveDir, inner)
+		if lib != nil {
+			return lib.Move(path, target)
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		return os.Rename(path, target)
+	}
+	return nil
+}
+
+// writeM3U8 writes the playlist in NetEase order with paths relative to the
+// subscription directory, so the folder can be copied to a player as is.
+func (m *Manager) writeM3U8(sub *Subscription, playlist *netease.PlaylistInfo) (string, error) {
+	root := m.downloader.Dir()
+	subRoot := filepath.Join(root, filepath.FromSlash(sub.Dir))
+	if err := os.MkdirAll(subRoot, 0755); err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#PLAYLIST:" + playlist.Name + "\n")
+	for _, track := range playlist.Tracks {
+		rel, ok := sub.Tracks[track.ID]
+		if !ok {
+			continue
+		}
+		inner, err := filepath.Rel(subRoot, filepath.Join(root, filepath.FromSlash(rel)))
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&b, "#EXTINF:%d,%s - %s\n", track.Duration/1000, track.Artists, track.Name)
+		b.WriteString(filepath.ToSlash(inner) + "\n")
+	}
+
+	name := fmt.Sprintf("%d", playlist.ID)
+	if playlist.Name != "" {
+		name = downloader.SanitizeComponent(playlist.Name, "windows")
+	}
+	path := filepath.Join(subRoot, name+".m3u8")
+	if err := atomicfile.Write(path, strings.NewReader(b.String()), 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func (m *Manager) saveLocked() error {
+	st := state{Subscriptions: make([]*Subscription, 0, len(m.subs)), History: m.history}
+	for _, sub := range m.subs {
+		st.Subscriptions = append(st.Subscriptions, sub)
+	}
+	sort.Slice(st.Subscriptions, func(i, j int) bool {
+		return st.Subscriptions[i].PlaylistID < st.Subscriptions[j].PlaylistID
+	})
+	if st.History == nil {
+		st.History = []SyncResult{}
+	}
+
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteBytes(m.path, data, 0644)
+}
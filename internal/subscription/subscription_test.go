@@ -0,0 +1,267 @@
+package subscription
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"wyapi-golang/internal/downloader"
+	"wyapi-golang/internal/library"
+	"wyapi-golang/internal/netease"
+)
+
+// fakePlaylist answers with whatever reply holds.
+type fakePlaylist struct {
+	reply *netease.PlaylistInfo
+}
+
+func (f *fakePlaylist) GetPlaylistDetail(ctx context.Context, playlistID int64, cookies map[string]string) (*netease.PlaylistInfo, error) {
+	return f.reply, nil
+}
+
+// fakeDownloader writes "<id>.mp3" into the requested folder.
+type fakeDownloader struct {
+	root   string
+	failID int64
+}
+
+func (f *fakeDownloader) Dir() string               { return f.root }
+func (f *fakeDownloader) Library() *library.Library { return nil }
+
+func (f *fakeDownloader) GetMusicInfo(ctx context.Context, songID int64, quality string) (*downloader.MusicInfo, error) {
+	if songID == f.failID {
+		return nil, errors.New("no copyright")
+	}
+	return &downloader.MusicInfo{ID: songID, Quality: quality}, nil
+}
+
+func (f *fakeDownloader) DownloadInto(ctx context.Context, info *downloader.MusicInfo, subdir string, tmpl *downloader.Template) (string, int64, error) {
+	path := filepath.Join(f.root, filepath.FromSlash(subdir), fmt.Sprintf("%d.mp3", info.ID))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", 0, err
+	}
+	return path, 3, os.WriteFile(path, []byte("mp3"), 0644)
+}
+
+func newTestManager(t *testing.T, onRemove string) (*Manager, *fakePlaylist, *fakeDownloader) {
+	t.Helper()
+	dir := t.TempDir()
+	source := &fakePlaylist{}
+	dl := &fakeDownloader{root: filepath.Join(dir, "downloads")}
+	m := &Manager{
+		path:         filepath.Join(dir, "subscriptions.json"),
+		client:       source,
+		downloader:   dl,
+		historyLimit: 100,
+		subs:         map[int64]*Subscription{},
+		running:      map[int64]bool{},
+	}
+	if _, err := m.Add(Subscription{PlaylistID: 7, OnRemove: onRemove}); err != nil {
+		t.Fatal(err)
+	}
+	return m, source, dl
+}
+
+// reply builds a playlist holding ids; count is the track count NetEase
+// reports, which a partial reply falls short of.
+func reply(count int, ids ...int64) *netease.PlaylistInfo {
+	playlist := &netease.PlaylistInfo{ID: 7, Name: "Road: Trip?", TrackCount: count}
+	for _, id := range ids {
+		playlist.Tracks = append(playlist.Tracks, netease.TrackInfo{
+			ID: id, Name: fmt.Sprintf("Song %d", id), Artists: "Artist", Duration: id * 60000,
+		})
+	}
+	return playlist
+}
+
+func syncOnce(t *testing.T, m *Manager) *SyncResult {
+	t.Helper()
+	result, err := m.Sync(context.Background(), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestSyncRemovesTracksMissingTwice(t *testing.T) {
+	m, source, dl := newTestManager(t, RemoveDelete)
+	first := filepath.Join(dl.root, "playlists", "7", "1.mp3")
+	second := filepath.Join(dl.root, "playlists", "7", "2.mp3")
+
+	source.reply = reply(2, 1, 2)
+	if r := syncOnce(t, m); r.Added != 2 || r.Total != 2 {
+		t.Fatalf("first sync = %+v, want both tracks added", r)
+	}
+
+	source.reply = reply(1, 1)
+	if r := syncOnce(t, m); r.Pending != 1 || r.Removed != 0 || r.Added != 0 {
+		t.Errorf("second sync = %+v, want track 2 pending", r)
+	}
+	if !exists(second) {
+		t.Fatal("track 2 removed after a single sync without it")
+	}
+
+	if r := syncOnce(t, m); r.Removed != 1 || r.Pending != 0 {
+		t.Errorf("third sync = %+v, want track 2 removed", r)
+	}
+	if exists(second) || !exists(first) {
+		t.Errorf("after confirmation: track 1 exists %v, track 2 exists %v", exists(first), exists(second))
+	}
+	if sub, _ := m.Get(7); len(sub.Tracks) != 1 || len(sub.Missing) != 0 {
+		t.Errorf("subscription = %+v, want only track 1 left", sub)
+	}
+}
+
+func TestSyncForgetsPendingTrackThatReturns(t *testing.T) {
+	m, source, dl := newTestManager(t, RemoveDelete)
+	second := filepath.Join(dl.root, "playlists", "7", "2.mp3")
+
+	source.reply = reply(2, 1, 2)
+	syncOnce(t, m)
+	source.reply = reply(1, 1)
+	syncOnce(t, m)
+	source.reply = reply(2, 1, 2)
+	if r := syncOnce(t, m); r.Pending != 0 || r.Added != 0 {
+		t.Errorf("sync with track 2 back = %+v, want nothing pending or added", r)
+	}
+	source.reply = reply(1, 1)
+	if r := syncOnce(t, m); r.Pending != 1 || r.Removed != 0 || !exists(second) {
+		t.Errorf("sync after the return = %+v, want track 2 pending again, not removed", r)
+	}
+}
+
+func TestSyncSkipsRemovalOnPartialReply(t *testing.T) {
+	m, source, dl := newTestManager(t, RemoveDelete)
+	second := filepath.Join(dl.root, "playlists", "7", "2.mp3")
+
+	source.reply = reply(2, 1, 2)
+	syncOnce(t, m)
+
+	for i, partial := range []*netease.PlaylistInfo{reply(2, 1), reply(2, 1), reply(0), reply(0)} {
+		source.reply = partial
+		r := syncOnce(t, m)
+		if !r.RemovalSkipped || r.Pending != 0 || r.Removed != 0 {
+			t.Errorf("partial reply %d: %+v, want removal skipped", i, r)
+		}
+	}
+	if !exists(second) {
+		t.Error("track 2 removed on the strength of partial replies")
+	}
+	if sub, _ := m.Get(7); len(sub.Tracks) != 2 {
+		t.Errorf("subscription tracks = %v, want both kept", sub.Tracks)
+	}
+}
+
+func TestSyncArchivesRemovedTracks(t *testing.T) {
+	m, source, dl := newTestManager(t, RemoveArchive)
+	second := filepath.Join(dl.root, "playlists", "7", "2.mp3")
+
+	source.reply = reply(2, 1, 2)
+	syncOnce(t, m)
+	source.reply = reply(1, 1)
+	syncOnce(t, m)
+	if r := syncOnce(t, m); r.Removed != 1 {
+		t.Errorf("sync = %+v, want track 2 archived", r)
+	}
+	if exists(second) {
+		t.Error("track 2 left in the playlist folder")
+	}
+	if !exists(filepath.Join(dl.root, "playlists", "7", archiveDir, "2.mp3")) {
+		t.Error("track 2 missing from the archive folder")
+	}
+}
+
+func TestSyncWritesM3U8(t *testing.T) {
+	m, source, dl := newTestManager(t, RemoveKeep)
+	dl.failID = 2
+
+	source.reply = reply(3, 3, 2, 1)
+	r := syncOnce(t, m)
+	if r.Added != 2 || len(r.Failed) != 1 || r.Failed[0].ID != 2 {
+		t.Errorf("sync = %+v, want tracks 1 and 3 added and 2 failed", r)
+	}
+
+	want := filepath.Join(dl.root, "playlists", "7", "Road_ Trip_.m3u8")
+	if r.Playlist != want {
+		t.Errorf("playlist file = %q, want %q", r.Playlist, want)
+	}
+	data, err := os.ReadFile(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m3u8 := "#EXTM3U\n#PLAYLIST:Road: Trip?\n" +
+		"#EXTINF:180,Artist - Song 3\n3.mp3\n" +
+		"#EXTINF:60,Artist - Song 1\n1.mp3\n"
+	if string(data) != m3u8 {
+		t.Errorf("m3u8 =\n%s\nwant\n%s", data, m3u8)
+	}
+}
+
+func writeTrack(t *testing.T, lib *library.Library, id int64, path string, age time.Duration) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lib.Add(library.Entry{ID: id, Path: path, DownloadedAt: time.Now().Add(-age)}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOwnedTracksSurviveEviction(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "downloads")
+	lib, err := library.Open(root, filepath.Join(dir, "library.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dl := downloader.NewDownloader(nil, nil, root)
+	dl.SetLibrary(lib)
+
+	m, err := NewManager(filepath.Join(dir, "subscriptions.json"), nil, dl, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Add(Subscription{PlaylistID: 7}); err != nil {
+		t.Fatal(err)
+	}
+
+	mirrored := filepath.Join(root, "playlists", "7", "Artist - Mirrored.mp3")
+	sibling := filepath.Join(root, "playlists", "70", "Artist - Sibling.mp3")
+	single := filepath.Join(root, "Artist - Single.mp3")
+	writeTrack(t, lib, 1, mirrored, 48*time.Hour)
+	writeTrack(t, lib, 2, sibling, 48*time.Hour)
+	writeTrack(t, lib, 3, single, 48*time.Hour)
+
+	evicted, err := lib.Evict(library.Policy{MaxAge: time.Hour, Keep: m.Owns}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evicted) != 2 {
+		t.Errorf("evicted %+v, want the two tracks outside the subscription", evicted)
+	}
+	if _, err := os.Stat(mirrored); err != nil {
+		t.Errorf("mirrored track was removed: %v", err)
+	}
+	if _, ok := lib.Lookup(mirrored); !ok {
+		t.Error("mirrored track dropped from the index")
+	}
+
+	if err := m.Remove(7); err != nil {
+		t.Fatal(err)
+	}
+	if m.Owns(mirrored) {
+		t.Error("Owns() still true after the subscription was removed")
+	}
+}
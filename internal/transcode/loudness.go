@@ -0,0 +1,142 @@
+package transcode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Loudness is the EBU R128 measurement of a file's first audio stream.
+type Loudness struct {
+	Integrated float64 // LUFS
+	TruePeak   float64 // dBTP; -Inf for digital silence
+}
+
+var (
+	integratedPattern = regexp.MustCompile(`I:\s+(-?[\d.]+|-inf) LUFS`)
+	truePeakPattern   = regexp.MustCompile(`Peak:\s+(-?[\d.]+|-inf) dBFS`)
+)
+
+// Measure runs the file at path through ffmpeg's ebur128 filter.
+func (t *Transcoder) Measure(ctx context.Context, path string) (*Loudness, error) {
+	output, err := t.run(ctx, "-hide_banner", "-nostats", "-i", path,
+		"-map", "0:a:0", "-filter:a", "ebur128=peak=true", "-f", "null", "-")
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the summary printed at the end is of interest.
+	if i := strings.LastIndex(output, "Summary:"); i >= 0 {
+		output = output[i:]
+	}
+	integrated := integratedPattern.FindStringSubmatch(output)
+	peak := truePeakPattern.FindStringSubmatch(output)
+	if integrated == nil || peak == nil {
+		return nil, errors.New("ffmpeg: no ebur128 summary in output")
+	}
+	loudness := &Loudness{Integrated: parseLevel(integrated[1]), TruePeak: parseLevel(peak[1])}
+	if math.IsInf(loudness.Integrated, -1) {
+		// ebur128 gates everything below -70 LUFS.
+		loudness.Integrated = -70
+	}
+	return loudness, nil
+}
+
+var tagMuxers = map[string]string{
+	".mp3":  "mp3",
+	".flac": "flac",
+	".m4a":  "ipod",
+	".mp4":  "ipod",
+	".ogg":  "ogg",
+	".opus": "opus",
+}
+
+// CanTag reports whether WriteTags supports the file type of path.
+func CanTag(path string) bool {
+	_, ok := tagMuxers[strings.ToLower(filepath.Ext(path))]
+	return ok
+}
+
+// WriteTags sets tags on the file at path without re-encoding it, keeping
+// its other tags and cover art. The file is replaced atomically.
+func (t *Transcoder) WriteTags(ctx context.Context, path string, tags map[string]string) error {
+	ext := strings.ToLower(filepath.Ext(path))
+	muxer, ok := tagMuxers[ext]
+	if !ok {
+		return fmt.Errorf("cannot tag %s files", ext)
+	}
+	// The temporary name must not look like audio to a library scan.
+	tmpPath := path + ".tags.part"
+	args := []string{"-hide_banner", "-loglevel", "error", "-y", "-i", path, "-map", "0", "-c", "copy", "-map_metadata", "0"}
+	switch muxer {
+	case "mp3":
+		args = append(args, "-id3v2_version", "3")
+	case "ipod":
+		// Without this the MP4 muxer drops keys it has no atom for.
+		args = append(args, "-movflags", "use_metadata_tags")
+	}
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		args = append(args, "-metadata", key+"="+tags[key])
+	}
+	args = append(args, "-f", muxer, tmpPath)
+
+	if _, err := t.run(ctx, args...); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// run executes ffmpeg to completion within the concurrency limit and
+// returns the tail of its log output.
+func (t *Transcoder) run(ctx context.Context, args ...string) (string, error) {
+	if !t.Available() {
+		return "", ErrUnavailable
+	}
+	select {
+	case t.slots <- struct{}{}:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	defer func() { <-t.slots }()
+
+	stderr := &tailBuffer{limit: 4096}
+	cmd := exec.CommandContext(ctx, t.binary, args...)
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("ffmpeg: %w: %s", err, lastLine(msg))
+		}
+		return "", fmt.Errorf("ffmpeg: %w", err)
+	}
+	return stderr.String(), nil
+}
+
+func parseLevel(s string) float64 {
+	if s == "-inf" {
+		return math.Inf(-1)
+	}
+	value, _ := strconv.ParseFloat(s, 64)
+	return value
+}
+
+func lastLine(s string) string {
+	return s[strings.LastIndex(s, "\n")+1:]
+}
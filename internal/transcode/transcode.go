@@ -0,0 +1,228 @@
+package transcode
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+var ErrUnavailable = errors.New("ffmpeg is not available")
+
+type codec struct {
+	encoder        string
+	muxer          string
+	extension      string
+	contentType    string
+	defaultBitrate int // kbps; 0 for lossless codecs
+	maxBitrate     int
+	// coverArt reports whether the container can carry an attached picture.
+	coverArt bool
+}
+
+var codecs = map[string]codec{
+	"mp3":  {encoder: "libmp3lame", muxer: "mp3", extension: "mp3", contentType: "audio/mpeg", defaultBitrate: 320, maxBitrate: 320, coverArt: true},
+	"opus": {encoder: "libopus", muxer: "ogg", extension: "opus", contentType: "audio/ogg", defaultBitrate: 160, maxBitrate: 512},
+	"aac":  {encoder: "aac", muxer: "ipod", extension: "m4a", contentType: "audio/mp4", defaultBitrate: 256, maxBitrate: 512},
+	"ogg":  {encoder: "libvorbis", muxer: "ogg", extension: "ogg", contentType: "audio/ogg", defaultBitrate: 320, maxBitrate: 500},
+	"flac": {encoder: "flac", muxer: "flac", extension: "flac", contentType: "audio/flac", coverArt: true},
+}
+
+// Target is the codec and bitrate (kbps) to transcode to.
+type Target struct {
+	Codec   string
+	Bitrate int
+}
+
+// ParseTarget validates a codec name (mp3, opus, aac, ogg or flac) and
+// bitrate; bitrate 0 picks the codec's default and is ignored for FLAC.
+func ParseTarget(name string, bitrate int) (Target, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "m4a" {
+		name = "aac"
+	}
+	c, ok := codecs[name]
+	if !ok {
+		return Target{}, fmt.Errorf("unsupported codec %q", name)
+	}
+	if c.defaultBitrate == 0 {
+		return Target{Codec: name}, nil
+	}
+	if bitrate == 0 {
+		bitrate = c.defaultBitrate
+	}
+	if bitrate < 32 || bitrate > c.maxBitrate {
+		return Target{}, fmt.Errorf("bitrate for %s must be between 32 and %d kbps", name, c.maxBitrate)
+	}
+	return Target{Codec: name, Bitrate: bitrate}, nil
+}
+
+func (t Target) Extension() string {
+	return codecs[t.Codec].extension
+}
+
+func (t Target) ContentType() string {
+	return codecs[t.Codec].contentType
+}
+
+func (t Target) String() string {
+	if t.Bitrate == 0 {
+		return t.Codec
+	}
+	return t.Codec + "-" + strconv.Itoa(t.Bitrate) + "k"
+}
+
+// Tags are written into the output, over whatever the source carried.
+type Tags struct {
+	Title       string
+	Artist      string
+	Album       string
+	AlbumArtist string
+	Year        int
+	Track       int
+	Disc        int
+}
+
+// Transcoder runs ffmpeg, at most limit processes at a time.
+type Transcoder struct {
+	binary string
+	slots  chan struct{}
+}
+
+func New(binary string, limit int) *Transcoder {
+	if limit <= 0 {
+		limit = 1
+	}
+	return &Transcoder{binary: binary, slots: make(chan struct{}, limit)}
+}
+
+// Available reports whether the configured binary can be found.
+func (t *Transcoder) Available() bool {
+	if t == nil || t.binary == "" {
+		return false
+	}
+	_, err := exec.LookPath(t.binary)
+	return err == nil
+}
+
+// Stream transcodes input to target and returns the encoded output as it
+// is produced. Closing the result waits for ffmpeg and reports its failure;
+// cancelling ctx kills it.
+func (t *Transcoder) Stream(ctx context.Context, input io.Reader, target Target, tags Tags) (io.ReadCloser, error) {
+	if !t.Available() {
+		return nil, ErrUnavailable
+	}
+	if _, ok := codecs[target.Codec]; !ok {
+		return nil, fmt.Errorf("unsupported codec %q", target.Codec)
+	}
+
+	select {
+	case t.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	cmd := exec.CommandContext(ctx, t.binary, Args(target, tags)...)
+	cmd.Stdin = input
+	stderr := &tailBuffer{limit: 2048}
+	cmd.Stderr = stderr
+	stdout, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		<-t.slots
+		return nil, fmt.Errorf("start ffmpeg: %w", err)
+	}
+	return &process{ReadCloser: stdout, cmd: cmd, stderr: stderr, release: func() { <-t.slots }}, nil
+}
+
+// Args builds the ffmpeg command line reading the source from stdin and
+// writing to stdout. Source tags are kept and overridden by tags.
+func Args(target Target, tags Tags) []string {
+	c := codecs[target.Codec]
+	args := []string{"-hide_banner", "-loglevel", "error", "-i", "pipe:0", "-map", "0:a:0"}
+	if c.coverArt {
+		args = append(args, "-map", "0:v:0?", "-c:v", "copy", "-disposition:v:0", "attached_pic")
+	}
+	args = append(args, "-map_metadata", "0")
+
+	for _, tag := range []struct{ key, value string }{
+		{"title", tags.Title},
+		{"artist", tags.Artist},
+		{"album", tags.Album},
+		{"album_artist", tags.AlbumArtist},
+		{"date", positive(tags.Year)},
+		{"track", positive(tags.Track)},
+		{"disc", positive(tags.Disc)},
+	} {
+		if tag.value != "" {
+			args = append(args, "-metadata", tag.key+"="+tag.value)
+		}
+	}
+
+	args = append(args, "-c:a", c.encoder)
+	if target.Bitrate > 0 {
+		args = append(args, "-b:a", strconv.Itoa(target.Bitrate)+"k")
+	}
+	switch target.Codec {
+	case "mp3":
+		args = append(args, "-id3v2_version", "3")
+	case "aac":
+		// stdout is not seekable, so the MP4 index has to come first.
+		args = append(args, "-movflags", "frag_keyframe+empty_moov")
+	}
+	return append(args, "-f", c.muxer, "pipe:1")
+}
+
+func positive(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+type process struct {
+	io.ReadCloser
+	cmd     *exec.Cmd
+	stderr  *tailBuffer
+	release func()
+	closed  bool
+}
+
+func (p *process) Close() error {
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	defer p.release()
+
+	// Drain what is left so ffmpeg is not blocked writing to a full pipe.
+	_, _ = io.Copy(io.Discard, p.ReadCloser)
+	if err := p.cmd.Wait(); err != nil {
+		if msg := strings.TrimSpace(p.stderr.String()); msg != "" {
+			return fmt.Errorf("ffmpeg: %w: %s", err, msg)
+		}
+		return fmt.Errorf("ffmpeg: %w", err)
+	}
+	return nil
+}
+
+// tailBuffer keeps the last limit bytes written, enough for ffmpeg's final
+// error message.
+type tailBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	n, _ := b.Buffer.Write(p)
+	if over := b.Buffer.Len() - b.limit; over > 0 {
+		b.Buffer.Next(over)
+	}
+	return n, nil
+}
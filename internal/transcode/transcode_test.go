@@ -0,0 +1,164 @@
+package transcode
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fakeFFmpeg returns a Transcoder running testdata/fake-ffmpeg, which copies
+// its input or, with FAKE_FFMPEG=fail, rejects it.
+func fakeFFmpeg(t *testing.T, mode string) *Transcoder {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffmpeg is a shell script")
+	}
+	binary, err := filepath.Abs(filepath.Join("testdata", "fake-ffmpeg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("FAKE_FFMPEG", mode)
+	return New(binary, 1)
+}
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		codec   string
+		bitrate int
+		want    Target
+		wantErr bool
+	}{
+		{codec: "mp3", want: Target{Codec: "mp3", Bitrate: 320}},
+		{codec: " MP3 ", bitrate: 128, want: Target{Codec: "mp3", Bitrate: 128}},
+		{codec: "m4a", want: Target{Codec: "aac", Bitrate: 256}},
+		{codec: "opus", bitrate: 512, want: Target{Codec: "opus", Bitrate: 512}},
+		{codec: "flac", bitrate: 320, want: Target{Codec: "flac"}},
+		{codec: "mp3", bitrate: 321, wantErr: true},
+		{codec: "ogg", bitrate: 31, wantErr: true},
+		{codec: "wav", wantErr: true},
+		{codec: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTarget(tt.codec, tt.bitrate)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseTarget(%q, %d) error = %v, wantErr %v", tt.codec, tt.bitrate, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseTarget(%q, %d) = %+v, want %+v", tt.codec, tt.bitrate, got, tt.want)
+		}
+	}
+}
+
+func TestTargetString(t *testing.T) {
+	if got := (Target{Codec: "opus", Bitrate: 160}).String(); got != "opus-160k" {
+		t.Errorf("String() = %q", got)
+	}
+	if got := (Target{Codec: "flac"}).String(); got != "flac" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func TestArgs(t *testing.T) {
+	tags := Tags{Title: "Song", Artist: "A/B", Album: "Album", Year: 2020, Track: 3}
+
+	mp3 := Args(Target{Codec: "mp3", Bitrate: 192}, tags)
+	for _, want := range [][]string{
+		{"-i", "pipe:0"},
+		{"-map", "0:v:0?"},
+		{"-c:a", "libmp3lame"},
+		{"-b:a", "192k"},
+		{"-metadata", "title=Song"},
+		{"-metadata", "artist=A/B"},
+		{"-metadata", "date=2020"},
+		{"-metadata", "track=3"},
+		{"-id3v2_version", "3"},
+		{"-f", "mp3", "pipe:1"},
+	} {
+		if !containsRun(mp3, want) {
+			t.Errorf("mp3 args %q lack %q", mp3, want)
+		}
+	}
+	for _, unset := range []string{"disc=", "album_artist="} {
+		for _, arg := range mp3 {
+			if strings.HasPrefix(arg, unset) {
+				t.Errorf("mp3 args set empty tag %q", arg)
+			}
+		}
+	}
+
+	aac := Args(Target{Codec: "aac", Bitrate: 256}, Tags{})
+	if !containsRun(aac, []string{"-movflags", "frag_keyframe+empty_moov"}) || !containsRun(aac, []string{"-f", "ipod"}) {
+		t.Errorf("aac args %q are not streamable MP4", aac)
+	}
+	if slices.Contains(aac, "attached_pic") {
+		t.Errorf("aac args %q copy cover art", aac)
+	}
+
+	flac := Args(Target{Codec: "flac"}, Tags{})
+	if slices.Contains(flac, "-b:a") {
+		t.Errorf("flac args %q set a bitrate", flac)
+	}
+}
+
+func containsRun(args, run []string) bool {
+	for i := 0; i+len(run) <= len(args); i++ {
+		if slices.Equal(args[i:i+len(run)], run) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestUnavailable(t *testing.T) {
+	for _, tc := range []*Transcoder{nil, New("", 1), New(filepath.Join(t.TempDir(), "missing-ffmpeg"), 1)} {
+		if tc.Available() {
+			t.Errorf("Available() = true for %+v", tc)
+		}
+		if _, err := tc.Stream(context.Background(), strings.NewReader("x"), Target{Codec: "mp3"}, Tags{}); !errors.Is(err, ErrUnavailable) {
+			t.Errorf("Stream() error = %v, want ErrUnavailable", err)
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	tc := fakeFFmpeg(t, "")
+	output, err := tc.Stream(context.Background(), strings.NewReader("audio"), Target{Codec: "mp3", Bitrate: 320}, Tags{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := output.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+	if string(data) != "audio" {
+		t.Errorf("output = %q", data)
+	}
+}
+
+func TestStreamFailure(t *testing.T) {
+	tc := fakeFFmpeg(t, "fail")
+	output, err := tc.Stream(context.Background(), strings.NewReader("not audio"), Target{Codec: "mp3", Bitrate: 320}, Tags{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := io.ReadAll(output); len(data) != 0 {
+		t.Errorf("output = %q, want nothing", data)
+	}
+	err = output.Close()
+	if err == nil || !strings.Contains(err.Error(), "Invalid data found") {
+		t.Errorf("Close() = %v, want ffmpeg's message", err)
+	}
+	// The slot is released, so another run does not block.
+	if _, err := tc.run(context.Background(), "-version"); err == nil {
+		t.Errorf("run() succeeded in fail mode")
+	}
+}
@@ -6,12 +6,13 @@ import (
 )
 
 type APIResponse struct {
-	Code    int         `json:"code"`
-	Status  int         `json:"status"`
-	Success bool        `json:"success"`
-	Msg     string      `json:"msg,omitempty"`
-	Message string      `json:"message,omitempty"`
-	Data    interface{} `json:"data,omitempty"`
+	Code      int         `json:"code"`
+	Status    int         `json:"status"`
+	Success   bool        `json:"success"`
+	Msg       string      `json:"msg,omitempty"`
+	Message   string      `json:"message,omitempty"`
+	ErrorCode string      `json:"error_code,omitempty"`
+	Data      interface{} `json:"data,omitempty"`
 }
 
 func Write(w http.ResponseWriter, httpStatus int, code int, msg string, data interface{}) {
@@ -39,3 +40,23 @@ func Error(w http.ResponseWriter, httpStatus int, msg string) {
 	}
 	Write(w, httpStatus, httpStatus, msg, nil)
 }
+
+// ErrorWithCode writes an error response carrying a machine-readable
+// error_code alongside the human-readable message.
+func ErrorWithCode(w http.ResponseWriter, httpStatus int, errorCode string, msg string) {
+	if httpStatus == 0 {
+		httpStatus = http.StatusBadRequest
+	}
+	resp := APIResponse{
+		Code:      httpStatus,
+		Status:    httpStatus,
+		Success:   false,
+		Msg:       msg,
+		Message:   msg,
+		ErrorCode: errorCode,
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(httpStatus)
+	_ = json.NewEncoder(w).Encode(resp)
+}